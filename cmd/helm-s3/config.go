@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+const configDesc = `This command shows or changes settings of the repository.
+
+'helm s3 config' takes one argument:
+- REPO - target repository.
+
+Without flags, the command prints the settings of the repository. With flags,
+it changes the corresponding settings, keeping the others as they are, and
+prints the result. Boolean settings are turned off with '=false', e.g.
+'--immutable=false'.
+
+The settings are stored in the 'index.yaml.settings' file next to the index
+and are shared by all users of the repository. They are initially set by
+'helm s3 init', see its help for the meaning of each setting.
+
+[Layout]
+
+Changing the layout does not move existing charts: it affects only where new
+charts are pushed and which charts reindex looks for.
+
+[Index history]
+
+Lowering --index-generations removes the backup objects beyond the new number
+on the next index update. Setting it to 0 stops keeping new generations, but does
+not remove the existing ones.
+`
+
+const configExample = `  helm s3 config my-repo - prints settings of the repository with name 'my-repo'.
+
+  helm s3 config --immutable my-repo - makes the repository immutable.
+
+  helm s3 config --require-provenance --allowed-signer 6A2B7A4C... my-repo - makes the repository accept only charts signed with the key.`
+
+// configFlags are names of the flags that change the settings.
+var configFlags = []string{"layout", "immutable", "require-provenance", "allowed-signer", "index-generations"}
+
+func newConfigCommand(opts *options) *cobra.Command {
+	act := &configAction{
+		printer:           nil,
+		acl:               "",
+		lock:              lockOptions{},
+		output:            outputText,
+		repoName:          "",
+		layout:            "",
+		immutable:         false,
+		requireProvenance: false,
+		allowedSigners:    nil,
+		indexGenerations:  0,
+		changed:           nil,
+	}
+
+	cmd := &cobra.Command{
+		Use:     "config REPO",
+		Short:   "Show or change settings of the repository.",
+		Long:    configDesc,
+		Example: configExample,
+		Args:    wrapPositionalArgsBadUsage(cobra.ExactArgs(1)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// No completions for the REPO argument.
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			act.printer = cmd
+			act.acl = opts.acl
+			act.lock = opts.lock
+			act.output = opts.output
+			act.repoName = args[0]
+			act.changed = make(map[string]bool)
+			for _, name := range configFlags {
+				if cmd.Flags().Changed(name) {
+					act.changed[name] = true
+				}
+			}
+			return act.run(cmd.Context())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&act.layout, "layout", act.layout, "Layout of the repository: flat or nested.")
+	flags.BoolVar(&act.immutable, "immutable", act.immutable, "Forbid replacing published chart versions.")
+	flags.BoolVar(&act.requireProvenance, "require-provenance", act.requireProvenance, "Require charts pushed to the repository to have a provenance file that verifies.")
+	flags.StringSliceVar(&act.allowedSigners, "allowed-signer", act.allowedSigners, "Fingerprint of the key charts pushed to the repository may be signed with. Can be repeated. Replaces the current list.")
+	flags.IntVar(&act.indexGenerations, "index-generations", act.indexGenerations, "Number of previous generations of the index to keep on every index update.")
+
+	return cmd
+}
+
+type configAction struct {
+	printer printer
+
+	// global flags
+
+	acl    string
+	lock   lockOptions
+	output string
+
+	// args
+
+	repoName string
+
+	// flags
+
+	layout            string
+	immutable         bool
+	requireProvenance bool
+	allowedSigners    []string
+	indexGenerations  int
+
+	// changed are names of the flags set explicitly.
+	changed map[string]bool
+}
+
+func (act *configAction) run(ctx context.Context) error {
+	if err := validateOutput(act.output, outputText, outputJSON, outputYAML); err != nil {
+		return err
+	}
+	if err := validateLayout(act.layout); err != nil {
+		return err
+	}
+	if err := validateIndexGenerations(act.indexGenerations); err != nil {
+		return err
+	}
+	allowedSigners, err := normalizeFingerprints(act.allowedSigners)
+	if err != nil {
+		return err
+	}
+
+	repoEntry, err := helmutil.LookupRepoEntry(act.repoName)
+	if err != nil {
+		return err
+	}
+
+	store, err := storage.New(repoEntry.URL())
+	if err != nil {
+		return err
+	}
+
+	var settings repoSettings
+	if len(act.changed) == 0 {
+		settings, err = fetchRepoSettings(ctx, store, repoEntry.URL())
+		if err != nil {
+			return err
+		}
+	} else {
+		settings, err = act.update(ctx, store, repoEntry.URL(), allowedSigners)
+		if err != nil {
+			return err
+		}
+	}
+
+	settings.Layout = resolveLayout("", settings)
+	if act.output == outputJSON || act.output == outputYAML {
+		return printStructured(act.printer, act.output, settings)
+	}
+
+	act.printSettings(settings)
+	return nil
+}
+
+// update applies the changed settings to the repository settings under
+// the repository lock, returning the updated settings.
+func (act *configAction) update(ctx context.Context, store storage.Storage, repoURL string, allowedSigners []string) (repoSettings, error) {
+	ctx, unlock, err := lockRepo(ctx, store, repoURL, act.acl, act.lock, act.printer)
+	if err != nil {
+		return repoSettings{}, err
+	}
+	defer unlock()
+
+	settings, cond, err := fetchRepoSettingsWithCond(ctx, store, repoURL)
+	if err != nil {
+		return repoSettings{}, err
+	}
+
+	if act.changed["layout"] {
+		settings.Layout = act.layout
+	}
+	if act.changed["immutable"] {
+		settings.Immutable = act.immutable
+	}
+	if act.changed["require-provenance"] {
+		settings.RequireProvenance = act.requireProvenance
+	}
+	if act.changed["allowed-signer"] {
+		settings.AllowedSigners = allowedSigners
+	}
+	if act.changed["index-generations"] {
+		settings.IndexGenerations = act.indexGenerations
+	}
+	if len(settings.AllowedSigners) > 0 && !settings.RequireProvenance {
+		return repoSettings{}, newBadUsageError(errors.New("allowed signers require the provenance policy, set --require-provenance"))
+	}
+
+	if err := putRepoSettings(ctx, store, repoURL, act.acl, settings, cond); err != nil {
+		if errors.Is(err, storage.ErrPreconditionFailed) {
+			return repoSettings{}, errors.WithMessage(err, "the repository settings were modified concurrently, try again")
+		}
+		return repoSettings{}, err
+	}
+
+	return settings, nil
+}
+
+func (act *configAction) printSettings(settings repoSettings) {
+	signers := "-"
+	if len(settings.AllowedSigners) > 0 {
+		signers = strings.Join(settings.AllowedSigners, ",")
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "layout\t%s\n", settings.Layout)
+	fmt.Fprintf(w, "immutable\t%t\n", settings.Immutable)
+	fmt.Fprintf(w, "require-provenance\t%t\n", settings.RequireProvenance)
+	fmt.Fprintf(w, "allowed-signers\t%s\n", signers)
+	fmt.Fprintf(w, "index-generations\t%d\n", settings.IndexGenerations)
+	_ = w.Flush()
+
+	act.printer.Printf("%s", b.String())
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig(t *testing.T) {
+	env := newTestEnv(t)
+	env.mustRun("init", "--layout", "nested", env.repoURL)
+	env.addRepo()
+
+	out := env.mustRun("config", testRepoName)
+	assert.Regexp(t, `layout +nested\n`, out)
+	assert.Regexp(t, `immutable +false\n`, out)
+
+	out = env.mustRun("config", "--immutable", testRepoName)
+	assert.Regexp(t, `immutable +true\n`, out)
+
+	stdout, _, err := env.run("config", "--require-provenance", "--allowed-signer", "6a2b7a4c", "--output", "json", testRepoName)
+	require.NoError(t, err)
+	var settings repoSettings
+	require.NoError(t, json.Unmarshal([]byte(stdout), &settings))
+	assert.Equal(t, repoSettings{
+		Layout:            layoutNested,
+		Immutable:         true,
+		RequireProvenance: true,
+		AllowedSigners:    []string{"6A2B7A4C"},
+	}, settings)
+
+	t.Run("should turn off settings", func(t *testing.T) {
+		out := env.mustRun("config", "--immutable=false", testRepoName)
+		assert.Regexp(t, `immutable +false\n`, out)
+		assert.Regexp(t, `layout +nested\n`, out, "other settings must be kept")
+	})
+
+	t.Run("should require provenance policy for allowed signers", func(t *testing.T) {
+		_, _, err := env.run("config", "--require-provenance=false", testRepoName)
+		require.ErrorContains(t, err, "allowed signers require the provenance policy")
+	})
+}
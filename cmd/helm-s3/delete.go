@@ -1,15 +1,19 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"fmt"
+	"io"
 	"strings"
+	"time"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
-	"github.com/hypnoglow/helm-s3/internal/awss3"
-	"github.com/hypnoglow/helm-s3/internal/awsutil"
 	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
 )
 
 const deleteDesc = `This command removes one or more versions of a chart from the repository.
@@ -21,9 +25,32 @@ const deleteDesc = `This command removes one or more versions of a chart from th
 Use --version once per version, or a comma-separated list, to remove several versions in a
 single run (one index fetch and one index upload).
 
+[Deleting many versions]
+
+Use --all to remove all versions of the chart, or --range to remove versions
+matching the semver constraint, e.g. '>=1.0.0 <2.0.0'. Like in helm,
+pre-release versions match only constraints with a pre-release, e.g.
+'>=1.0.0-0'. The versions are resolved from the index and printed, and
+the deletion must be confirmed, unless --yes is set.
+
+When no versions of the chart remain, the chart is removed from the index.
+
 [Provenance]
 
 If the chart is signed, the provenance file is removed from the repository as well.
+
+[Soft delete]
+
+With --soft, the chart files are moved to the '.trash/' directory of the
+repository instead of being removed, and the removed index entries are
+recorded in '.trash/index.json'. Use 'helm s3 undelete' to restore a chart
+version, and 'helm s3 trash purge' to remove charts from the trash for good.
+
+[Immutability]
+
+If the repository is immutable (see 'helm s3 init --immutable'), deleting
+charts requires --allow-immutable-delete, as a deleted chart version could be
+pushed again with different content.
 `
 
 const deleteExample = `  helm s3 delete epicservice --version 0.5.1 my-repo
@@ -33,14 +60,33 @@ const deleteExample = `  helm s3 delete epicservice --version 0.5.1 my-repo
   - removes both versions in one operation.
 
   helm s3 delete epicservice --version 0.5.1,0.5.2 my-repo
-  - same as repeating --version for each value.`
+  - same as repeating --version for each value.
+
+  helm s3 delete epicservice --range '>=0.5.0 <0.6.0' my-repo
+  - removes all 0.5.x versions of epicservice after confirmation.
+
+  helm s3 delete epicservice --all --yes my-repo
+  - removes all versions of epicservice without confirmation.
+
+  helm s3 delete epicservice --version 0.5.1 --soft my-repo
+  - moves version 0.5.1 of epicservice to the trash.`
 
 func newDeleteCommand(opts *options) *cobra.Command {
 	act := &deleteAction{
-		printer:   nil,
-		acl:       "",
-		chartName: "",
-		repoName:  "",
+		printer:              nil,
+		result:               &commandResult{Command: "delete"},
+		acl:                  "",
+		maxIndexRetries:      0,
+		lock:                 lockOptions{},
+		chartName:            "",
+		repoName:             "",
+		versions:             nil,
+		all:                  false,
+		versionRange:         "",
+		yes:                  false,
+		allowImmutableDelete: false,
+		soft:                 false,
+		stdin:                nil,
 	}
 
 	cmd := &cobra.Command{
@@ -55,27 +101,41 @@ func newDeleteCommand(opts *options) *cobra.Command {
 			return nil, cobra.ShellCompDirectiveNoFileComp
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			act.printer = cmd
+			if err := validateOutput(opts.output, outputText, outputJSON); err != nil {
+				return err
+			}
+			act.printer = newPrinter(cmd, opts.output)
+			act.stdin = cmd.InOrStdin()
 			act.acl = opts.acl
+			act.maxIndexRetries = opts.maxIndexRetries
+			act.lock = opts.lock
 			act.chartName = args[0]
 			act.repoName = args[1]
-			return act.run(cmd.Context())
+			act.result.Repo = act.repoName
+			return reportResult(cmd, opts.output, act.result, act.run(cmd.Context()))
 		},
 	}
 
 	flags := cmd.Flags()
 	flags.StringSliceVar(&act.versions, "version", nil, "Version(s) of the chart to delete. Repeat the flag or use comma-separated values.")
-	_ = cobra.MarkFlagRequired(flags, "version")
+	flags.BoolVar(&act.all, "all", act.all, "Delete all versions of the chart.")
+	flags.StringVar(&act.versionRange, "range", act.versionRange, "Delete versions of the chart matching the semver constraint.")
+	flags.BoolVarP(&act.yes, "yes", "y", act.yes, "Do not ask for confirmation when deleting versions selected by --all or --range.")
+	flags.BoolVar(&act.allowImmutableDelete, "allow-immutable-delete", act.allowImmutableDelete, "Allow deleting charts from an immutable repository.")
+	flags.BoolVar(&act.soft, "soft", act.soft, "Move the chart files to the trash instead of removing them, so that they can be restored with 'helm s3 undelete'.")
 
 	return cmd
 }
 
 type deleteAction struct {
 	printer printer
+	result  *commandResult
 
 	// global flags
 
-	acl string
+	acl             string
+	maxIndexRetries int
+	lock            lockOptions
 
 	// args
 
@@ -84,12 +144,39 @@ type deleteAction struct {
 
 	// flags
 
-	versions []string
+	versions             []string
+	all                  bool
+	versionRange         string
+	yes                  bool
+	allowImmutableDelete bool
+	soft                 bool
+
+	// stdin is where the confirmation is read from.
+	stdin io.Reader
 }
 
 func (act *deleteAction) run(ctx context.Context) error {
+	selectors := 0
+	for _, set := range []bool{len(act.versions) > 0, act.all, act.versionRange != ""} {
+		if set {
+			selectors++
+		}
+	}
+	if selectors != 1 {
+		return newBadUsageError(errors.New("exactly one of --version, --all and --range flags is required"))
+	}
+
+	var constraint *semver.Constraints
+	if act.versionRange != "" {
+		c, err := semver.NewConstraint(act.versionRange)
+		if err != nil {
+			return newBadUsageError(fmt.Errorf("invalid --range constraint %q: %v", act.versionRange, err))
+		}
+		constraint = c
+	}
+
 	versions := expandVersions(act.versions)
-	if len(versions) == 0 {
+	if len(act.versions) > 0 && len(versions) == 0 {
 		return errors.New("at least one non-empty --version is required")
 	}
 
@@ -98,50 +185,95 @@ func (act *deleteAction) run(ctx context.Context) error {
 		return err
 	}
 
-	sess, err := awsutil.Session(awsutil.DynamicBucketRegion(repoEntry.URL()))
+	act.result.RepoURL = repoEntry.URL()
+
+	store, err := storage.New(repoEntry.URL())
 	if err != nil {
 		return err
 	}
-	storage := awss3.New(sess)
 
-	// Fetch current index.
-	b, err := storage.FetchRaw(ctx, repoEntry.IndexURL())
+	settings, err := fetchRepoSettings(ctx, store, repoEntry.URL())
 	if err != nil {
-		return errors.WithMessage(err, "fetch current repo index")
+		return err
 	}
-
-	idx := helmutil.NewIndex()
-	if err := idx.UnmarshalBinary(b); err != nil {
-		return errors.WithMessage(err, "load index from downloaded file")
+	if settings.Immutable && !act.allowImmutableDelete {
+		return withErrorCode(errorCodeImmutable, errors.New("the repository is immutable, set --allow-immutable-delete to delete charts anyway"))
 	}
 
-	// Apply deletions in-memory; collect URLs to delete from S3 later.
-	urls := make([]string, 0, len(versions))
-	for _, ver := range versions {
-		url, err := idx.Delete(act.chartName, ver)
+	if act.all || constraint != nil {
+		versions, err = act.resolveVersions(ctx, store, repoEntry, constraint)
 		if err != nil {
 			return err
 		}
-
-		if url != "" {
-			// For relative URLs we need to prepend base URL.
-			if !strings.HasPrefix(url, repoEntry.URL()) {
-				url = strings.TrimSuffix(repoEntry.URL(), "/") + "/" + url
-			}
-			urls = append(urls, url)
+		if err := act.confirm(versions); err != nil {
+			return err
 		}
 	}
 
-	idx.UpdateGeneratedTime()
-
-	idxReader, err := idx.Reader()
+	ctx, unlock, err := lockRepo(ctx, store, repoEntry.URL(), act.acl, act.lock, act.printer)
 	if err != nil {
-		return errors.Wrap(err, "get index reader")
+		return err
 	}
+	defer unlock()
+
+	// With --soft, the charts are copied to the trash and recorded in the
+	// trash index before the index is updated, so that a chart version never
+	// disappears from the index without a trash record to undelete it from.
+	var trashed []trashEntry
+	if act.soft {
+		trashed, err = act.moveToTrash(ctx, store, repoEntry, versions)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Apply deletions to the fetched index; collect URLs to delete from S3 later.
+	// The index update may be retried if the index was modified concurrently,
+	// so URLs are collected from scratch on each attempt.
+	// The updated index is uploaded first to keep the repo consistent on failure.
+	var urls []string
+	idx, err := updateIndex(ctx, store, repoEntry, act.acl, act.maxIndexRetries, act.printer, func(idx helmutil.Index) error {
+		urls = make([]string, 0, len(versions))
+		act.result.Charts = make([]chartResult, 0, len(versions))
+		entries := make(map[string]helmutil.IndexEntry)
+		for _, entry := range idx.Entries() {
+			if entry.Name == act.chartName {
+				entries[entry.Version] = entry
+			}
+		}
+		for _, entry := range trashed {
+			if current, ok := entries[entry.Version]; ok && current.Digest != entry.Digest {
+				return withErrorCode(errorCodeIndexConflict, fmt.Errorf("chart %s version %s was replaced concurrently, delete it again", entry.Name, entry.Version))
+			}
+		}
+		for _, ver := range versions {
+			url, err := idx.Delete(act.chartName, ver)
+			if err != nil {
+				return withErrorCode(errorCodeChartNotFound, err)
+			}
 
-	// Upload the updated index first to keep the repo consistent on failure.
-	if err := storage.PutIndex(ctx, repoEntry.URL(), act.acl, idxReader); err != nil {
-		return errors.WithMessage(err, "upload new index to s3")
+			if url != "" {
+				url = absoluteChartURL(repoEntry.URL(), url)
+				urls = append(urls, url)
+			}
+			act.result.Charts = append(act.result.Charts, chartResult{
+				Name:    act.chartName,
+				Version: ver,
+				URL:     url,
+				Digest:  entries[ver].Digest,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		if act.soft {
+			// The chart versions are still in the index, so their trash
+			// records must not be left behind.
+			if err := discardTrashEntries(ctx, store, repoEntry.URL(), act.acl, act.maxIndexRetries, trashed); err != nil {
+				act.printer.PrintErrf("[WARNING] failed to remove the chart from the trash: %s\n", err)
+			}
+		}
+		return err
 	}
 
 	if err := idx.WriteFile(repoEntry.CacheFile(), helmutil.DefaultIndexFilePerm); err != nil {
@@ -150,7 +282,7 @@ func (act *deleteAction) run(ctx context.Context) error {
 
 	// Delete .tgz objects last; a failure here leaves orphans, not broken links.
 	for _, url := range urls {
-		if err := storage.DeleteChart(ctx, url); err != nil {
+		if err := store.DeleteChart(ctx, url); err != nil {
 			return errors.WithMessage(err, "delete chart file from s3")
 		}
 	}
@@ -160,9 +292,105 @@ func (act *deleteAction) run(ctx context.Context) error {
 	} else {
 		act.printer.Printf("Successfully deleted %d chart versions from the repository.\n", len(versions))
 	}
+	if act.soft {
+		act.printer.Printf("The chart files were moved to the trash, use 'helm s3 undelete' to restore them.\n")
+	}
 	return nil
 }
 
+// moveToTrash copies the charts of the versions to the trash and records
+// their index entries in the trash index. The versions are left in the index.
+func (act *deleteAction) moveToTrash(ctx context.Context, store storage.Storage, repoEntry helmutil.RepoEntry, versions []string) ([]trashEntry, error) {
+	idx, _, err := fetchIndex(ctx, store, repoEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]helmutil.IndexEntry)
+	for _, entry := range idx.Entries() {
+		if entry.Name == act.chartName {
+			entries[entry.Version] = entry
+		}
+	}
+
+	deletedAt := time.Now()
+	trashed := make([]trashEntry, 0, len(versions))
+	for _, ver := range versions {
+		entry, ok := entries[ver]
+		if !ok {
+			return nil, withErrorCode(errorCodeChartNotFound, fmt.Errorf("chart %s version %s not found in index", act.chartName, ver))
+		}
+		marshaled, err := idx.MarshalEntry(act.chartName, ver)
+		if err != nil {
+			return nil, err
+		}
+		trashed = append(trashed, newTrashEntry(repoEntry.URL(), entry, marshaled, deletedAt))
+	}
+
+	if err := moveToTrash(ctx, store, repoEntry.URL(), act.acl, act.maxIndexRetries, trashed); err != nil {
+		return nil, err
+	}
+	return trashed, nil
+}
+
+// resolveVersions returns versions of the chart in the index matching
+// the constraint, or all versions if the constraint is nil.
+func (act *deleteAction) resolveVersions(ctx context.Context, store storage.Storage, repoEntry helmutil.RepoEntry, constraint *semver.Constraints) ([]string, error) {
+	idx, _, err := fetchIndex(ctx, store, repoEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	found := false
+	for _, entry := range idx.Entries() {
+		if entry.Name != act.chartName {
+			continue
+		}
+		found = true
+		if constraint != nil && !matchesConstraint(constraint, entry.Version) {
+			continue
+		}
+		versions = append(versions, entry.Version)
+	}
+
+	if !found {
+		return nil, withErrorCode(errorCodeChartNotFound, fmt.Errorf("chart %s not found in the repository %s", act.chartName, act.repoName))
+	}
+	if len(versions) == 0 {
+		return nil, withErrorCode(errorCodeChartNotFound, fmt.Errorf("no versions of chart %s match %q", act.chartName, act.versionRange))
+	}
+	return versions, nil
+}
+
+// confirm prints the versions to delete and asks for confirmation,
+// unless --yes is set.
+func (act *deleteAction) confirm(versions []string) error {
+	act.printer.Printf(
+		"The following versions of chart %s will be deleted from the repository:\n\n  %s\n\n",
+		act.chartName, strings.Join(versions, "\n  "),
+	)
+	if act.yes {
+		return nil
+	}
+
+	if len(versions) == 1 {
+		act.printer.Printf("Delete the version? [y/N]: ")
+	} else {
+		act.printer.Printf("Delete %d versions? [y/N]: ", len(versions))
+	}
+	answer, err := bufio.NewReader(act.stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return errors.Wrap(err, "read confirmation")
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	default:
+		return errors.New("deletion is not confirmed, nothing was deleted; use --yes to skip confirmation")
+	}
+}
+
 // expandVersions flattens comma-separated entries, trims space, drops empties, and dedupes
 // while preserving first-seen order.
 func expandVersions(in []string) []string {
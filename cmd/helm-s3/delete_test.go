@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hypnoglow/helm-s3/internal/storage/storagetest"
+)
+
+func TestDelete(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+	env.mustRun("push", env.chart("foo", "1.1.0"), testRepoName)
+
+	out := env.mustRun("delete", "foo", "--version", "1.0.0", testRepoName)
+	assert.Contains(t, out, "Successfully deleted the chart from the repository.")
+
+	_, ok := env.store.Get(env.repoURL + "/foo-1.0.0.tgz")
+	assert.False(t, ok)
+	_, ok = env.store.Get(env.repoURL + "/foo-1.1.0.tgz")
+	assert.True(t, ok)
+
+	idx := env.index()
+	require.Len(t, idx.Entries["foo"], 1)
+	assert.Equal(t, "1.1.0", idx.Entries["foo"][0].Version)
+}
+
+func TestDelete_UpdatesIndexBeforeDeletingChart(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+
+	env.store.FailOn(storagetest.OpDeleteChart, errors.New("access denied"))
+
+	_, _, err := env.run("delete", "foo", "--version", "1.0.0", testRepoName)
+	require.ErrorContains(t, err, "access denied")
+
+	// The index must not reference the chart, even though the chart object
+	// was not deleted: an orphan object is better than a broken link.
+	assert.Empty(t, env.index().Entries["foo"])
+	_, ok := env.store.Get(env.repoURL + "/foo-1.0.0.tgz")
+	assert.True(t, ok)
+
+	var ops []storagetest.Op
+	for _, call := range env.store.Calls() {
+		if call.Op == storagetest.OpPutIndex || call.Op == storagetest.OpDeleteChart {
+			ops = append(ops, call.Op)
+		}
+	}
+	// init, push, delete.
+	assert.Equal(t, []storagetest.Op{
+		storagetest.OpPutIndex,
+		storagetest.OpPutIndex,
+		storagetest.OpPutIndex,
+		storagetest.OpDeleteChart,
+	}, ops)
+}
+
+func TestDelete_OutputJSON(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+	digest := env.index().Entries["foo"][0].Digest
+
+	stdout, stderr, err := env.run("delete", "-o", "json", "foo", "--version", "1.0.0", testRepoName)
+	require.NoError(t, err, stderr)
+
+	var res commandResult
+	require.NoError(t, json.Unmarshal([]byte(stdout), &res))
+	assert.Equal(t, "delete", res.Command)
+	assert.Equal(t, []chartResult{{
+		Name:    "foo",
+		Version: "1.0.0",
+		URL:     env.repoURL + "/foo-1.0.0.tgz",
+		Digest:  digest,
+	}}, res.Charts)
+
+	t.Run("should report missing version", func(t *testing.T) {
+		stdout, _, err := env.run("delete", "-o", "json", "foo", "--version", "1.0.0", testRepoName)
+		require.Error(t, err)
+
+		var res commandResult
+		require.NoError(t, json.Unmarshal([]byte(stdout), &res))
+		require.NotNil(t, res.Error)
+		assert.Equal(t, errorCodeChartNotFound, res.Error.Code)
+	})
+}
+
+func TestDelete_Immutable(t *testing.T) {
+	env := newTestEnv(t)
+	env.mustRun("init", "--immutable", env.repoURL)
+	env.addRepo()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+
+	_, _, err := env.run("delete", "foo", "--version", "1.0.0", testRepoName)
+	require.ErrorContains(t, err, "--allow-immutable-delete")
+	assert.Equal(t, errorCodeImmutable, errorCode(err))
+	assert.Len(t, env.index().Entries["foo"], 1)
+
+	env.mustRun("delete", "foo", "--version", "1.0.0", "--allow-immutable-delete", testRepoName)
+	assert.Empty(t, env.index().Entries["foo"])
+}
+
+func TestDelete_All(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	env.mustRun("push", env.chart("foo", "1.0.0"), env.chart("foo", "1.1.0"), env.chart("bar", "1.0.0"), testRepoName)
+
+	t.Run("should not delete without confirmation", func(t *testing.T) {
+		stdout, _, err := env.runWithInput(strings.NewReader("n\n"), "delete", "foo", "--all", testRepoName)
+		require.ErrorContains(t, err, "deletion is not confirmed")
+		assert.Contains(t, stdout, "The following versions of chart foo will be deleted from the repository:\n\n  1.1.0\n  1.0.0\n")
+		assert.Contains(t, stdout, "Delete 2 versions? [y/N]: ")
+		assert.Len(t, env.index().Entries["foo"], 2)
+	})
+
+	_, _, err := env.runWithInput(strings.NewReader("y\n"), "delete", "foo", "--all", testRepoName)
+	require.NoError(t, err)
+
+	idx := env.index()
+	assert.NotContains(t, idx.Entries, "foo", "the chart must be removed from the index")
+	assert.Len(t, idx.Entries["bar"], 1)
+	_, ok := env.store.Get(env.repoURL + "/foo-1.0.0.tgz")
+	assert.False(t, ok)
+}
+
+func TestDelete_Range(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	env.mustRun("push", env.chart("foo", "0.9.0"), env.chart("foo", "1.0.0"), env.chart("foo", "1.5.0"), env.chart("foo", "2.0.0-rc.1"), env.chart("foo", "2.0.0"), testRepoName)
+
+	out := env.mustRun("delete", "foo", "--range", ">=1.0.0 <2.0.0", "--yes", testRepoName)
+	assert.Contains(t, out, "Successfully deleted 2 chart versions from the repository.")
+
+	var versions []string
+	for _, v := range env.index().Entries["foo"] {
+		versions = append(versions, v.Version)
+	}
+	assert.ElementsMatch(t, []string{"0.9.0", "2.0.0-rc.1", "2.0.0"}, versions)
+
+	t.Run("should fail if no versions match", func(t *testing.T) {
+		_, _, err := env.run("delete", "foo", "--range", "^3.0.0", "--yes", testRepoName)
+		require.ErrorContains(t, err, `no versions of chart foo match "^3.0.0"`)
+		assert.Equal(t, errorCodeChartNotFound, errorCode(err))
+	})
+
+	t.Run("should fail on invalid range", func(t *testing.T) {
+		_, _, err := env.run("delete", "foo", "--range", "one", testRepoName)
+		require.ErrorContains(t, err, "invalid --range constraint")
+	})
+
+	t.Run("should require exactly one selector", func(t *testing.T) {
+		_, _, err := env.run("delete", "foo", "--all", "--version", "0.9.0", testRepoName)
+		require.ErrorContains(t, err, "exactly one of --version, --all and --range flags is required")
+
+		_, _, err = env.run("delete", "foo", testRepoName)
+		require.ErrorContains(t, err, "exactly one of --version, --all and --range flags is required")
+	})
+}
@@ -3,13 +3,21 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
-	"github.com/hypnoglow/helm-s3/internal/awss3"
-	"github.com/hypnoglow/helm-s3/internal/awsutil"
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
 )
 
 const downloadDesc = `This command downloads a chart from AWS S3.
@@ -23,14 +31,38 @@ https://helm.sh/docs/topics/plugins/#downloader-plugins
 - KEY - key file,
 - CA - certificate authority file,
 - URL - full url.
+
+[Verification]
+
+To require verification of downloaded charts, set HELM_S3_VERIFY_DOWNLOADS to
+true for all repositories, or to a comma-separated list of names of helm
+repositories to verify charts from. The setting is read on the client, so that
+whoever can modify the repository cannot turn the verification off.
+
+Then the provenance file of the chart is downloaded as well, and the chart is
+verified against it: the provenance file must be signed with a key from the
+keyring, and the chart digest in it must match the chart archive. Charts that
+are not signed or fail the verification are refused. The keyring is read from
+HELM_S3_KEYRING, and defaults to ~/.gnupg/pubring.gpg.
 `
 
 func newDownloadCommand() *cobra.Command {
+	verify, verifyRepos := parseVerifyDownloads(os.Getenv("HELM_S3_VERIFY_DOWNLOADS"))
+	keyring := os.Getenv("HELM_S3_KEYRING")
+	if keyring == "" {
+		keyring = defaultKeyring()
+	}
+
 	act := &downloadAction{
-		certFile: "",
-		keyFile:  "",
-		caFile:   "",
-		url:      "",
+		printer:     nil,
+		out:         nil,
+		certFile:    "",
+		keyFile:     "",
+		caFile:      "",
+		url:         "",
+		verify:      verify,
+		verifyRepos: verifyRepos,
+		keyring:     keyring,
 	}
 
 	cmd := &cobra.Command{
@@ -45,6 +77,7 @@ func newDownloadCommand() *cobra.Command {
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			act.printer = cmd
+			act.out = cmd.OutOrStdout()
 			act.certFile = args[0]
 			act.keyFile = args[1]
 			act.caFile = args[2]
@@ -60,30 +93,37 @@ func newDownloadCommand() *cobra.Command {
 type downloadAction struct {
 	printer printer
 
+	// out is where the downloaded file is written to. Helm reads it from
+	// stdout, while the printer writes to stderr.
+	out io.Writer
+
 	// args
 
 	certFile string
 	keyFile  string
 	caFile   string
 	url      string
+
+	// environment
+
+	// verify requires verification of charts from all repositories, and
+	// verifyRepos only from the helm repositories with these names.
+	verify      bool
+	verifyRepos []string
+	keyring     string
 }
 
 func (act *downloadAction) run(ctx context.Context) error {
 	const indexYaml = "index.yaml"
 
-	sess, err := awsutil.Session(
-		awsutil.AssumeRoleTokenProvider(awsutil.StderrTokenProvider),
-		awsutil.DynamicBucketRegion(act.url),
-	)
+	store, err := storage.New(act.url)
 	if err != nil {
 		return err
 	}
 
-	storage := awss3.New(sess)
-
-	b, err := storage.FetchRaw(ctx, act.url)
+	b, _, err := store.FetchRaw(ctx, act.url)
 	if err != nil {
-		if strings.HasSuffix(act.url, indexYaml) && err == awss3.ErrObjectNotFound {
+		if strings.HasSuffix(act.url, indexYaml) && errors.Is(err, storage.ErrObjectNotFound) {
 			act.printer.PrintErrf(
 				"The index file does not exist by the path %s. "+
 					"If you haven't initialized the repository yet, try running `helm s3 init %s`",
@@ -96,7 +136,90 @@ func (act *downloadAction) run(ctx context.Context) error {
 		return errors.WithMessage(err, fmt.Sprintf("fetch from s3 url=%s", act.url))
 	}
 
-	// Do not use printer, use os.Stdout directly, as required by Helm.
-	fmt.Print(string(b))
+	if strings.HasSuffix(act.url, ".tgz") {
+		if err := act.verifyChart(ctx, store, b); err != nil {
+			return err
+		}
+	}
+
+	// Do not use printer, use stdout directly, as required by Helm.
+	_, err = act.out.Write(b)
+	return err
+}
+
+// verifyChart verifies the downloaded chart against its provenance file, if
+// the verification is required.
+func (act *downloadAction) verifyChart(ctx context.Context, store storage.Storage, chart []byte) error {
+	required, err := act.verificationRequired()
+	if err != nil {
+		return err
+	}
+	if !required {
+		return nil
+	}
+
+	prov, _, err := store.FetchRaw(ctx, act.url+".prov")
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return withErrorCode(errorCodeVerificationFailed, fmt.Errorf("refuse to serve chart %s: the chart is not signed", act.url))
+	}
+	if err != nil {
+		return errors.WithMessage(err, fmt.Sprintf("fetch from s3 url=%s.prov", act.url))
+	}
+
+	tmpDir, err := os.MkdirTemp("", "helm-s3-download-")
+	if err != nil {
+		return errors.Wrap(err, "create temporary directory for chart verification")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// The provenance file refers to the chart by its filename, so keep it.
+	filename := path.Base(act.url)
+	if unescaped, err := url.PathUnescape(filename); err == nil {
+		filename = unescaped
+	}
+	chartPath := filepath.Join(tmpDir, filename)
+	if err := os.WriteFile(chartPath, chart, 0o600); err != nil {
+		return errors.Wrap(err, "write chart file")
+	}
+	if err := os.WriteFile(chartPath+".prov", prov, 0o600); err != nil {
+		return errors.Wrap(err, "write prov file")
+	}
+
+	if _, err := helmutil.VerifyChart(chartPath, chartPath+".prov", act.keyring); err != nil {
+		return withErrorCode(errorCodeVerificationFailed, errors.WithMessagef(err, "refuse to serve chart %s", act.url))
+	}
 	return nil
 }
+
+// verificationRequired returns true if the verification of the chart is
+// required by the environment, either for all repositories or for the helm
+// repository the chart belongs to.
+func (act *downloadAction) verificationRequired() (bool, error) {
+	if act.verify {
+		return true, nil
+	}
+	if len(act.verifyRepos) == 0 {
+		return false, nil
+	}
+
+	repoEntry, ok, err := helmutil.LookupRepoEntryByChartURL(act.url)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	return ok && slices.Contains(act.verifyRepos, repoEntry.Name()), nil
+}
+
+// parseVerifyDownloads parses the value of HELM_S3_VERIFY_DOWNLOADS: either a
+// boolean, which requires verification of charts from all repositories, or a
+// comma-separated list of helm repository names.
+func parseVerifyDownloads(value string) (all bool, repos []string) {
+	if b, err := strconv.ParseBool(value); err == nil {
+		return b, nil
+	}
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			repos = append(repos, name)
+		}
+	}
+	return false, repos
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hypnoglow/helm-s3/internal/storage"
+	"github.com/hypnoglow/helm-s3/internal/storage/storagetest"
+)
+
+func TestDownload_Verify(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	keyring := env.keyring("Test Signer")
+	t.Setenv("HELM_S3_KEYRING", keyring)
+	t.Setenv("HELM_S3_VERIFY_DOWNLOADS", "other-repo, "+testRepoName)
+
+	signed := env.chart("foo", "1.0.0")
+	env.sign(signed, keyring, "Test Signer")
+	env.mustRun("push", signed, testRepoName)
+	env.mustRun("push", env.chart("bar", "1.0.0"), testRepoName)
+
+	t.Run("should serve signed chart", func(t *testing.T) {
+		stdout, _, err := env.run("download", "", "", "", env.repoURL+"/foo-1.0.0.tgz")
+		require.NoError(t, err)
+
+		want, err := os.ReadFile(signed)
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(want, []byte(stdout)))
+	})
+
+	t.Run("should refuse unsigned chart", func(t *testing.T) {
+		stdout, _, err := env.run("download", "", "", "", env.repoURL+"/bar-1.0.0.tgz")
+		require.ErrorContains(t, err, "the chart is not signed")
+		assert.Empty(t, stdout)
+	})
+
+	t.Run("should refuse chart signed with unknown key", func(t *testing.T) {
+		t.Setenv("HELM_S3_KEYRING", env.keyring("Somebody Else"))
+
+		stdout, _, err := env.run("download", "", "", "", env.repoURL+"/foo-1.0.0.tgz")
+		require.ErrorContains(t, err, "refuse to serve chart")
+		assert.Empty(t, stdout)
+	})
+
+	t.Run("should refuse chart with digest mismatch", func(t *testing.T) {
+		chartURL := env.repoURL + "/foo-1.0.0.tgz"
+		other, err := os.ReadFile(env.chartWithDescription("foo", "1.0.0", "tampered"))
+		require.NoError(t, err)
+		require.NoError(t, env.store.PutRaw(context.Background(), chartURL, "", bytes.NewReader(other), storage.Precondition{}))
+
+		stdout, _, err := env.run("download", "", "", "", chartURL)
+		require.ErrorContains(t, err, "refuse to serve chart")
+		assert.Empty(t, stdout)
+	})
+}
+
+func TestDownload_VerifyDisabled(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	env.mustRun("push", env.chart("bar", "1.0.0"), testRepoName)
+
+	before := len(env.store.Calls())
+	_, _, err := env.run("download", "", "", "", env.repoURL+"/bar-1.0.0.tgz")
+	require.NoError(t, err)
+	for _, call := range env.store.Calls()[before:] {
+		if call.Op == storagetest.OpFetchRaw {
+			assert.NotContains(t, call.URI, "index.yaml.settings", "the verification must not depend on the repository settings")
+		}
+	}
+
+	t.Run("should not verify charts from other repositories", func(t *testing.T) {
+		t.Setenv("HELM_S3_VERIFY_DOWNLOADS", "other-repo")
+
+		_, _, err := env.run("download", "", "", "", env.repoURL+"/bar-1.0.0.tgz")
+		require.NoError(t, err)
+	})
+
+	t.Run("should verify when required for all repositories", func(t *testing.T) {
+		t.Setenv("HELM_S3_VERIFY_DOWNLOADS", "true")
+
+		_, _, err := env.run("download", "", "", "", env.repoURL+"/bar-1.0.0.tgz")
+		require.ErrorContains(t, err, "the chart is not signed")
+	})
+}
+
+func TestParseVerifyDownloads(t *testing.T) {
+	testCases := map[string]struct {
+		value string
+		all   bool
+		repos []string
+	}{
+		"empty":     {value: ""},
+		"true":      {value: "true", all: true},
+		"false":     {value: "0"},
+		"repo list": {value: "stable, staging,,", repos: []string{"stable", "staging"}},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			all, repos := parseVerifyDownloads(tc.value)
+			assert.Equal(t, tc.all, all)
+			assert.Equal(t, tc.repos, repos)
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+const historyDesc = `This command lists previous versions of a chart archive and of the index.
+
+'helm s3 history' takes two arguments:
+- NAME - name of the chart,
+- REPO - target repository.
+
+The versions are kept by S3 when versioning is enabled for the bucket, so that
+a chart replaced with 'helm s3 push --force' or deleted can be brought back
+with 'helm s3 restore'. The chart archive is looked up by the index entry, or,
+if the chart version is not in the index, by the repository layout.
+`
+
+const historyExample = `  helm s3 history epicservice --version 0.5.1 my-repo - lists versions of the epicservice 0.5.1 archive and of the index.`
+
+func newHistoryCommand(opts *options) *cobra.Command {
+	act := &historyAction{
+		printer:   nil,
+		output:    outputText,
+		chartName: "",
+		repoName:  "",
+		version:   "",
+	}
+
+	cmd := &cobra.Command{
+		Use:     "history NAME REPO",
+		Short:   "List previous versions of a chart archive and of the index.",
+		Long:    historyDesc,
+		Example: historyExample,
+		Args:    wrapPositionalArgsBadUsage(cobra.ExactArgs(2)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// No completions for the NAME and REPO arguments.
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			act.printer = cmd
+			act.output = opts.output
+			act.chartName = args[0]
+			act.repoName = args[1]
+			return act.run(cmd.Context())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&act.version, "version", act.version, "Version of the chart.")
+	_ = cobra.MarkFlagRequired(flags, "version")
+
+	return cmd
+}
+
+type historyAction struct {
+	printer printer
+
+	// global flags
+
+	output string
+
+	// args
+
+	chartName string
+	repoName  string
+
+	// flags
+
+	version string
+}
+
+// objectHistory describes versions of an object.
+type objectHistory struct {
+	URL      string                  `json:"url"`
+	Versions []storage.ObjectVersion `json:"versions"`
+}
+
+// chartHistory describes versions of the chart archive and of the index.
+type chartHistory struct {
+	Chart objectHistory `json:"chart"`
+	Index objectHistory `json:"index"`
+}
+
+func (act *historyAction) run(ctx context.Context) error {
+	if err := validateOutput(act.output, outputText, outputTable, outputJSON, outputYAML); err != nil {
+		return err
+	}
+
+	repoEntry, err := helmutil.LookupRepoEntry(act.repoName)
+	if err != nil {
+		return err
+	}
+
+	store, err := storage.New(repoEntry.URL())
+	if err != nil {
+		return err
+	}
+
+	versioner, err := repoVersioner(store)
+	if err != nil {
+		return err
+	}
+
+	settings, err := fetchRepoSettings(ctx, store, repoEntry.URL())
+	if err != nil {
+		return err
+	}
+
+	idx, _, err := fetchIndex(ctx, store, repoEntry)
+	if err != nil {
+		return err
+	}
+
+	chartURL, _, _ := chartObjectURL(idx, repoEntry.URL(), resolveLayout("", settings), act.chartName, act.version)
+
+	history := chartHistory{
+		Chart: objectHistory{URL: chartURL},
+		Index: objectHistory{URL: repoEntry.IndexURL()},
+	}
+	if history.Chart.Versions, err = objectVersions(ctx, versioner, chartURL); err != nil {
+		return err
+	}
+	if history.Index.Versions, err = objectVersions(ctx, versioner, repoEntry.IndexURL()); err != nil {
+		return err
+	}
+
+	if act.output == outputJSON || act.output == outputYAML {
+		return printStructured(act.printer, act.output, history)
+	}
+
+	act.printer.Printf("Chart archive %s:\n", history.Chart.URL)
+	act.printVersions(history.Chart.Versions)
+	act.printer.Printf("\nIndex %s:\n", history.Index.URL)
+	act.printVersions(history.Index.Versions)
+	return nil
+}
+
+func (act *historyAction) printVersions(versions []storage.ObjectVersion) {
+	if len(versions) == 0 {
+		act.printer.Printf("  no versions found\n")
+		return
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION ID\tLAST MODIFIED\tSIZE\tSTATE")
+	for _, v := range versions {
+		state := "previous"
+		switch {
+		case v.DeleteMarker:
+			state = "deleted"
+		case v.IsLatest:
+			state = "current"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", v.VersionID, v.LastModified.UTC().Format(time.RFC3339), v.Size, state)
+	}
+	_ = w.Flush()
+
+	act.printer.Printf("%s", b.String())
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+const indexDesc = `This command manages previous generations of the repository index.
+
+Previous generations are kept as versions of the index object if versioning is
+enabled for the bucket, and as 'index.yaml.<timestamp>' backup objects if the
+repository is set up to keep them with --index-generations, see
+'helm s3 config'.
+`
+
+const indexHistoryDesc = `This command lists generations of the repository index, newest first.
+
+'helm s3 index history' takes one argument:
+- REPO - target repository.
+
+For every generation, the time it was generated and the number of chart
+versions in it are printed.
+`
+
+const indexHistoryExample = `  helm s3 index history my-repo - lists generations of the index of the repository with name 'my-repo'.`
+
+const indexRollbackDesc = `This command restores a previous generation of the repository index.
+
+'helm s3 index rollback' takes one argument:
+- REPO - target repository.
+
+By default, the generation preceding the current one is restored; choose
+another one with --generation (see 'helm s3 index history'). The chart versions
+added, removed and changed by the rollback are printed, and the rollback must
+be confirmed, unless --yes is set. The replaced index is kept as a generation
+as well, so the rollback can be undone.
+
+Chart files are not changed, so chart versions that were deleted after the
+generation was written are listed in the restored index, but cannot be
+downloaded. In an immutable repository, the rollback fails if it would change
+digests of chart versions.
+`
+
+const indexRollbackExample = `  helm s3 index rollback my-repo - restores the previous generation of the index after confirmation.
+
+  helm s3 index rollback --generation 20240302T100000.000Z --dry-run my-repo - prints what the rollback to the generation would change.`
+
+func newIndexCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "index",
+		Short: "Manage previous generations of the repository index.",
+		Long:  indexDesc,
+		Args:  wrapPositionalArgsBadUsage(cobra.NoArgs),
+	}
+
+	cmd.AddCommand(
+		newIndexHistoryCommand(opts),
+		newIndexRollbackCommand(opts),
+	)
+
+	return cmd
+}
+
+func newIndexHistoryCommand(opts *options) *cobra.Command {
+	act := &indexHistoryAction{
+		printer:  nil,
+		output:   outputText,
+		repoName: "",
+		limit:    20,
+	}
+
+	cmd := &cobra.Command{
+		Use:     "history REPO",
+		Short:   "List generations of the repository index.",
+		Long:    indexHistoryDesc,
+		Example: indexHistoryExample,
+		Args:    wrapPositionalArgsBadUsage(cobra.ExactArgs(1)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// No completions for the REPO argument.
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			act.printer = cmd
+			act.output = opts.output
+			act.repoName = args[0]
+			return act.run(cmd.Context())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.IntVar(&act.limit, "limit", act.limit, "Maximum number of generations to list, 0 for all.")
+
+	return cmd
+}
+
+type indexHistoryAction struct {
+	printer printer
+
+	// global flags
+
+	output string
+
+	// args
+
+	repoName string
+
+	// flags
+
+	limit int
+}
+
+// indexGenerationInfo describes a generation of the index along with its
+// contents.
+type indexGenerationInfo struct {
+	storage.IndexGeneration
+
+	Generated time.Time `json:"generated"`
+	Entries   int       `json:"entries"`
+}
+
+func (act *indexHistoryAction) run(ctx context.Context) error {
+	if err := validateOutput(act.output, outputText, outputTable, outputJSON, outputYAML); err != nil {
+		return err
+	}
+
+	repoEntry, err := helmutil.LookupRepoEntry(act.repoName)
+	if err != nil {
+		return err
+	}
+
+	store, err := storage.New(repoEntry.URL())
+	if err != nil {
+		return err
+	}
+
+	history, err := repoIndexHistory(store)
+	if err != nil {
+		return err
+	}
+
+	generations, err := history.IndexGenerations(ctx, repoEntry.URL())
+	if err != nil {
+		return errors.WithMessage(err, "list index generations")
+	}
+	if act.limit > 0 && len(generations) > act.limit {
+		generations = generations[:act.limit]
+	}
+
+	infos := make([]indexGenerationInfo, 0, len(generations))
+	for _, g := range generations {
+		idx, err := fetchIndexGeneration(ctx, history, repoEntry.URL(), g.ID)
+		if err != nil {
+			return err
+		}
+		infos = append(infos, indexGenerationInfo{
+			IndexGeneration: g,
+			Generated:       idx.Generated(),
+			Entries:         len(idx.Entries()),
+		})
+	}
+
+	if act.output == outputJSON || act.output == outputYAML {
+		return printStructured(act.printer, act.output, infos)
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "GENERATION\tGENERATED\tENTRIES\tSTATE")
+	for _, info := range infos {
+		state := "previous"
+		if info.Current {
+			state = "current"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", info.ID, info.Generated.UTC().Format(time.RFC3339), info.Entries, state)
+	}
+	_ = w.Flush()
+
+	act.printer.Printf("%s", b.String())
+	return nil
+}
+
+func newIndexRollbackCommand(opts *options) *cobra.Command {
+	act := &indexRollbackAction{
+		printer:    nil,
+		result:     &commandResult{Command: "index rollback"},
+		stdin:      nil,
+		acl:        "",
+		lock:       lockOptions{},
+		repoName:   "",
+		generation: "",
+		dryRun:     false,
+		yes:        false,
+	}
+
+	cmd := &cobra.Command{
+		Use:     "rollback REPO",
+		Short:   "Restore a previous generation of the repository index.",
+		Long:    indexRollbackDesc,
+		Example: indexRollbackExample,
+		Args:    wrapPositionalArgsBadUsage(cobra.ExactArgs(1)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// No completions for the REPO argument.
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(opts.output, outputText, outputJSON); err != nil {
+				return err
+			}
+			act.printer = newPrinter(cmd, opts.output)
+			act.stdin = cmd.InOrStdin()
+			act.acl = opts.acl
+			act.lock = opts.lock
+			act.repoName = args[0]
+			act.result.Repo = act.repoName
+			return reportResult(cmd, opts.output, act.result, act.run(cmd.Context()))
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&act.generation, "generation", act.generation, "ID of the generation to restore, see 'helm s3 index history'. Defaults to the generation preceding the current one.")
+	flags.BoolVar(&act.dryRun, "dry-run", act.dryRun, "Print what the rollback would change without updating the index.")
+	flags.BoolVarP(&act.yes, "yes", "y", act.yes, "Do not ask for confirmation.")
+
+	return cmd
+}
+
+type indexRollbackAction struct {
+	printer printer
+	result  *commandResult
+
+	// stdin is where the confirmation is read from.
+	stdin io.Reader
+
+	// global flags
+
+	acl  string
+	lock lockOptions
+
+	// args
+
+	repoName string
+
+	// flags
+
+	generation string
+	dryRun     bool
+	yes        bool
+}
+
+func (act *indexRollbackAction) run(ctx context.Context) error {
+	repoEntry, err := helmutil.LookupRepoEntry(act.repoName)
+	if err != nil {
+		return err
+	}
+
+	act.result.RepoURL = repoEntry.URL()
+	act.result.DryRun = act.dryRun
+
+	store, err := storage.New(repoEntry.URL())
+	if err != nil {
+		return err
+	}
+
+	history, err := repoIndexHistory(store)
+	if err != nil {
+		return err
+	}
+
+	settings, err := fetchRepoSettings(ctx, store, repoEntry.URL())
+	if err != nil {
+		return err
+	}
+
+	generations, err := history.IndexGenerations(ctx, repoEntry.URL())
+	if err != nil {
+		return errors.WithMessage(err, "list index generations")
+	}
+	generation, err := act.findGeneration(generations)
+	if err != nil {
+		return err
+	}
+
+	current, etag, err := fetchIndex(ctx, store, repoEntry)
+	if err != nil {
+		return err
+	}
+	idx, err := fetchIndexGeneration(ctx, history, repoEntry.URL(), generation.ID)
+	if err != nil {
+		return err
+	}
+
+	diff := helmutil.DiffIndex(current, idx)
+	act.result.Rollback = &rollbackResult{
+		Generation: generation.ID,
+		Charts:     len(idx.Entries()),
+		Added:      len(diff.Added),
+		Removed:    len(diff.Removed),
+		Changed:    len(diff.Changed),
+	}
+
+	if settings.Immutable {
+		if err := immutableDigestsError(diff, "rollback"); err != nil {
+			return err
+		}
+	}
+
+	act.printer.Printf(
+		"Generation %s of the index of repository %s was generated at %s and has %d chart versions.\n",
+		generation.ID, act.repoName, idx.Generated().UTC().Format(time.RFC3339), len(idx.Entries()),
+	)
+	printIndexDiff(act.printer, diff)
+	act.printer.Printf(
+		"\nSummary: %d added, %d removed, %d changed.\n\n",
+		len(diff.Added), len(diff.Removed), len(diff.Changed),
+	)
+
+	if act.dryRun {
+		act.printer.Printf("Dry run: the index of repository %s is not updated.\n", act.repoName)
+		return nil
+	}
+
+	if err := act.confirm(); err != nil {
+		return err
+	}
+
+	// The lock is taken only after the confirmation, so that other writers
+	// are not blocked while the prompt is open. The index must not change
+	// since it was diffed.
+	ctx, unlock, err := lockRepo(ctx, store, repoEntry.URL(), act.acl, act.lock, act.printer)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, lockedEtag, err := fetchIndex(ctx, store, repoEntry)
+	if err != nil {
+		return err
+	}
+	if lockedEtag != etag {
+		return withErrorCode(errorCodeIndexConflict, errors.New("the index was modified concurrently during rollback, run rollback again"))
+	}
+
+	r, err := idx.Reader()
+	if err != nil {
+		return errors.WithMessage(err, "get index reader")
+	}
+	if err := putIndex(ctx, store, repoEntry.URL(), act.acl, r, storage.Precondition{IfMatch: etag}, settings.IndexGenerations, act.printer); err != nil {
+		if errors.Is(err, storage.ErrPreconditionFailed) {
+			return withErrorCode(errorCodeIndexConflict, errors.New("the index was modified concurrently during rollback, run rollback again"))
+		}
+		return errors.WithMessage(err, "upload index to the repository")
+	}
+
+	if err := idx.WriteFile(repoEntry.CacheFile(), helmutil.DefaultIndexFilePerm); err != nil {
+		return errors.WithMessage(err, "update local index")
+	}
+
+	act.printer.Printf("Successfully rolled back the index to generation %s.\n", generation.ID)
+	return nil
+}
+
+// findGeneration returns the generation chosen with --generation, or the one
+// preceding the current generation.
+func (act *indexRollbackAction) findGeneration(generations []storage.IndexGeneration) (storage.IndexGeneration, error) {
+	for _, g := range generations {
+		if act.generation == "" && !g.Current {
+			return g, nil
+		}
+		if act.generation != "" && g.ID == act.generation {
+			if g.Current {
+				return g, newBadUsageError(fmt.Errorf("generation %s is the current generation of the index", g.ID))
+			}
+			return g, nil
+		}
+	}
+
+	if act.generation == "" {
+		return storage.IndexGeneration{}, withErrorCode(errorCodeNotFound, errors.New("no previous generations of the index are kept, set --index-generations with 'helm s3 config' or enable versioning for the bucket"))
+	}
+	return storage.IndexGeneration{}, withErrorCode(errorCodeNotFound, fmt.Errorf("generation %s of the index not found, see 'helm s3 index history'", act.generation))
+}
+
+// confirm asks for confirmation of the rollback, unless --yes is set.
+func (act *indexRollbackAction) confirm() error {
+	if act.yes {
+		return nil
+	}
+
+	act.printer.Printf("Roll back the index? [y/N]: ")
+	answer, err := bufio.NewReader(act.stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return errors.Wrap(err, "read confirmation")
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	default:
+		return errors.New("rollback is not confirmed, the index is not changed; use --yes to skip confirmation")
+	}
+}
+
+// repoIndexHistory returns the storage as a storage.IndexHistory, if it can
+// keep previous generations of the index.
+func repoIndexHistory(store storage.Storage) (storage.IndexHistory, error) {
+	history, ok := store.(storage.IndexHistory)
+	if !ok {
+		return nil, errors.New("the repository storage does not keep previous generations of the index")
+	}
+	return history, nil
+}
+
+// fetchIndexGeneration fetches the generation of the index by id.
+func fetchIndexGeneration(ctx context.Context, history storage.IndexHistory, repoURL, id string) (helmutil.Index, error) {
+	b, err := history.FetchIndexGeneration(ctx, repoURL, id)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "fetch index generation %s", id)
+	}
+
+	idx := helmutil.NewIndex()
+	if err := idx.UnmarshalBinary(b); err != nil {
+		return nil, errors.WithMessagef(err, "load index generation %s", id)
+	}
+	return idx, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hypnoglow/helm-s3/internal/storage/storagetest"
+)
+
+func TestIndexRollback(t *testing.T) {
+	env := newTestEnv(t)
+	env.mustRun("init", "--index-generations", "2", env.repoURL)
+	env.addRepo()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+	env.mustRun("push", env.chart("bar", "1.0.0"), testRepoName)
+
+	stdout, _, err := env.run("index", "history", "-o", "json", testRepoName)
+	require.NoError(t, err)
+	var generations []indexGenerationInfo
+	require.NoError(t, json.Unmarshal([]byte(stdout), &generations))
+	require.Len(t, generations, 3, "the current generation and the two kept ones")
+	assert.True(t, generations[0].Current)
+	assert.Equal(t, []int{2, 1, 0}, []int{generations[0].Entries, generations[1].Entries, generations[2].Entries})
+
+	out := env.mustRun("index", "history", testRepoName)
+	assert.Regexp(t, `current +\S+ +2 +current\n`, out)
+
+	t.Run("should not roll back without confirmation", func(t *testing.T) {
+		_, _, err := env.runWithInput(strings.NewReader("n\n"), "index", "rollback", testRepoName)
+		require.ErrorContains(t, err, "rollback is not confirmed")
+		assert.Len(t, env.index().Entries["bar"], 1)
+	})
+
+	out = env.mustRun("index", "rollback", "--yes", testRepoName)
+	assert.Contains(t, out, "Removed versions:\n  - bar 1.0.0\n")
+	assert.Contains(t, out, "Successfully rolled back the index to generation "+generations[1].ID)
+	assert.Empty(t, env.index().Entries["bar"])
+	assert.Len(t, env.index().Entries["foo"], 1)
+
+	stdout, _, err = env.run("index", "history", "-o", "json", testRepoName)
+	require.NoError(t, err)
+	generations = nil
+	require.NoError(t, json.Unmarshal([]byte(stdout), &generations))
+	require.Len(t, generations, 3, "older generations must be removed")
+	assert.Equal(t, 2, generations[1].Entries, "the replaced generation must be kept")
+
+	t.Run("should roll back to the chosen generation", func(t *testing.T) {
+		env.mustRun("index", "rollback", "--yes", "--generation", generations[1].ID, testRepoName)
+		assert.Len(t, env.index().Entries["bar"], 1)
+	})
+
+	t.Run("should fail on unknown generation", func(t *testing.T) {
+		_, _, err := env.run("index", "rollback", "--yes", "--generation", "unknown", testRepoName)
+		require.ErrorContains(t, err, "generation unknown of the index not found")
+		assert.Equal(t, errorCodeNotFound, errorCode(err))
+	})
+}
+
+func TestIndexRollback_ConcurrentUpdate(t *testing.T) {
+	env := newTestEnv(t)
+	env.mustRun("init", "--index-generations", "1", env.repoURL)
+	env.addRepo()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+
+	// The index is updated while the confirmation prompt is open.
+	var status string
+	stdin := readerFunc(func(p []byte) (int, error) {
+		status = env.mustRun("lock", "status", testRepoName)
+		env.mustRun("push", "--lock", env.chart("bar", "1.0.0"), testRepoName)
+		return copy(p, "y\n"), io.EOF
+	})
+
+	_, _, err := env.runWithInput(stdin, "index", "rollback", "--lock", testRepoName)
+	require.ErrorContains(t, err, "the index was modified concurrently during rollback")
+	assert.Equal(t, errorCodeIndexConflict, errorCode(err))
+	assert.Contains(t, status, "is not locked", "the lock must not be held while the prompt is open")
+	assert.Len(t, env.index().Entries["foo"], 1)
+	assert.Len(t, env.index().Entries["bar"], 1)
+}
+
+// readerFunc is an io.Reader implemented by a function.
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestIndexRollback_Versioning(t *testing.T) {
+	env := newTestEnv(t)
+	env.store.EnableVersioning()
+	env.mustRun("init", "--index-generations", "1", env.repoURL)
+	env.addRepo()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+	env.mustRun("push", env.chart("bar", "1.0.0"), testRepoName)
+
+	for _, key := range env.store.Keys() {
+		assert.NotContains(t, key, "index.yaml.2", "no backups are made when the bucket keeps versions")
+	}
+
+	stdout, _, err := env.run("index", "history", "-o", "json", testRepoName)
+	require.NoError(t, err)
+	var generations []indexGenerationInfo
+	require.NoError(t, json.Unmarshal([]byte(stdout), &generations))
+	require.Len(t, generations, 3, "versions of the index must be kept, their expiry is left to lifecycle rules")
+
+	stdout, _, err = env.run("index", "rollback", "--dry-run", "-o", "json", testRepoName)
+	require.NoError(t, err)
+	var result commandResult
+	require.NoError(t, json.Unmarshal([]byte(stdout), &result))
+	require.NotNil(t, result.Rollback)
+	assert.Equal(t, rollbackResult{Generation: generations[1].ID, Charts: 1, Removed: 1}, *result.Rollback)
+	assert.Len(t, env.index().Entries["bar"], 1, "dry run must not change the index")
+}
+
+func TestIndexRollback_BackupFailure(t *testing.T) {
+	env := newTestEnv(t)
+	env.mustRun("init", "--index-generations", "2", env.repoURL)
+	env.addRepo()
+
+	env.store.SetHook(func(op storagetest.Op, uri string) error {
+		if op == storagetest.OpPutRaw && strings.Contains(uri, "index.yaml.") {
+			return errors.New("access denied")
+		}
+		return nil
+	})
+
+	// The index is updated before the backup is made, so the push must
+	// succeed, otherwise it could not be repeated.
+	out := env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+	assert.Contains(t, out, "[WARNING] index is updated, but its previous generations are not kept")
+	assert.Contains(t, out, "access denied")
+	assert.Len(t, env.index().Entries["foo"], 1)
+}
+
+func TestIndexRollback_NoGenerations(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+
+	_, _, err := env.run("index", "rollback", "--yes", testRepoName)
+	require.ErrorContains(t, err, "no previous generations of the index are kept")
+	assert.Equal(t, errorCodeNotFound, errorCode(err))
+}
@@ -8,26 +8,81 @@ import (
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
-	"github.com/hypnoglow/helm-s3/internal/awss3"
-	"github.com/hypnoglow/helm-s3/internal/awsutil"
 	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
 )
 
 const initDesc = `This command initializes an empty repository on AWS S3.
 
 'helm s3 init' takes one argument:
 - URI - URI of the repository.
+
+[Layout]
+
+By default, charts are stored in the repository root. With --layout nested,
+the repository is set up to store each chart in a directory named after it,
+e.g. 'charts/epicservice/epicservice-0.5.1.tgz'. The layout is saved in the
+'index.yaml.settings' file next to the index, and push and reindex follow it.
+
+[Provenance policy]
+
+With --require-provenance, the repository is set up to accept only signed
+charts: push fails if the chart has no provenance file, or if the provenance
+file doesn't verify against the keyring of the user pushing the chart (see
+'helm s3 push --keyring'). To accept only charts signed with certain keys, list
+their fingerprints with --allowed-signer. The policy is saved in the
+'index.yaml.settings' file as well.
+
+[Immutability]
+
+With --immutable, published chart versions cannot be replaced: push refuses
+--force, delete requires --allow-immutable-delete, and reindex refuses to
+change digests of existing chart versions.
+
+[Index history]
+
+With --index-generations N, every update of the index keeps N previous
+generations of the index: as versions of the index object if versioning is
+enabled for the bucket, and as 'index.yaml.<timestamp>' backup objects
+otherwise. Versions of the index object are never deleted by the plugin, use
+bucket lifecycle rules to expire them. See 'helm s3 index rollback' to
+restore one of them.
+
+All the settings can be changed later with 'helm s3 config'.
+
+[Re-initialization]
+
+With --force, the index of the existing repository is replaced with an empty
+one. The settings of the repository are kept, except the ones set with the
+flags. The index of an immutable repository cannot be replaced.
 `
 
-const initExample = `  helm s3 init s3://awesome-bucket/charts - inits chart repository in 'awesome-bucket' bucket under 'charts' path.`
+const initExample = `  helm s3 init s3://awesome-bucket/charts - inits chart repository in 'awesome-bucket' bucket under 'charts' path.
+
+  helm s3 init --layout nested s3://awesome-bucket/charts - inits chart repository that stores each chart in its own directory.
+
+
+  helm s3 init --require-provenance --allowed-signer 6A2B7A4C... s3://awesome-bucket/charts - inits chart repository that accepts only charts signed with the key.
+
+  helm s3 init --immutable s3://awesome-bucket/charts - inits chart repository where published chart versions cannot be replaced.
+
+  helm s3 init --index-generations 10 s3://awesome-bucket/charts - inits chart repository that keeps 10 previous generations of the index.`
 
 func newInitCommand(opts *options) *cobra.Command {
 	act := &initAction{
-		printer:        nil,
-		acl:            "",
-		uri:            "",
-		force:          false,
-		ignoreIfExists: false,
+		printer:           nil,
+		result:            &commandResult{Command: "init"},
+		acl:               "",
+		lock:              lockOptions{},
+		uri:               "",
+		force:             false,
+		ignoreIfExists:    false,
+		layout:            "",
+		immutable:         false,
+		requireProvenance: false,
+		allowedSigners:    nil,
+		indexGenerations:  0,
+		changed:           nil,
 	}
 
 	cmd := &cobra.Command{
@@ -41,16 +96,33 @@ func newInitCommand(opts *options) *cobra.Command {
 			return nil, cobra.ShellCompDirectiveNoFileComp
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			act.printer = cmd
+			if err := validateOutput(opts.output, outputText, outputJSON); err != nil {
+				return err
+			}
+			act.printer = newPrinter(cmd, opts.output)
 			act.acl = opts.acl
+			act.lock = opts.lock
 			act.uri = args[0]
-			return act.run(cmd.Context())
+			act.result.Repo = act.uri
+			act.result.RepoURL = act.uri
+			act.changed = make(map[string]bool)
+			for _, name := range configFlags {
+				if cmd.Flags().Changed(name) {
+					act.changed[name] = true
+				}
+			}
+			return reportResult(cmd, opts.output, act.result, act.run(cmd.Context()))
 		},
 	}
 
 	flags := cmd.Flags()
 	flags.BoolVar(&act.force, "force", act.force, "Replace the index file if it already exists.")
 	flags.BoolVar(&act.ignoreIfExists, "ignore-if-exists", act.ignoreIfExists, "If the index file already exists, exit normally and do not trigger an error.")
+	flags.StringVar(&act.layout, "layout", act.layout, "Layout of the repository: flat or nested.")
+	flags.BoolVar(&act.immutable, "immutable", act.immutable, "Forbid replacing published chart versions.")
+	flags.BoolVar(&act.requireProvenance, "require-provenance", act.requireProvenance, "Require charts pushed to the repository to have a provenance file that verifies.")
+	flags.StringSliceVar(&act.allowedSigners, "allowed-signer", act.allowedSigners, "Fingerprint of the key charts pushed to the repository may be signed with. Can be repeated. Requires --require-provenance.")
+	flags.IntVar(&act.indexGenerations, "index-generations", act.indexGenerations, "Number of previous generations of the index to keep on every index update.")
 
 	// We don't use cobra's feature
 	//
@@ -64,10 +136,12 @@ func newInitCommand(opts *options) *cobra.Command {
 
 type initAction struct {
 	printer printer
+	result  *commandResult
 
 	// global flags
 
-	acl string
+	acl  string
+	lock lockOptions
 
 	// args
 
@@ -75,8 +149,19 @@ type initAction struct {
 
 	// flags
 
-	force          bool
-	ignoreIfExists bool
+	force            bool
+	ignoreIfExists   bool
+	layout           string
+	immutable        bool
+	indexGenerations int
+
+	// flags for the provenance policy
+
+	requireProvenance bool
+	allowedSigners    []string
+
+	// changed are names of the settings flags set explicitly.
+	changed map[string]bool
 }
 
 func (act *initAction) run(ctx context.Context) error {
@@ -84,7 +169,20 @@ func (act *initAction) run(ctx context.Context) error {
 		act.printer.PrintErrf(
 			"The --force and --ignore-if-exists flags are mutually exclusive and cannot be specified together.\n",
 		)
-		return newSilentError()
+		return withErrorCode(errorCodeBadUsage, newSilentErrorf("--force and --ignore-if-exists flags are mutually exclusive"))
+	}
+	if err := validateLayout(act.layout); err != nil {
+		return err
+	}
+	if err := validateIndexGenerations(act.indexGenerations); err != nil {
+		return err
+	}
+	if len(act.allowedSigners) > 0 && !act.requireProvenance {
+		return newBadUsageError(errors.New("--allowed-signer requires --require-provenance"))
+	}
+	allowedSigners, err := normalizeFingerprints(act.allowedSigners)
+	if err != nil {
+		return err
 	}
 
 	if err := act.checkRepoEntry(); err != nil {
@@ -96,13 +194,18 @@ func (act *initAction) run(ctx context.Context) error {
 		return errors.WithMessage(err, "get index reader")
 	}
 
-	sess, err := awsutil.Session(awsutil.DynamicBucketRegion(act.uri))
+	store, err := storage.New(act.uri)
 	if err != nil {
 		return err
 	}
-	storage := awss3.New(sess)
 
-	exists, err := storage.IndexExists(ctx, act.uri)
+	ctx, unlock, err := lockRepo(ctx, store, act.uri, act.acl, act.lock, act.printer)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	exists, err := store.IndexExists(ctx, act.uri)
 	if err != nil {
 		return fmt.Errorf("check if index exists in the storage: %v", err)
 	}
@@ -117,10 +220,43 @@ func (act *initAction) run(ctx context.Context) error {
 		// fallthrough on --force
 	}
 
-	if err := storage.PutIndex(ctx, act.uri, act.acl, r); err != nil {
+	// The settings of the existing repository are kept, except the ones set
+	// explicitly, like 'helm s3 config' does.
+	settings, settingsCond, err := fetchRepoSettingsWithCond(ctx, store, act.uri)
+	if err != nil {
+		return err
+	}
+	if exists && settings.Immutable {
+		return withErrorCode(errorCodeImmutable, errors.New("the repository is immutable, its index cannot be replaced with --force"))
+	}
+	act.applySettings(&settings, allowedSigners)
+
+	// Unless the index is going to be replaced explicitly, make sure it is not
+	// created concurrently by another process between the check and the upload.
+	cond := storage.Precondition{IfNoneMatch: "*"}
+	if exists {
+		cond = storage.Precondition{}
+	}
+
+	if err := putIndex(ctx, store, act.uri, act.acl, r, cond, settings.IndexGenerations, act.printer); err != nil {
+		if errors.Is(err, storage.ErrPreconditionFailed) {
+			if act.ignoreIfExists {
+				return act.ignoreIfExistsInStorageError()
+			}
+			return act.alreadyExistsInStorageError()
+		}
 		return errors.WithMessage(err, "upload index to s3")
 	}
 
+	if len(act.changed) > 0 {
+		if err := putRepoSettings(ctx, store, act.uri, act.acl, settings, settingsCond); err != nil {
+			if errors.Is(err, storage.ErrPreconditionFailed) {
+				return errors.WithMessage(err, "the repository settings were modified concurrently, try again")
+			}
+			return err
+		}
+	}
+
 	// TODO:
 	// do we need to automatically do `helm repo add <name> <uri>`,
 	// like we are doing `helm repo update` when we push a chart
@@ -130,6 +266,25 @@ func (act *initAction) run(ctx context.Context) error {
 	return nil
 }
 
+// applySettings applies the settings set explicitly to the settings.
+func (act *initAction) applySettings(settings *repoSettings, allowedSigners []string) {
+	if act.changed["layout"] {
+		settings.Layout = act.layout
+	}
+	if act.changed["immutable"] {
+		settings.Immutable = act.immutable
+	}
+	if act.changed["require-provenance"] {
+		settings.RequireProvenance = act.requireProvenance
+	}
+	if act.changed["allowed-signer"] {
+		settings.AllowedSigners = allowedSigners
+	}
+	if act.changed["index-generations"] {
+		settings.IndexGenerations = act.indexGenerations
+	}
+}
+
 func (act *initAction) checkRepoEntry() error {
 	repoEntry, ok, err := helmutil.LookupRepoEntryByURL(act.uri)
 	if errors.Is(err, fs.ErrNotExist) {
@@ -160,6 +315,7 @@ func (act *initAction) checkRepoEntry() error {
 }
 
 func (act *initAction) ignoreIfExistsError(name string) error {
+	act.result.Skipped = true
 	act.printer.Printf(
 		"The repository with the provided URI already exists under name %q, ignore init operation.\n",
 		name,
@@ -168,6 +324,7 @@ func (act *initAction) ignoreIfExistsError(name string) error {
 }
 
 func (act *initAction) ignoreIfExistsInStorageError() error {
+	act.result.Skipped = true
 	act.printer.Printf(
 		"The index file already exists under the provided URI, ignore init operation.\n",
 	)
@@ -184,7 +341,7 @@ func (act *initAction) alreadyExistsError(name string) error {
 		name,
 		act.uri,
 	)
-	return newSilentError()
+	return withErrorCode(errorCodeRepoExists, newSilentErrorf("the repository already exists under name %q", name))
 }
 
 func (act *initAction) alreadyExistsInStorageError() error {
@@ -196,5 +353,5 @@ func (act *initAction) alreadyExistsInStorageError() error {
 			"  helm s3 init --ignore-if-exists %[1]s\n\n",
 		act.uri,
 	)
-	return newSilentError()
+	return withErrorCode(errorCodeRepoExists, newSilentErrorf("the index file already exists"))
 }
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInit(t *testing.T) {
+	env := newTestEnv(t)
+
+	out := env.mustRun("init", env.repoURL)
+	assert.Contains(t, out, "Initialized empty repository")
+	assert.Empty(t, env.index().Entries)
+
+	t.Run("should fail if the index already exists", func(t *testing.T) {
+		_, stderr, err := env.run("init", env.repoURL)
+		require.Error(t, err)
+		assert.Contains(t, stderr, "The index file already exists")
+	})
+
+	t.Run("should ignore existing index with --ignore-if-exists", func(t *testing.T) {
+		out := env.mustRun("init", "--ignore-if-exists", env.repoURL)
+		assert.Contains(t, out, "ignore init operation")
+	})
+
+	t.Run("should fail if the repository is already added", func(t *testing.T) {
+		env.addRepo()
+
+		_, stderr, err := env.run("init", env.repoURL)
+		require.Error(t, err)
+		assert.Contains(t, stderr, `already exists under name "test-repo"`)
+	})
+}
+
+func TestInit_OutputJSON(t *testing.T) {
+	env := newTestEnv(t)
+
+	stdout, stderr, err := env.run("init", "-o", "json", env.repoURL)
+	require.NoError(t, err, stderr)
+
+	var res commandResult
+	require.NoError(t, json.Unmarshal([]byte(stdout), &res))
+	assert.Equal(t, commandResult{Command: "init", Repo: env.repoURL, RepoURL: env.repoURL}, res)
+
+	t.Run("should report skipped init", func(t *testing.T) {
+		stdout, _, err := env.run("init", "-o", "json", "--ignore-if-exists", env.repoURL)
+		require.NoError(t, err)
+
+		var res commandResult
+		require.NoError(t, json.Unmarshal([]byte(stdout), &res))
+		assert.True(t, res.Skipped)
+	})
+
+	t.Run("should report existing repository", func(t *testing.T) {
+		stdout, _, err := env.run("init", "-o", "json", env.repoURL)
+		require.Error(t, err)
+
+		var res commandResult
+		require.NoError(t, json.Unmarshal([]byte(stdout), &res))
+		require.NotNil(t, res.Error)
+		assert.Equal(t, errorCodeRepoExists, res.Error.Code)
+	})
+
+	t.Run("should refuse unsupported output format", func(t *testing.T) {
+		_, _, err := env.run("init", "-o", "yaml", env.repoURL)
+		require.ErrorContains(t, err, `unknown output format "yaml"`)
+	})
+}
+
+func TestInit_RequireProvenance(t *testing.T) {
+	env := newTestEnv(t)
+
+	t.Run("should require --require-provenance for --allowed-signer", func(t *testing.T) {
+		_, _, err := env.run("init", "--allowed-signer", "6A2B7A4C", env.repoURL)
+		require.ErrorContains(t, err, "--allowed-signer requires --require-provenance")
+	})
+
+	t.Run("should fail on invalid fingerprint", func(t *testing.T) {
+		_, _, err := env.run("init", "--require-provenance", "--allowed-signer", "John Smith", env.repoURL)
+		require.ErrorContains(t, err, `invalid key fingerprint "John Smith"`)
+	})
+
+	env.mustRun("init", "--require-provenance", "--allowed-signer", "6a2b 7a4c", "--allowed-signer", "6A2B7A4C", env.repoURL)
+
+	obj, ok := env.store.Get(env.repoURL + "/index.yaml.settings")
+	require.True(t, ok)
+	var settings repoSettings
+	require.NoError(t, json.Unmarshal(obj.Data, &settings))
+	assert.Equal(t, repoSettings{RequireProvenance: true, AllowedSigners: []string{"6A2B7A4C"}}, settings)
+}
+
+func TestInit_Force(t *testing.T) {
+	env := newTestEnv(t)
+	env.mustRun("init", "--require-provenance", "--index-generations", "1", env.repoURL)
+	env.addRepo()
+	env.mustRun("config", "--require-provenance=false", testRepoName)
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+	env.mustRun("config", "--require-provenance", testRepoName)
+
+	env.mustRun("init", "--force", "--layout", "nested", env.repoURL)
+	assert.Empty(t, env.index().Entries)
+
+	obj, ok := env.store.Get(env.repoURL + "/index.yaml.settings")
+	require.True(t, ok)
+	var settings repoSettings
+	require.NoError(t, json.Unmarshal(obj.Data, &settings))
+	assert.Equal(t, repoSettings{Layout: layoutNested, RequireProvenance: true, IndexGenerations: 1}, settings, "the settings not set with flags must be kept")
+
+	stdout, _, err := env.run("index", "history", "-o", "json", testRepoName)
+	require.NoError(t, err)
+	var generations []indexGenerationInfo
+	require.NoError(t, json.Unmarshal([]byte(stdout), &generations))
+	assert.Len(t, generations, 2, "the replaced index must be kept by the repository setting")
+
+	t.Run("should refuse to replace the index of immutable repository", func(t *testing.T) {
+		env.mustRun("config", "--immutable", "--require-provenance=false", testRepoName)
+		env.mustRun("push", env.chart("foo", "1.1.0"), testRepoName)
+
+		_, _, err := env.run("init", "--force", "--immutable=false", env.repoURL)
+		require.ErrorContains(t, err, "the repository is immutable, its index cannot be replaced with --force")
+		assert.Equal(t, errorCodeImmutable, errorCode(err))
+		assert.Len(t, env.index().Entries["foo"], 1)
+	})
+}
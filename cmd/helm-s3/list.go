@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+const listDesc = `This command lists charts in the repository.
+
+'helm s3 list' takes one or two arguments:
+- REPO - target repository,
+- CHART - (optional) name of the chart to list versions of.
+
+Unlike 'helm search repo', it reads the index straight from the repository
+rather than from the local cache, and lists all chart versions.
+
+For each chart version, the app version, the creation time, the digest and
+whether the provenance file exists in the repository are shown. Checking
+provenance files takes a request per chart version; they are made
+concurrently, use --concurrency to tune the number of requests made at once.
+
+[Filtering versions]
+
+Use --version to list only chart versions matching the semver constraint,
+e.g. '^1.2.0' or '>=1.0.0 <2.0.0'. Like in helm, pre-release versions match
+only constraints with a pre-release, e.g. '>=1.0.0-0'.
+`
+
+const listExample = `  helm s3 list my-repo - lists all charts in the repository with name 'my-repo'.
+
+  helm s3 list my-repo epicservice --version '~0.5' - lists 0.5.x versions of chart 'epicservice'.
+
+  helm s3 list my-repo --output json - lists all charts in JSON format.`
+
+// defaultListConcurrency is the default number of provenance files checked
+// concurrently during listing.
+const defaultListConcurrency = 10
+
+func newListCommand(opts *options) *cobra.Command {
+	act := &listAction{
+		printer:     nil,
+		verbose:     false,
+		output:      outputText,
+		repoName:    "",
+		chartName:   "",
+		version:     "",
+		concurrency: defaultListConcurrency,
+	}
+
+	cmd := &cobra.Command{
+		Use:     "list REPO [CHART]",
+		Aliases: []string{"ls"},
+		Short:   "List charts in the repository.",
+		Long:    listDesc,
+		Example: listExample,
+		Args:    wrapPositionalArgsBadUsage(cobra.RangeArgs(1, 2)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// No completions for the REPO and CHART arguments.
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			act.printer = cmd
+			act.verbose = opts.verbose
+			act.output = opts.output
+			act.repoName = args[0]
+			if len(args) > 1 {
+				act.chartName = args[1]
+			}
+			return act.run(cmd.Context())
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&act.version, "version", act.version, "List only chart versions matching the semver constraint.")
+	flags.IntVar(&act.concurrency, "concurrency", act.concurrency, "Maximum number of provenance files checked concurrently.")
+
+	return cmd
+}
+
+type listAction struct {
+	printer printer
+
+	// global flags
+
+	verbose bool
+	output  string
+
+	// args
+
+	repoName  string
+	chartName string
+
+	// flags
+
+	version     string
+	concurrency int
+}
+
+// listedChart describes a chart version in the list.
+type listedChart struct {
+	Name       string    `json:"name"`
+	Version    string    `json:"version"`
+	AppVersion string    `json:"appVersion,omitempty"`
+	Created    time.Time `json:"created"`
+	Digest     string    `json:"digest"`
+	URL        string    `json:"url,omitempty"`
+	Provenance bool      `json:"provenance"`
+}
+
+func (act *listAction) run(ctx context.Context) error {
+	if err := validateOutput(act.output, outputText, outputTable, outputJSON, outputYAML); err != nil {
+		return err
+	}
+	if act.concurrency < 1 {
+		return newBadUsageError(errors.New("--concurrency must be a positive number"))
+	}
+
+	var constraint *semver.Constraints
+	if act.version != "" {
+		c, err := semver.NewConstraint(act.version)
+		if err != nil {
+			return newBadUsageError(fmt.Errorf("invalid --version constraint %q: %v", act.version, err))
+		}
+		constraint = c
+	}
+
+	repoEntry, err := helmutil.LookupRepoEntry(act.repoName)
+	if err != nil {
+		return err
+	}
+
+	store, err := storage.New(repoEntry.URL())
+	if err != nil {
+		return err
+	}
+
+	idx, _, err := fetchIndex(ctx, store, repoEntry)
+	if err != nil {
+		return err
+	}
+
+	charts := make([]listedChart, 0)
+	found := false
+	for _, entry := range idx.Entries() {
+		if act.chartName != "" && entry.Name != act.chartName {
+			continue
+		}
+		found = true
+		if constraint != nil && !matchesConstraint(constraint, entry.Version) {
+			continue
+		}
+
+		var url string
+		if len(entry.URLs) > 0 {
+			url = entry.URLs[0]
+		}
+		charts = append(charts, listedChart{
+			Name:       entry.Name,
+			Version:    entry.Version,
+			AppVersion: entry.AppVersion,
+			Created:    entry.Created,
+			Digest:     entry.Digest,
+			URL:        url,
+		})
+	}
+
+	if act.chartName != "" && !found {
+		return fmt.Errorf("chart %s not found in the repository %s", act.chartName, act.repoName)
+	}
+
+	if err := act.checkProvenance(ctx, store, repoEntry.URL(), charts); err != nil {
+		return err
+	}
+
+	if act.output == outputJSON || act.output == outputYAML {
+		return printStructured(act.printer, act.output, charts)
+	}
+
+	act.printTable(charts)
+	return nil
+}
+
+// checkProvenance sets whether the provenance file exists in the repository
+// for each chart version. Charts located outside of the repository are
+// considered to have no provenance file.
+func (act *listAction) checkProvenance(ctx context.Context, store storage.Storage, repoURL string, charts []listedChart) error {
+	return forEachConcurrently(ctx, len(charts), act.concurrency, func(ctx context.Context, i int) error {
+		chart := &charts[i]
+		filename, ok := entryFilename(helmutil.IndexEntry{URLs: []string{chart.URL}}, repoURL)
+		if !ok {
+			return nil
+		}
+		provURL := strings.TrimSuffix(repoURL, "/") + "/" + filename + ".prov"
+
+		if act.verbose {
+			act.printer.Printf("[DEBUG] Checking provenance file %s.\n", provURL)
+		}
+		exists, err := store.Exists(ctx, provURL)
+		if err != nil {
+			return errors.WithMessagef(err, "check if provenance file exists for %s %s", chart.Name, chart.Version)
+		}
+		chart.Provenance = exists
+		return nil
+	})
+}
+
+func (act *listAction) printTable(charts []listedChart) {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tAPP VERSION\tCREATED\tDIGEST\tPROVENANCE")
+	for _, chart := range charts {
+		created := ""
+		if !chart.Created.IsZero() {
+			created = chart.Created.UTC().Format(time.RFC3339)
+		}
+		prov := "no"
+		if chart.Provenance {
+			prov = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", chart.Name, chart.Version, chart.AppVersion, created, chart.Digest, prov)
+	}
+	_ = w.Flush()
+
+	act.printer.Printf("%s", b.String())
+}
+
+// matchesConstraint returns true if the version matches the constraint.
+// Versions that are not valid semver never match.
+func matchesConstraint(constraint *semver.Constraints, version string) bool {
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return false
+	}
+	return constraint.Check(v)
+}
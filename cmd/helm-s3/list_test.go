@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/yaml"
+)
+
+func TestList(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+
+	for _, version := range []string{"1.0.0", "1.1.0", "2.0.0", "2.1.0-rc.1"} {
+		env.mustRun("push", env.chart("foo", version), testRepoName)
+	}
+	env.mustRun("push", env.chart("bar", "0.1.0"), testRepoName)
+	env.store.Put(env.repoURL+"/foo-1.1.0.tgz.prov", []byte("prov"), nil)
+
+	t.Run("should print table", func(t *testing.T) {
+		out := env.mustRun("list", testRepoName)
+		assert.Contains(t, out, "NAME")
+		assert.Contains(t, out, "PROVENANCE")
+		assert.Contains(t, out, "bar")
+		assert.Contains(t, out, "2.1.0-rc.1")
+	})
+
+	t.Run("should print json", func(t *testing.T) {
+		out := env.mustRun("list", "--output", "json", testRepoName, "foo")
+
+		var charts []listedChart
+		require.NoError(t, json.Unmarshal([]byte(out), &charts))
+		require.Len(t, charts, 4)
+		for _, chart := range charts {
+			assert.Equal(t, "foo", chart.Name)
+			assert.NotEmpty(t, chart.Digest)
+			assert.False(t, chart.Created.IsZero())
+			assert.Equal(t, chart.Version == "1.1.0", chart.Provenance, chart.Version)
+		}
+	})
+
+	t.Run("should print yaml", func(t *testing.T) {
+		out := env.mustRun("list", "-o", "yaml", testRepoName, "bar")
+
+		var charts []listedChart
+		require.NoError(t, yaml.Unmarshal([]byte(out), &charts))
+		require.Len(t, charts, 1)
+		assert.Equal(t, "0.1.0", charts[0].Version)
+		assert.Equal(t, env.repoURL+"/bar-0.1.0.tgz", charts[0].URL)
+	})
+
+	t.Run("should filter versions by constraint", func(t *testing.T) {
+		out := env.mustRun("list", "-o", "json", "--version", ">=1.1.0 <3.0.0", testRepoName, "foo")
+
+		var charts []listedChart
+		require.NoError(t, json.Unmarshal([]byte(out), &charts))
+		var versions []string
+		for _, chart := range charts {
+			versions = append(versions, chart.Version)
+		}
+		assert.Equal(t, []string{"2.0.0", "1.1.0"}, versions)
+	})
+
+	t.Run("should fail on unknown chart", func(t *testing.T) {
+		_, _, err := env.run("list", testRepoName, "baz")
+		require.ErrorContains(t, err, "chart baz not found")
+	})
+
+	t.Run("should fail on bad output format", func(t *testing.T) {
+		_, _, err := env.run("list", "-o", "xml", testRepoName)
+		require.ErrorContains(t, err, `unknown output format "xml"`)
+	})
+}
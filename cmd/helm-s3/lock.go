@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+const lockDesc = `This command manages the repository lock.
+
+The repository lock is acquired by commands that modify the repository when
+'--lock' flag is set. Normally the lock is released automatically, but if the
+process holding the lock was terminated abruptly, the lock stays in place until
+its lease expires. Use these commands to inspect and release such locks.
+`
+
+const lockStatusDesc = `This command shows the repository lock status.
+
+'helm s3 lock status' takes one argument:
+- REPO - target repository.
+`
+
+const lockStatusExample = `  helm s3 lock status my-repo - shows who holds the lock on the repository with name 'my-repo'.`
+
+const lockReleaseDesc = `This command forcibly releases the repository lock.
+
+'helm s3 lock release' takes one argument:
+- REPO - target repository.
+
+Make sure the process holding the lock is not running anymore, otherwise
+concurrent modifications may corrupt the repository index.
+`
+
+const lockReleaseExample = `  helm s3 lock release my-repo - releases the lock on the repository with name 'my-repo'.`
+
+func newLockCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lock",
+		Short: "Manage the repository lock.",
+		Long:  lockDesc,
+		Args:  wrapPositionalArgsBadUsage(cobra.NoArgs),
+	}
+
+	cmd.AddCommand(
+		newLockStatusCommand(),
+		newLockReleaseCommand(),
+	)
+
+	return cmd
+}
+
+func newLockStatusCommand() *cobra.Command {
+	act := &lockStatusAction{
+		printer:  nil,
+		repoName: "",
+	}
+
+	cmd := &cobra.Command{
+		Use:     "status REPO",
+		Short:   "Show the repository lock status.",
+		Long:    lockStatusDesc,
+		Example: lockStatusExample,
+		Args:    wrapPositionalArgsBadUsage(cobra.ExactArgs(1)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// No completions for the REPO argument.
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			act.printer = cmd
+			act.repoName = args[0]
+			return act.run(cmd.Context())
+		},
+	}
+
+	return cmd
+}
+
+type lockStatusAction struct {
+	printer printer
+
+	// args
+
+	repoName string
+}
+
+func (act *lockStatusAction) run(ctx context.Context) error {
+	repoEntry, err := helmutil.LookupRepoEntry(act.repoName)
+	if err != nil {
+		return err
+	}
+
+	store, err := storage.New(repoEntry.URL())
+	if err != nil {
+		return err
+	}
+
+	locker, err := repoLocker(store)
+	if err != nil {
+		return err
+	}
+
+	lock, err := locker.LockStatus(ctx, repoEntry.URL())
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		act.printer.Printf("Repository %s is not locked.\n", act.repoName)
+		return nil
+	}
+	if err != nil {
+		return errors.WithMessage(err, "get repository lock")
+	}
+
+	state := "active"
+	if lock.Expired(time.Now()) {
+		state = "stale"
+	}
+
+	act.printer.Printf("Repository %s is locked (%s).\n", act.repoName, state)
+	act.printer.Printf("Owner:    %s\n", lock.Owner)
+	act.printer.Printf("Hostname: %s\n", lock.Hostname)
+	act.printer.Printf("PID:      %d\n", lock.PID)
+	act.printer.Printf("Acquired: %s\n", lock.AcquiredAt.Format(time.RFC3339))
+	act.printer.Printf("Expires:  %s\n", lock.ExpiresAt.Format(time.RFC3339))
+	return nil
+}
+
+func newLockReleaseCommand() *cobra.Command {
+	act := &lockReleaseAction{
+		printer:  nil,
+		repoName: "",
+	}
+
+	cmd := &cobra.Command{
+		Use:     "release REPO",
+		Short:   "Forcibly release the repository lock.",
+		Long:    lockReleaseDesc,
+		Example: lockReleaseExample,
+		Args:    wrapPositionalArgsBadUsage(cobra.ExactArgs(1)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// No completions for the REPO argument.
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			act.printer = cmd
+			act.repoName = args[0]
+			return act.run(cmd.Context())
+		},
+	}
+
+	return cmd
+}
+
+type lockReleaseAction struct {
+	printer printer
+
+	// args
+
+	repoName string
+}
+
+func (act *lockReleaseAction) run(ctx context.Context) error {
+	repoEntry, err := helmutil.LookupRepoEntry(act.repoName)
+	if err != nil {
+		return err
+	}
+
+	store, err := storage.New(repoEntry.URL())
+	if err != nil {
+		return err
+	}
+
+	locker, err := repoLocker(store)
+	if err != nil {
+		return err
+	}
+
+	if err := locker.ForceUnlock(ctx, repoEntry.URL()); err != nil {
+		return errors.WithMessage(err, "release repository lock")
+	}
+
+	act.printer.Printf("Released the lock on repository %s.\n", act.repoName)
+	return nil
+}
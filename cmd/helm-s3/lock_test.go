@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hypnoglow/helm-s3/internal/storage"
+	"github.com/hypnoglow/helm-s3/internal/storage/storagetest"
+)
+
+func TestLock_Contention(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+
+	other := storage.NewLock(time.Minute)
+	require.NoError(t, env.store.TryLock(context.Background(), env.repoURL, "", other))
+
+	_, _, err := env.run("push", "--lock", "--lock-timeout", "0", env.chart("foo", "1.0.0"), testRepoName)
+	require.ErrorContains(t, err, "timed out waiting for the repository lock held by "+other.Hostname)
+	assert.Equal(t, errorCodeLocked, errorCode(err))
+	assert.Empty(t, env.index().Entries["foo"])
+
+	out := env.mustRun("lock", "status", testRepoName)
+	assert.Contains(t, out, "Repository test-repo is locked (active).")
+	assert.Contains(t, out, "Owner:    "+other.Owner)
+
+	out = env.mustRun("lock", "release", testRepoName)
+	assert.Contains(t, out, "Released the lock on repository test-repo.")
+
+	env.mustRun("push", "--lock", env.chart("foo", "1.0.0"), testRepoName)
+	assert.Len(t, env.index().Entries["foo"], 1)
+
+	out = env.mustRun("lock", "status", testRepoName)
+	assert.Contains(t, out, "Repository test-repo is not locked.", "the lock must be released after push")
+}
+
+func TestLock_TakesOverStaleLock(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+
+	stale := storage.NewLock(-time.Second)
+	require.NoError(t, env.store.TryLock(context.Background(), env.repoURL, "", stale))
+
+	out := env.mustRun("lock", "status", testRepoName)
+	assert.Contains(t, out, "Repository test-repo is locked (stale).")
+
+	env.mustRun("push", "--lock", "--lock-timeout", "0", env.chart("foo", "1.0.0"), testRepoName)
+	assert.Len(t, env.index().Entries["foo"], 1)
+}
+
+func TestLock_RenewsLease(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+
+	// Make the index update take longer than the lease.
+	env.store.SetLatency(40 * time.Millisecond)
+
+	out := env.mustRun("push", "--lock", "--lock-ttl", "60ms", env.chart("foo", "1.0.0"), testRepoName)
+	assert.NotContains(t, out, "WARN")
+	assert.NotContains(t, out, "ERROR")
+	assert.Len(t, env.index().Entries["foo"], 1)
+
+	renewals := 0
+	for _, call := range env.store.Calls() {
+		if call.Op == storagetest.OpRenewLock {
+			renewals++
+		}
+	}
+	assert.GreaterOrEqual(t, renewals, 1)
+}
+
+func TestLock_AbortsOnLostLease(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+
+	env.store.SetLatency(50 * time.Millisecond)
+	env.store.FailOn(storagetest.OpRenewLock, storage.ErrLockHeld)
+
+	_, stderr, err := env.run("push", "--lock", "--lock-ttl", "90ms", env.chart("foo", "1.0.0"), testRepoName)
+	require.Error(t, err)
+	assert.Contains(t, stderr, "[ERROR] The operation was aborted: the repository lock lease could not be renewed")
+	assert.Empty(t, env.index().Entries["foo"], "the index must not be updated without the lock")
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"os"
 
+	_ "github.com/hypnoglow/helm-s3/internal/awss3" // Register s3:// storage.
 	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	_ "github.com/hypnoglow/helm-s3/internal/localfs" // Register file:// storage.
 )
 
 var (
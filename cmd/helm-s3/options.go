@@ -2,21 +2,41 @@ package main
 
 import (
 	"os"
+	"strconv"
 	"time"
 )
 
 // options represents global command options (global flags).
 type options struct {
+	timeout         time.Duration
+	acl             string
+	verbose         bool
+	output          string
+	maxIndexRetries int
+	lock            lockOptions
+}
+
+// lockOptions represents options of the repository lock.
+type lockOptions struct {
+	enabled bool
 	timeout time.Duration
-	acl     string
-	verbose bool
+	ttl     time.Duration
 }
 
 // newDefaultOptions returns default options.
 func newDefaultOptions() *options {
+	lockEnabled, _ := strconv.ParseBool(os.Getenv("HELM_S3_LOCK"))
+
 	return &options{
-		timeout: 5 * time.Minute,
-		acl:     os.Getenv("S3_ACL"),
-		verbose: false,
+		timeout:         5 * time.Minute,
+		acl:             os.Getenv("S3_ACL"),
+		verbose:         false,
+		output:          outputText,
+		maxIndexRetries: 10,
+		lock: lockOptions{
+			enabled: lockEnabled,
+			timeout: time.Minute,
+			ttl:     10 * time.Minute,
+		},
 	}
 }
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+const promoteDesc = `This command copies a chart version from one repository to another.
+
+'helm s3 promote' takes three arguments:
+- NAME - name of the chart to promote,
+- FROM_REPO - repository to copy the chart from,
+- TO_REPO - repository to copy the chart to.
+
+The chart file, its provenance file and the object metadata are copied with
+server-side requests, without downloading the chart, even if the repositories
+are in different buckets or regions. The chart is placed according to the
+layout of the target repository. The index entry of the chart version is
+added to the target index as is, keeping its digest, creation time and chart
+metadata; only the chart URL is changed to point to the target repository,
+in the same style (absolute or relative) as in the source repository.
+
+With --move, the chart version is removed from the source repository after it
+is promoted. Removing charts from an immutable repository also requires
+--allow-immutable-delete.
+
+The command fails if the chart version exists in the target repository,
+unless --force is set; in an immutable target repository, existing chart
+versions cannot be replaced at all. If the target repository requires
+provenance, the chart and its provenance file are downloaded to verify them
+against --keyring before the chart is copied.
+`
+
+const promoteExample = `  helm s3 promote epicservice --version 0.5.1 staging stable - copies version 0.5.1 of epicservice from 'staging' to 'stable'.
+
+  helm s3 promote epicservice --version 0.5.1 --move dev staging - moves version 0.5.1 of epicservice from 'dev' to 'staging'.`
+
+func newPromoteCommand(opts *options) *cobra.Command {
+	act := &promoteAction{
+		printer:              nil,
+		result:               &commandResult{Command: "promote"},
+		acl:                  "",
+		maxIndexRetries:      0,
+		lock:                 lockOptions{},
+		chartName:            "",
+		fromRepoName:         "",
+		toRepoName:           "",
+		version:              "",
+		move:                 false,
+		force:                false,
+		allowImmutableDelete: false,
+		keyring:              defaultKeyring(),
+	}
+
+	cmd := &cobra.Command{
+		Use:     "promote NAME FROM_REPO TO_REPO",
+		Short:   "Copy a chart version from one repository to another.",
+		Long:    promoteDesc,
+		Example: promoteExample,
+		Args:    wrapPositionalArgsBadUsage(cobra.ExactArgs(3)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// No completions for the NAME, FROM_REPO and TO_REPO arguments.
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(opts.output, outputText, outputJSON); err != nil {
+				return err
+			}
+			act.printer = newPrinter(cmd, opts.output)
+			act.acl = opts.acl
+			act.maxIndexRetries = opts.maxIndexRetries
+			act.lock = opts.lock
+			act.chartName = args[0]
+			act.fromRepoName = args[1]
+			act.toRepoName = args[2]
+			act.result.Repo = act.toRepoName
+			return reportResult(cmd, opts.output, act.result, act.run(cmd.Context()))
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&act.version, "version", act.version, "Version of the chart to promote.")
+	flags.BoolVar(&act.move, "move", act.move, "Remove the chart version from the source repository after it is promoted.")
+	flags.BoolVar(&act.force, "force", act.force, "Replace the chart version if it exists in the target repository.")
+	flags.BoolVar(&act.allowImmutableDelete, "allow-immutable-delete", act.allowImmutableDelete, "Allow removing the chart from an immutable source repository with --move.")
+	flags.StringVar(&act.keyring, "keyring", act.keyring, "Path to the keyring with the public keys to verify the chart with, if the target repository requires provenance.")
+	_ = cobra.MarkFlagRequired(flags, "version")
+
+	return cmd
+}
+
+type promoteAction struct {
+	printer printer
+	result  *commandResult
+
+	// global flags
+
+	acl             string
+	maxIndexRetries int
+	lock            lockOptions
+
+	// args
+
+	chartName    string
+	fromRepoName string
+	toRepoName   string
+
+	// flags
+
+	version              string
+	move                 bool
+	force                bool
+	allowImmutableDelete bool
+	keyring              string
+}
+
+func (act *promoteAction) run(ctx context.Context) error {
+	fromEntry, err := helmutil.LookupRepoEntry(act.fromRepoName)
+	if err != nil {
+		return err
+	}
+	toEntry, err := helmutil.LookupRepoEntry(act.toRepoName)
+	if err != nil {
+		return err
+	}
+
+	act.result.RepoURL = toEntry.URL()
+
+	if err := checkPromoteRepos(fromEntry.URL(), toEntry.URL()); err != nil {
+		return err
+	}
+
+	fromStore, err := storage.New(fromEntry.URL())
+	if err != nil {
+		return err
+	}
+	toStore, err := storage.New(toEntry.URL())
+	if err != nil {
+		return err
+	}
+
+	fromSettings, err := fetchRepoSettings(ctx, fromStore, fromEntry.URL())
+	if err != nil {
+		return err
+	}
+	if act.move && fromSettings.Immutable && !act.allowImmutableDelete {
+		return withErrorCode(errorCodeImmutable, errors.New("the source repository is immutable, set --allow-immutable-delete to move charts anyway"))
+	}
+	toSettings, err := fetchRepoSettings(ctx, toStore, toEntry.URL())
+	if err != nil {
+		return err
+	}
+
+	fromIdx, _, err := fetchIndex(ctx, fromStore, fromEntry)
+	if err != nil {
+		return err
+	}
+	srcURL, srcEntry, ok := chartObjectURL(fromIdx, fromEntry.URL(), resolveLayout("", fromSettings), act.chartName, act.version)
+	if !ok {
+		return withErrorCode(errorCodeChartNotFound, fmt.Errorf("chart %s version %s not found in the repository %s", act.chartName, act.version, act.fromRepoName))
+	}
+	marshaled, err := fromIdx.MarshalEntry(act.chartName, act.version)
+	if err != nil {
+		return err
+	}
+
+	key := chartKey(resolveLayout("", toSettings), act.chartName, path.Base(srcURL))
+	dstURL := strings.TrimSuffix(toEntry.URL(), "/") + "/" + key
+	relative := len(srcEntry.URLs) > 0 && !strings.Contains(srcEntry.URLs[0], "://")
+	marshaled, err = withEntryURL(marshaled, escapeIfRelative(key, relative), dstURL, relative)
+	if err != nil {
+		return err
+	}
+
+	act.result.Charts = []chartResult{{
+		Name:    act.chartName,
+		Version: act.version,
+		URL:     dstURL,
+		Digest:  srcEntry.Digest,
+	}}
+
+	if toSettings.RequireProvenance {
+		if err := act.verify(ctx, fromStore, srcURL, toSettings); err != nil {
+			return err
+		}
+	}
+
+	signed, err := fromStore.Exists(ctx, srcURL+".prov")
+	if err != nil {
+		return errors.WithMessage(err, "check provenance file of the chart")
+	}
+
+	if err := act.copy(ctx, toStore, toEntry, toSettings, srcURL, dstURL, signed, marshaled); err != nil {
+		return err
+	}
+
+	if act.move {
+		if err := act.remove(ctx, fromStore, fromEntry, srcURL); err != nil {
+			return err
+		}
+		act.printer.Printf("Successfully moved the chart from %s to %s.\n", act.fromRepoName, act.toRepoName)
+		return nil
+	}
+
+	act.printer.Printf("Successfully promoted the chart from %s to %s.\n", act.fromRepoName, act.toRepoName)
+	return nil
+}
+
+// copy copies the chart files to the target repository and adds the index
+// entry to its index, under the target repository lock. signed tells whether
+// the chart has a provenance file.
+func (act *promoteAction) copy(ctx context.Context, store storage.Storage, repoEntry helmutil.RepoEntry, settings repoSettings, srcURL, dstURL string, signed bool, marshaled []byte) error {
+	ctx, unlock, err := lockRepo(ctx, store, repoEntry.URL(), act.acl, act.lock, act.printer)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Check the index before copying, so that an existing chart is not
+	// replaced by mistake. The check is repeated on the index update.
+	idx, _, err := fetchIndex(ctx, store, repoEntry)
+	if err != nil {
+		return err
+	}
+	if err := act.checkExisting(idx, settings); err != nil {
+		return err
+	}
+	replacing := idx.Has(act.chartName, act.version)
+
+	if err := store.CopyChart(ctx, srcURL, dstURL, act.acl); err != nil {
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			return withErrorCode(errorCodeChartNotFound, fmt.Errorf("chart file %s not found", srcURL))
+		}
+		return errors.WithMessage(err, "copy chart file to the target repository")
+	}
+
+	// The provenance file is copied only if the source chart has one, so the
+	// provenance file of the replaced chart must not be left next to the
+	// unsigned chart, where it would fail verification.
+	if replacing && !signed {
+		if err := store.Delete(ctx, dstURL+".prov"); err != nil {
+			return errors.WithMessage(err, "delete provenance file of the replaced chart")
+		}
+	}
+
+	idx, err = updateIndex(ctx, store, repoEntry, act.acl, act.maxIndexRetries, act.printer, func(idx helmutil.Index) error {
+		if err := act.checkExisting(idx, settings); err != nil {
+			return err
+		}
+		if idx.Has(act.chartName, act.version) {
+			if _, err := idx.Delete(act.chartName, act.version); err != nil {
+				return err
+			}
+		}
+		if err := idx.AddMarshaledEntry(marshaled); err != nil {
+			return err
+		}
+		idx.SortEntries()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := idx.WriteFile(repoEntry.CacheFile(), helmutil.DefaultIndexFilePerm); err != nil {
+		return errors.WithMessage(err, "update local index")
+	}
+	return nil
+}
+
+// checkExisting returns an error if the chart version exists in the target
+// index and may not be replaced.
+func (act *promoteAction) checkExisting(idx helmutil.Index, settings repoSettings) error {
+	if !idx.Has(act.chartName, act.version) {
+		return nil
+	}
+	if settings.Immutable {
+		return withErrorCode(errorCodeImmutable, fmt.Errorf("chart %s version %s already exists in the immutable repository %s", act.chartName, act.version, act.toRepoName))
+	}
+	if !act.force {
+		return withErrorCode(errorCodeChartExists, fmt.Errorf("chart %s version %s already exists in the repository %s, set --force to replace it", act.chartName, act.version, act.toRepoName))
+	}
+	return nil
+}
+
+// remove removes the chart version from the source repository.
+func (act *promoteAction) remove(ctx context.Context, store storage.Storage, repoEntry helmutil.RepoEntry, srcURL string) error {
+	ctx, unlock, err := lockRepo(ctx, store, repoEntry.URL(), act.acl, act.lock, act.printer)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	idx, err := updateIndex(ctx, store, repoEntry, act.acl, act.maxIndexRetries, act.printer, func(idx helmutil.Index) error {
+		if !idx.Has(act.chartName, act.version) {
+			return nil
+		}
+		_, err := idx.Delete(act.chartName, act.version)
+		return err
+	})
+	if err != nil {
+		return errors.WithMessage(err, "remove the chart from the source repository index")
+	}
+
+	if err := idx.WriteFile(repoEntry.CacheFile(), helmutil.DefaultIndexFilePerm); err != nil {
+		return errors.WithMessage(err, "update local index of the source repository")
+	}
+
+	if err := store.DeleteChart(ctx, srcURL); err != nil {
+		return errors.WithMessage(err, "delete chart file from the source repository")
+	}
+	return nil
+}
+
+// verify downloads the chart and its provenance file, and enforces the
+// provenance policy of the target repository.
+func (act *promoteAction) verify(ctx context.Context, store storage.Storage, srcURL string, settings repoSettings) error {
+	tmpDir, err := os.MkdirTemp("", "helm-s3-promote-")
+	if err != nil {
+		return errors.Wrap(err, "create temporary directory for chart files")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	chartPath := filepath.Join(tmpDir, path.Base(srcURL))
+	for _, file := range []struct{ url, path string }{{srcURL, chartPath}, {srcURL + ".prov", chartPath + ".prov"}} {
+		b, _, err := store.FetchRaw(ctx, file.url)
+		if errors.Is(err, storage.ErrObjectNotFound) && file.url != srcURL {
+			err = fmt.Errorf("chart %s %s is not signed: provenance file %s not found", act.chartName, act.version, file.url)
+			return withErrorCode(errorCodePolicyViolation, errors.WithMessage(err, "the target repository requires provenance"))
+		}
+		if err != nil {
+			return errors.WithMessagef(err, "fetch %s", file.url)
+		}
+		if err := os.WriteFile(file.path, b, 0o600); err != nil {
+			return errors.Wrap(err, "write chart file")
+		}
+	}
+
+	return verifySignedChart(act.chartName, act.version, chartPath, chartPath+".prov", act.keyring, settings)
+}
+
+// checkPromoteRepos returns an error if charts cannot be promoted between
+// the repositories: the repositories are the same, or use different storages.
+func checkPromoteRepos(fromURL, toURL string) error {
+	if strings.TrimSuffix(fromURL, "/") == strings.TrimSuffix(toURL, "/") {
+		return newBadUsageError(errors.New("the source and the target repositories are the same"))
+	}
+
+	from, err := url.Parse(fromURL)
+	if err != nil {
+		return errors.Wrapf(err, "parse repository url %s", fromURL)
+	}
+	to, err := url.Parse(toURL)
+	if err != nil {
+		return errors.Wrapf(err, "parse repository url %s", toURL)
+	}
+	if from.Scheme != to.Scheme {
+		return newBadUsageError(fmt.Errorf("cannot promote charts from a %s repository to a %s repository", from.Scheme, to.Scheme))
+	}
+	return nil
+}
+
+// withEntryURL returns the index entry encoded by Index.MarshalEntry with
+// the chart URL replaced: with the relative one if relative is true,
+// otherwise with the absolute one.
+func withEntryURL(marshaled []byte, relativeURL, absoluteURL string, relative bool) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(marshaled, &fields); err != nil {
+		return nil, errors.Wrap(err, "unmarshal index entry")
+	}
+
+	u := absoluteURL
+	if relative {
+		u = relativeURL
+	}
+	urls, err := json.Marshal([]string{u})
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal chart urls")
+	}
+	fields["urls"] = urls
+
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal index entry")
+	}
+	return b, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/repo"
+
+	"github.com/hypnoglow/helm-s3/internal/storage/storagetest"
+)
+
+// testTargetRepoName is the name of the target repository set up by
+// addTargetRepo.
+const testTargetRepoName = "target-repo"
+
+// addTargetRepo initializes a repository in another bucket with the init
+// args, and adds it as testTargetRepoName next to testRepoName, returning
+// its storage and URL.
+func addTargetRepo(env *testEnv, args ...string) (*storagetest.Memory, string) {
+	env.t.Helper()
+
+	store := storagetest.NewMemory(env.t)
+	repoURL := store.URL() + "/charts"
+	env.mustRun(append(append([]string{"init"}, args...), repoURL)...)
+
+	repoFile := repo.NewFile()
+	repoFile.Add(
+		&repo.Entry{Name: testRepoName, URL: env.repoURL},
+		&repo.Entry{Name: testTargetRepoName, URL: repoURL},
+	)
+	require.NoError(env.t, repoFile.WriteFile(env.repoConfig, 0o644))
+	return store, repoURL
+}
+
+func TestPromote(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	keyring := env.keyring("Test Signer")
+	env.mustRun("push", "--sign", "--key", "Test Signer", "--keyring", keyring, env.chart("foo", "1.0.0"), testRepoName)
+	env.mustRun("push", env.chart("foo", "1.1.0"), testRepoName)
+	target, targetURL := addTargetRepo(env, "--layout", "nested")
+
+	out := env.mustRun("promote", "foo", "--version", "1.0.0", testRepoName, testTargetRepoName)
+	assert.Contains(t, out, "Successfully promoted the chart from test-repo to target-repo.")
+
+	src, ok := env.store.Get(env.repoURL + "/foo-1.0.0.tgz")
+	require.True(t, ok)
+	dst, ok := target.Get(targetURL + "/foo/foo-1.0.0.tgz")
+	require.True(t, ok, "the chart must be placed by the target layout")
+	assert.Equal(t, src.Data, dst.Data)
+	assert.Equal(t, src.Metadata, dst.Metadata)
+	_, ok = target.Get(targetURL + "/foo/foo-1.0.0.tgz.prov")
+	assert.True(t, ok, "the provenance file must be copied")
+
+	srcEntry := env.index().Entries["foo"][1]
+	require.Equal(t, "1.0.0", srcEntry.Version)
+	idx := loadIndex(t, target, targetURL)
+	require.Len(t, idx.Entries["foo"], 1)
+	dstEntry := idx.Entries["foo"][0]
+	assert.Equal(t, srcEntry.Digest, dstEntry.Digest)
+	assert.True(t, srcEntry.Created.Equal(dstEntry.Created), "the creation time must be kept")
+	assert.Equal(t, []string{targetURL + "/foo/foo-1.0.0.tgz"}, dstEntry.URLs)
+
+	assert.Len(t, env.index().Entries["foo"], 2, "the source repository must not change")
+
+	t.Run("should fail on existing version", func(t *testing.T) {
+		_, _, err := env.run("promote", "foo", "--version", "1.0.0", testRepoName, testTargetRepoName)
+		require.ErrorContains(t, err, "chart foo version 1.0.0 already exists in the repository target-repo, set --force to replace it")
+		assert.Equal(t, errorCodeChartExists, errorCode(err))
+	})
+
+	t.Run("should replace existing version with force", func(t *testing.T) {
+		env.mustRun("promote", "--force", "foo", "--version", "1.0.0", testRepoName, testTargetRepoName)
+		assert.Len(t, loadIndex(t, target, targetURL).Entries["foo"], 1)
+	})
+
+	t.Run("should fail on unknown version", func(t *testing.T) {
+		_, _, err := env.run("promote", "foo", "--version", "2.0.0", testRepoName, testTargetRepoName)
+		require.ErrorContains(t, err, "chart foo version 2.0.0 not found in the repository test-repo")
+		assert.Equal(t, errorCodeChartNotFound, errorCode(err))
+	})
+
+	t.Run("should fail on the same repository", func(t *testing.T) {
+		_, _, err := env.run("promote", "foo", "--version", "1.0.0", testRepoName, testRepoName)
+		require.ErrorContains(t, err, "the source and the target repositories are the same")
+	})
+}
+
+func TestPromote_Move(t *testing.T) {
+	env := newTestEnv(t)
+	env.mustRun("init", "--immutable", env.repoURL)
+	env.addRepo()
+	env.mustRun("push", "--relative", env.chart("foo", "1.0.0"), testRepoName)
+	target, targetURL := addTargetRepo(env)
+
+	_, _, err := env.run("promote", "--move", "foo", "--version", "1.0.0", testRepoName, testTargetRepoName)
+	require.ErrorContains(t, err, "the source repository is immutable, set --allow-immutable-delete")
+	assert.Equal(t, errorCodeImmutable, errorCode(err))
+	_, ok := target.Get(targetURL + "/foo-1.0.0.tgz")
+	assert.False(t, ok)
+
+	stdout, stderr, err := env.run("promote", "-o", "json", "--move", "--allow-immutable-delete", "foo", "--version", "1.0.0", testRepoName, testTargetRepoName)
+	require.NoError(t, err, stderr)
+
+	var res commandResult
+	require.NoError(t, json.Unmarshal([]byte(stdout), &res))
+	assert.Equal(t, "promote", res.Command)
+	assert.Equal(t, targetURL, res.RepoURL)
+	require.Len(t, res.Charts, 1)
+	assert.Equal(t, targetURL+"/foo-1.0.0.tgz", res.Charts[0].URL)
+
+	_, ok = target.Get(targetURL + "/foo-1.0.0.tgz")
+	assert.True(t, ok)
+	_, ok = env.store.Get(env.repoURL + "/foo-1.0.0.tgz")
+	assert.False(t, ok, "the chart must be removed from the source repository")
+	assert.Empty(t, env.index().Entries["foo"])
+
+	idx := loadIndex(t, target, targetURL)
+	require.Len(t, idx.Entries["foo"], 1)
+	assert.Equal(t, []string{"foo-1.0.0.tgz"}, idx.Entries["foo"][0].URLs, "relative URLs must stay relative")
+}
+
+func TestPromote_ReplaceSignedWithUnsigned(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	keyring := env.keyring("Test Signer")
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+	target, targetURL := addTargetRepo(env)
+	env.mustRun("push", "--sign", "--key", "Test Signer", "--keyring", keyring, env.chart("foo", "1.0.0"), testTargetRepoName)
+	_, ok := target.Get(targetURL + "/foo-1.0.0.tgz.prov")
+	require.True(t, ok)
+
+	env.mustRun("promote", "--force", "foo", "--version", "1.0.0", testRepoName, testTargetRepoName)
+
+	_, ok = target.Get(targetURL + "/foo-1.0.0.tgz")
+	assert.True(t, ok)
+	_, ok = target.Get(targetURL + "/foo-1.0.0.tgz.prov")
+	assert.False(t, ok, "the provenance file of the replaced chart must be removed")
+}
+
+func TestPromote_RequireProvenance(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	keyring := env.keyring("Test Signer")
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+	env.mustRun("push", "--sign", "--key", "Test Signer", "--keyring", keyring, env.chart("foo", "1.1.0"), testRepoName)
+	target, targetURL := addTargetRepo(env, "--require-provenance")
+
+	_, _, err := env.run("promote", "--keyring", keyring, "foo", "--version", "1.0.0", testRepoName, testTargetRepoName)
+	require.ErrorContains(t, err, "the target repository requires provenance: chart foo 1.0.0 is not signed")
+	assert.Equal(t, errorCodePolicyViolation, errorCode(err))
+	_, ok := target.Get(targetURL + "/foo-1.0.0.tgz")
+	assert.False(t, ok)
+
+	env.mustRun("promote", "--keyring", keyring, "foo", "--version", "1.1.0", testRepoName, testTargetRepoName)
+	assert.Len(t, loadIndex(t, target, targetURL).Entries["foo"], 1)
+}
+
+// loadIndex fetches the index of the repository by url from the storage.
+func loadIndex(t *testing.T, store *storagetest.Memory, repoURL string) *repo.IndexFile {
+	t.Helper()
+
+	env := &testEnv{t: t, store: store, repoURL: repoURL}
+	return env.index()
+}
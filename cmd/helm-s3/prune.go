@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+const pruneDesc = `This command removes chart versions from the repository according to
+a retention policy.
+
+'helm s3 prune' takes one argument:
+- REPO - target repository.
+
+[Retention policy]
+
+A chart version is removed only if it matches all of the set policies:
+- --older-than: the version was created earlier than the age ago, e.g. '90d'
+  or '36h'. Versions with unknown creation time are kept.
+- --prereleases-only: the version is a pre-release, e.g. '1.0.0-rc.1'.
+- --keep-last: the version is not among the N latest versions of the chart
+  that match the other policies, by semver order.
+
+At least one of these flags is required. Versions matching the --keep-semver
+constraint, e.g. '>=2.0.0', and versions that are not valid semver are always
+kept.
+
+The index is updated once, and then the chart files are removed concurrently;
+use --concurrency to tune the number of requests made at once.
+
+[Dry run]
+
+With --dry-run, the versions that would be removed are printed, but nothing is
+removed.
+
+[Immutability]
+
+If the repository is immutable (see 'helm s3 init --immutable'), pruning
+requires --allow-immutable-delete.
+`
+
+const pruneExample = `  helm s3 prune --prereleases-only --older-than 30d my-repo - removes pre-release versions older than 30 days.
+
+  helm s3 prune --keep-last 10 --keep-semver '>=2.0.0' my-repo - keeps 10 latest versions of each chart, and all versions starting from 2.0.0.
+
+  helm s3 prune --keep-last 10 --dry-run my-repo - prints versions that would be removed.`
+
+// defaultPruneConcurrency is the default number of chart files removed
+// concurrently.
+const defaultPruneConcurrency = 10
+
+func newPruneCommand(opts *options) *cobra.Command {
+	act := &pruneAction{
+		printer:              nil,
+		result:               &commandResult{Command: "prune"},
+		acl:                  "",
+		maxIndexRetries:      0,
+		lock:                 lockOptions{},
+		repoName:             "",
+		keepLast:             0,
+		olderThan:            "",
+		prereleasesOnly:      false,
+		keepSemver:           "",
+		dryRun:               false,
+		concurrency:          defaultPruneConcurrency,
+		allowImmutableDelete: false,
+	}
+
+	cmd := &cobra.Command{
+		Use:     "prune REPO",
+		Short:   "Remove chart versions according to a retention policy.",
+		Long:    pruneDesc,
+		Example: pruneExample,
+		Args:    wrapPositionalArgsBadUsage(cobra.ExactArgs(1)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// No completions for the REPO argument.
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(opts.output, outputText, outputJSON); err != nil {
+				return err
+			}
+			act.printer = newPrinter(cmd, opts.output)
+			act.acl = opts.acl
+			act.maxIndexRetries = opts.maxIndexRetries
+			act.lock = opts.lock
+			act.repoName = args[0]
+			act.result.Repo = act.repoName
+			act.result.DryRun = act.dryRun
+			return reportResult(cmd, opts.output, act.result, act.run(cmd.Context()))
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.IntVar(&act.keepLast, "keep-last", act.keepLast, "Keep the N latest versions of each chart.")
+	flags.StringVar(&act.olderThan, "older-than", act.olderThan, "Remove only versions created earlier than the age ago, e.g. 90d.")
+	flags.BoolVar(&act.prereleasesOnly, "prereleases-only", act.prereleasesOnly, "Remove only pre-release versions.")
+	flags.StringVar(&act.keepSemver, "keep-semver", act.keepSemver, "Keep versions matching the semver constraint.")
+	flags.BoolVar(&act.dryRun, "dry-run", act.dryRun, "Print versions that would be removed, but don't remove them.")
+	flags.IntVar(&act.concurrency, "concurrency", act.concurrency, "Maximum number of chart files removed concurrently.")
+	flags.BoolVar(&act.allowImmutableDelete, "allow-immutable-delete", act.allowImmutableDelete, "Allow removing charts from an immutable repository.")
+
+	return cmd
+}
+
+type pruneAction struct {
+	printer printer
+	result  *commandResult
+
+	// global flags
+
+	acl             string
+	maxIndexRetries int
+	lock            lockOptions
+
+	// args
+
+	repoName string
+
+	// flags
+
+	keepLast             int
+	olderThan            string
+	prereleasesOnly      bool
+	keepSemver           string
+	dryRun               bool
+	concurrency          int
+	allowImmutableDelete bool
+}
+
+// prunePolicy describes which chart versions to prune.
+type prunePolicy struct {
+	// KeepLast is the number of latest matching versions of each chart to
+	// keep. Zero means none.
+	KeepLast int
+
+	// CreatedBefore, if set, makes only versions created before it match.
+	CreatedBefore time.Time
+
+	// PrereleasesOnly makes only pre-release versions match.
+	PrereleasesOnly bool
+
+	// Keep, if set, excludes versions matching it.
+	Keep *semver.Constraints
+}
+
+func (act *pruneAction) run(ctx context.Context) error {
+	policy, err := act.policy(time.Now())
+	if err != nil {
+		return err
+	}
+	if act.concurrency < 1 {
+		return newBadUsageError(errors.New("--concurrency must be a positive number"))
+	}
+
+	repoEntry, err := helmutil.LookupRepoEntry(act.repoName)
+	if err != nil {
+		return err
+	}
+
+	act.result.RepoURL = repoEntry.URL()
+
+	store, err := storage.New(repoEntry.URL())
+	if err != nil {
+		return err
+	}
+
+	idx, _, err := fetchIndex(ctx, store, repoEntry)
+	if err != nil {
+		return err
+	}
+	pruned := planPrune(idx.Entries(), policy)
+	act.setResult(repoEntry.URL(), pruned)
+	if len(pruned) == 0 {
+		act.printer.Printf("Nothing to prune.\n")
+		return nil
+	}
+	if act.dryRun {
+		act.printPlan("Would remove", pruned)
+		return nil
+	}
+
+	settings, err := fetchRepoSettings(ctx, store, repoEntry.URL())
+	if err != nil {
+		return err
+	}
+	if settings.Immutable && !act.allowImmutableDelete {
+		return withErrorCode(errorCodeImmutable, errors.New("the repository is immutable, set --allow-immutable-delete to prune charts anyway"))
+	}
+
+	ctx, unlock, err := lockRepo(ctx, store, repoEntry.URL(), act.acl, act.lock, act.printer)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// The plan is computed again on each attempt, so that versions pushed or
+	// removed since the index was fetched are taken into account.
+	idx, err = updateIndex(ctx, store, repoEntry, act.acl, act.maxIndexRetries, act.printer, func(idx helmutil.Index) error {
+		pruned = planPrune(idx.Entries(), policy)
+		for _, entry := range pruned {
+			if _, err := idx.Delete(entry.Name, entry.Version); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	act.setResult(repoEntry.URL(), pruned)
+	if len(pruned) == 0 {
+		act.printer.Printf("Nothing to prune.\n")
+		return nil
+	}
+	act.printPlan("Removed", pruned)
+
+	if err := idx.WriteFile(repoEntry.CacheFile(), helmutil.DefaultIndexFilePerm); err != nil {
+		return errors.WithMessage(err, "update local index")
+	}
+
+	// Delete chart files last; a failure here leaves orphans, not broken links.
+	err = forEachConcurrently(ctx, len(act.result.Charts), act.concurrency, func(ctx context.Context, i int) error {
+		url := act.result.Charts[i].URL
+		if url == "" {
+			return nil
+		}
+		if err := store.DeleteChart(ctx, url); err != nil {
+			return errors.WithMessagef(err, "delete chart file %s", url)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	act.printer.Printf("Successfully pruned %d chart versions from the repository.\n", len(pruned))
+	return nil
+}
+
+// policy returns the prune policy set by the flags.
+func (act *pruneAction) policy(now time.Time) (prunePolicy, error) {
+	if act.keepLast < 0 {
+		return prunePolicy{}, newBadUsageError(errors.New("--keep-last must not be negative"))
+	}
+	if act.keepLast == 0 && act.olderThan == "" && !act.prereleasesOnly {
+		return prunePolicy{}, newBadUsageError(errors.New("at least one of --keep-last, --older-than and --prereleases-only flags is required"))
+	}
+
+	policy := prunePolicy{
+		KeepLast:        act.keepLast,
+		PrereleasesOnly: act.prereleasesOnly,
+	}
+
+	if act.olderThan != "" {
+		age, err := parseAge(act.olderThan)
+		if err != nil {
+			return prunePolicy{}, newBadUsageError(fmt.Errorf("invalid --older-than value %q: %v", act.olderThan, err))
+		}
+		policy.CreatedBefore = now.Add(-age)
+	}
+
+	if act.keepSemver != "" {
+		c, err := semver.NewConstraint(act.keepSemver)
+		if err != nil {
+			return prunePolicy{}, newBadUsageError(fmt.Errorf("invalid --keep-semver constraint %q: %v", act.keepSemver, err))
+		}
+		policy.Keep = c
+	}
+
+	return policy, nil
+}
+
+func (act *pruneAction) setResult(repoURL string, pruned []helmutil.IndexEntry) {
+	act.result.Charts = make([]chartResult, 0, len(pruned))
+	for _, entry := range pruned {
+		var url string
+		if len(entry.URLs) > 0 {
+			url = absoluteChartURL(repoURL, entry.URLs[0])
+		}
+		act.result.Charts = append(act.result.Charts, chartResult{
+			Name:    entry.Name,
+			Version: entry.Version,
+			URL:     url,
+			Digest:  entry.Digest,
+		})
+	}
+}
+
+func (act *pruneAction) printPlan(verb string, pruned []helmutil.IndexEntry) {
+	act.printer.Printf("%s %d chart versions:\n", verb, len(pruned))
+	for _, entry := range pruned {
+		created := "unknown"
+		if !entry.Created.IsZero() {
+			created = entry.Created.UTC().Format(time.RFC3339)
+		}
+		act.printer.Printf("  - %s %s (created %s)\n", entry.Name, entry.Version, created)
+	}
+}
+
+// planPrune returns the index entries to prune according to the policy,
+// ordered by chart name and by version in descending order.
+func planPrune(entries []helmutil.IndexEntry, policy prunePolicy) []helmutil.IndexEntry {
+	type candidate struct {
+		entry   helmutil.IndexEntry
+		version *semver.Version
+	}
+
+	byChart := make(map[string][]candidate)
+	var names []string
+	for _, entry := range entries {
+		v, err := semver.NewVersion(entry.Version)
+		if err != nil {
+			// The order is unknown, so the version is kept.
+			continue
+		}
+		if policy.Keep != nil && policy.Keep.Check(v) {
+			continue
+		}
+		if policy.PrereleasesOnly && v.Prerelease() == "" {
+			continue
+		}
+
+		if _, ok := byChart[entry.Name]; !ok {
+			names = append(names, entry.Name)
+		}
+		byChart[entry.Name] = append(byChart[entry.Name], candidate{entry: entry, version: v})
+	}
+	sort.Strings(names)
+
+	var pruned []helmutil.IndexEntry
+	for _, name := range names {
+		candidates := byChart[name]
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].version.GreaterThan(candidates[j].version)
+		})
+
+		for i, c := range candidates {
+			if i < policy.KeepLast {
+				continue
+			}
+			if !policy.CreatedBefore.IsZero() && (c.entry.Created.IsZero() || !c.entry.Created.Before(policy.CreatedBefore)) {
+				continue
+			}
+			pruned = append(pruned, c.entry)
+		}
+	}
+	return pruned
+}
+
+// parseAge parses the age, which is either a number of days, e.g. '90d', or
+// a duration, e.g. '36h'.
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, errors.New("expected a number of days, e.g. 90d")
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, errors.New("age must not be negative")
+	}
+	return d, nil
+}
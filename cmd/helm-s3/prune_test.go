@@ -0,0 +1,157 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+)
+
+func TestPrune(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	env.mustRun("push",
+		env.chart("foo", "1.0.0"), env.chart("foo", "1.1.0"), env.chart("foo", "1.2.0-rc.1"), env.chart("foo", "2.0.0"),
+		env.chart("bar", "0.1.0"), env.chart("bar", "0.2.0"),
+		testRepoName,
+	)
+
+	t.Run("should print the plan on dry run", func(t *testing.T) {
+		out := env.mustRun("prune", "--keep-last", "1", "--dry-run", testRepoName)
+		assert.Contains(t, out, "Would remove 4 chart versions:\n")
+		assert.Contains(t, out, "  - bar 0.1.0 (created ")
+		assert.Contains(t, out, "  - foo 1.0.0 (created ")
+		assert.Len(t, env.index().Entries["foo"], 4)
+	})
+
+	out := env.mustRun("prune", "--keep-last", "1", "--keep-semver", ">=1.1.0", testRepoName)
+	assert.Contains(t, out, "Successfully pruned 2 chart versions from the repository.")
+
+	idx := env.index()
+	var versions []string
+	for _, v := range idx.Entries["foo"] {
+		versions = append(versions, v.Version)
+	}
+	assert.ElementsMatch(t, []string{"1.1.0", "1.2.0-rc.1", "2.0.0"}, versions, "versions matching --keep-semver must be kept")
+	assert.Len(t, idx.Entries["bar"], 1)
+	_, ok := env.store.Get(env.repoURL + "/bar-0.1.0.tgz")
+	assert.False(t, ok)
+	_, ok = env.store.Get(env.repoURL + "/bar-0.2.0.tgz")
+	assert.True(t, ok)
+
+	t.Run("should do nothing if nothing matches", func(t *testing.T) {
+		out := env.mustRun("prune", "--older-than", "90d", testRepoName)
+		assert.Contains(t, out, "Nothing to prune.")
+	})
+
+	t.Run("should require a policy", func(t *testing.T) {
+		_, _, err := env.run("prune", "--keep-semver", ">=1.0.0", testRepoName)
+		require.ErrorContains(t, err, "at least one of --keep-last, --older-than and --prereleases-only flags is required")
+		assert.Equal(t, errorCodeBadUsage, errorCode(err))
+	})
+}
+
+func TestPrune_Immutable(t *testing.T) {
+	env := newTestEnv(t)
+	env.mustRun("init", "--immutable", env.repoURL)
+	env.addRepo()
+	env.mustRun("push", env.chart("foo", "1.0.0-rc.1"), testRepoName)
+
+	_, _, err := env.run("prune", "--prereleases-only", testRepoName)
+	require.ErrorContains(t, err, "--allow-immutable-delete")
+	assert.Equal(t, errorCodeImmutable, errorCode(err))
+
+	env.mustRun("prune", "--prereleases-only", "--allow-immutable-delete", testRepoName)
+	assert.NotContains(t, env.index().Entries, "foo")
+}
+
+func TestPlanPrune(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	entry := func(version string, age time.Duration) helmutil.IndexEntry {
+		var created time.Time
+		if age > 0 {
+			created = now.Add(-age)
+		}
+		return helmutil.IndexEntry{Name: "foo", Version: version, Created: created}
+	}
+	day := 24 * time.Hour
+
+	entries := []helmutil.IndexEntry{
+		entry("1.0.0", 100*day),
+		entry("1.1.0-rc.1", 95*day),
+		entry("1.1.0", 90*day),
+		entry("2.0.0-rc.1", 10*day),
+		entry("2.0.0", 5*day),
+		entry("2.1.0", 0),
+		entry("latest", 200*day),
+		{Name: "bar", Version: "0.1.0", Created: now.Add(-300 * day)},
+	}
+
+	testCases := map[string]struct {
+		policy prunePolicy
+		want   []string
+	}{
+		"keep last": {
+			policy: prunePolicy{KeepLast: 2},
+			want:   []string{"foo 2.0.0-rc.1", "foo 1.1.0", "foo 1.1.0-rc.1", "foo 1.0.0"},
+		},
+		"older than": {
+			policy: prunePolicy{CreatedBefore: now.Add(-30 * day)},
+			want:   []string{"bar 0.1.0", "foo 1.1.0", "foo 1.1.0-rc.1", "foo 1.0.0"},
+		},
+		"prereleases only": {
+			policy: prunePolicy{PrereleasesOnly: true},
+			want:   []string{"foo 2.0.0-rc.1", "foo 1.1.0-rc.1"},
+		},
+		"keep last prereleases": {
+			policy: prunePolicy{KeepLast: 1, PrereleasesOnly: true},
+			want:   []string{"foo 1.1.0-rc.1"},
+		},
+		"keep semver": {
+			policy: prunePolicy{KeepLast: 1, Keep: mustConstraint(t, ">=2.0.0")},
+			want:   []string{"foo 1.1.0", "foo 1.1.0-rc.1", "foo 1.0.0"},
+		},
+		"all policies": {
+			policy: prunePolicy{KeepLast: 1, CreatedBefore: now.Add(-1 * day), Keep: mustConstraint(t, "^2.0.0")},
+			want:   []string{"foo 1.1.0", "foo 1.1.0-rc.1", "foo 1.0.0"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var got []string
+			for _, e := range planPrune(entries, tc.policy) {
+				got = append(got, e.Name+" "+e.Version)
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	d, err := parseAge("90d")
+	require.NoError(t, err)
+	assert.Equal(t, 90*24*time.Hour, d)
+
+	d, err = parseAge("36h")
+	require.NoError(t, err)
+	assert.Equal(t, 36*time.Hour, d)
+
+	_, err = parseAge("xd")
+	assert.Error(t, err)
+
+	_, err = parseAge("-1h")
+	assert.Error(t, err)
+}
+
+func mustConstraint(t *testing.T, s string) *semver.Constraints {
+	t.Helper()
+
+	c, err := semver.NewConstraint(s)
+	require.NoError(t, err)
+	return c
+}
@@ -3,29 +3,98 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
-	"github.com/hypnoglow/helm-s3/internal/awss3"
-	"github.com/hypnoglow/helm-s3/internal/awsutil"
 	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
 )
 
-const pushDesc = `This command uploads a chart to the repository.
+const pushDesc = `This command uploads one or more charts to the repository.
 
-'helm s3 push' takes two arguments:
-- PATH - path to the chart file,
+'helm s3 push' takes two or more arguments:
+- PATH - path to the chart file or the chart directory, can be repeated,
 - REPO - target repository.
 
+[Chart directory]
+
+If PATH is a chart directory, the chart is packaged before the upload, like
+'helm package' does: files matching patterns in the .helmignore file are
+excluded, the chart version and the app version can be overridden with
+--version and --app-version (only when a single chart directory is pushed;
+the version must be a valid semantic version), and dependencies can be updated first with
+--dependency-update (use --skip-refresh to not refresh the local repository
+cache first, like 'helm dependency update --skip-refresh'). The archive is
+created in a temporary directory, which is removed afterwards.
+
+[Multiple charts]
+
+Several charts can be pushed at once: pass several PATH arguments, a glob
+pattern like './dist/*.tgz', or a directory that contains chart archives.
+The charts are uploaded concurrently, use --concurrency to tune the number of
+uploads made at once, and then added to the index in a single update.
+
+The push is all or nothing: if any of the charts already exists (and neither
+--force nor --ignore-if-exists is set), nothing is uploaded; if an upload or
+the index update fails, the charts uploaded so far are removed.
+
 [Provenance]
 
 If the chart is signed, the provenance file is uploaded to the repository as well.
+
+With --sign, the chart is signed before the upload, like 'helm package --sign'
+does, and the generated provenance file is uploaded instead of the one next to
+the chart, if any. The key is looked up by --key in --keyring. If the key is
+encrypted, its passphrase is read from --passphrase-file ('-' for stdin).
+
+With --verify, the provenance file is checked before the upload: it must be
+signed with a key from --keyring, and the chart digest in it must match the
+chart archive. Charts without a provenance file fail the verification. If any
+chart fails the verification, nothing is uploaded.
+
+If the repository requires provenance (see 'helm s3 init --require-provenance'),
+charts are always verified, and must be signed with one of the keys allowed by
+the repository policy, if any.
+
+If the repository is immutable (see 'helm s3 init --immutable'), --force is
+refused.
+
+[Idempotent push]
+
+With --idempotent, the push can be safely re-run, e.g. by a CI job: if the chart
+already exists in the repository with the same digest, it is skipped; if it
+exists with a different digest, the push fails and nothing is uploaded. The
+digest of the existing chart is taken from the object metadata recorded on
+push, or from the index if the object has none.
+
+[Layout]
+
+By default, the chart is uploaded to the repository root. If the repository
+uses the nested layout (see 'helm s3 init --layout'), or --layout nested is
+set, the chart is uploaded to a directory named after the chart, e.g.
+'epicservice/epicservice-0.5.1.tgz'.
 `
 
-const pushExample = `  helm s3 push ./epicservice-0.5.1.tgz my-repo - uploads chart file 'epicservice-0.5.1.tgz' from the current directory to the repository with name 'my-repo'.`
+const pushExample = `  helm s3 push ./epicservice-0.5.1.tgz my-repo - uploads chart file 'epicservice-0.5.1.tgz' from the current directory to the repository with name 'my-repo'.
+
+  helm s3 push --version 0.5.2 ./epicservice my-repo - packages chart directory 'epicservice' as version 0.5.2 and uploads it to the repository with name 'my-repo'.
+
+  helm s3 push './dist/*.tgz' my-repo - uploads all chart files from the 'dist' directory in a single index update.
+
+  helm s3 push --sign --key 'John Smith' --keyring ~/.gnupg/secring.gpg ./epicservice-0.5.1.tgz my-repo - signs chart file 'epicservice-0.5.1.tgz' and uploads it along with the provenance file.
+
+  helm s3 push --verify --keyring ~/.gnupg/pubring.gpg ./epicservice-0.5.1.tgz my-repo - verifies the provenance file of chart file 'epicservice-0.5.1.tgz' and uploads it.`
+
+// defaultPushConcurrency is the default number of charts uploaded
+// concurrently.
+const defaultPushConcurrency = 10
 
 func newPushCommand(opts *options) *cobra.Command {
 	contentTypeDefault := os.Getenv("S3_CHART_CONTENT_TYPE")
@@ -34,46 +103,77 @@ func newPushCommand(opts *options) *cobra.Command {
 	}
 
 	act := &pushAction{
-		printer:        nil,
-		acl:            "",
-		chartPath:      "",
-		repoName:       "",
-		contentType:    contentTypeDefault,
-		dryRun:         false,
-		force:          false,
-		ignoreIfExists: false,
-		relative:       false,
+		printer:          nil,
+		result:           &commandResult{Command: "push"},
+		acl:              "",
+		maxIndexRetries:  0,
+		lock:             lockOptions{},
+		chartPaths:       nil,
+		repoName:         "",
+		contentType:      contentTypeDefault,
+		dryRun:           false,
+		force:            false,
+		ignoreIfExists:   false,
+		idempotent:       false,
+		relative:         false,
+		layout:           "",
+		concurrency:      defaultPushConcurrency,
+		version:          "",
+		appVersion:       "",
+		dependencyUpdate: false,
+		skipRefresh:      false,
+		sign:             false,
+		key:              "",
+		keyring:          defaultKeyring(),
+		passphraseFile:   "",
+		verify:           false,
 	}
 
 	cmd := &cobra.Command{
-		Use:     "push PATH REPO",
-		Short:   "Push chart to the repository.",
+		Use:     "push PATH... REPO",
+		Short:   "Push charts to the repository.",
 		Long:    pushDesc,
 		Example: pushExample,
-		Args:    wrapPositionalArgsBadUsage(cobra.ExactArgs(2)),
+		Args:    wrapPositionalArgsBadUsage(cobra.MinimumNArgs(2)),
 		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
-			if len(args) == 0 {
-				// Allow file completion for the PATH argument.
-				return nil, cobra.ShellCompDirectiveDefault
-			}
-			// No completions for the REPO argument.
-			return nil, cobra.ShellCompDirectiveNoFileComp
+			// Allow file completion for the PATH arguments. The REPO argument
+			// is the last one, so it cannot be told apart from PATH.
+			return nil, cobra.ShellCompDirectiveDefault
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			act.printer = cmd
+			if err := validateOutput(opts.output, outputText, outputJSON); err != nil {
+				return err
+			}
+			act.printer = newPrinter(cmd, opts.output)
 			act.acl = opts.acl
-			act.chartPath = args[0]
-			act.repoName = args[1]
-			return act.run(cmd.Context())
+			act.maxIndexRetries = opts.maxIndexRetries
+			act.lock = opts.lock
+			act.chartPaths = args[:len(args)-1]
+			act.repoName = args[len(args)-1]
+			act.result.Repo = act.repoName
+			act.result.DryRun = act.dryRun
+			return reportResult(cmd, opts.output, act.result, act.run(cmd.Context()))
 		},
 	}
 
 	flags := cmd.Flags()
 	flags.StringVar(&act.contentType, "content-type", act.contentType, "Set the content-type for the chart file. Can be sourced from S3_CHART_CONTENT_TYPE environment variable.")
 	flags.BoolVar(&act.dryRun, "dry-run", act.dryRun, "Simulate push operation, but don't actually touch anything.")
-	flags.BoolVar(&act.force, "force", act.force, "Replace the chart if it already exists. This can cause the repository to lose existing chart; use it with care.")
+	flags.BoolVar(&act.force, "force", act.force, "Replace the chart if it already exists. This can cause the repository to lose existing chart; use it with care. Not allowed in immutable repositories.")
 	flags.BoolVar(&act.ignoreIfExists, "ignore-if-exists", act.ignoreIfExists, "If the chart already exists, exit normally and do not trigger an error.")
+	flags.BoolVar(&act.idempotent, "idempotent", act.idempotent, "If the chart already exists with the same digest, exit normally; if the digest differs, fail.")
 	flags.BoolVar(&act.relative, "relative", act.relative, "Use relative chart URL in the index instead of absolute.")
+	flags.StringVar(&act.layout, "layout", act.layout, "Layout of the repository: flat or nested. Defaults to the layout set on init, or flat.")
+	flags.StringVar(&act.version, "version", act.version, "Override the chart version when pushing a chart directory.")
+	flags.StringVar(&act.appVersion, "app-version", act.appVersion, "Override the chart app version when pushing a chart directory.")
+	flags.BoolVar(&act.dependencyUpdate, "dependency-update", act.dependencyUpdate, "Update dependencies of the chart before packaging when pushing a chart directory.")
+	flags.BoolVar(&act.skipRefresh, "skip-refresh", act.skipRefresh, "Do not refresh the local repository cache on the dependency update.")
+	flags.IntVar(&act.concurrency, "concurrency", act.concurrency, "Maximum number of charts uploaded concurrently.")
+	flags.BoolVar(&act.sign, "sign", act.sign, "Sign the chart and upload the generated provenance file.")
+	flags.StringVar(&act.key, "key", act.key, "Name of the key to sign the chart with.")
+	flags.StringVar(&act.keyring, "keyring", act.keyring, "Path to the keyring with the signing key, or with the public keys to verify the chart with.")
+	flags.StringVar(&act.passphraseFile, "passphrase-file", act.passphraseFile, "File with the passphrase of the signing key, or '-' to read it from stdin.")
+	flags.BoolVar(&act.verify, "verify", act.verify, "Verify the provenance file of the chart against the keyring before uploading.")
 
 	// We don't use cobra's feature
 	//
@@ -87,15 +187,18 @@ func newPushCommand(opts *options) *cobra.Command {
 
 type pushAction struct {
 	printer printer
+	result  *commandResult
 
 	// global args
 
-	acl string
+	acl             string
+	maxIndexRetries int
+	lock            lockOptions
 
 	// args
 
-	chartPath string
-	repoName  string
+	chartPaths []string
+	repoName   string
 
 	// flags
 
@@ -103,16 +206,82 @@ type pushAction struct {
 	dryRun         bool
 	force          bool
 	ignoreIfExists bool
+	idempotent     bool
 	relative       bool
+	layout         string
+	concurrency    int
+
+	// flags for chart directories
+
+	version          string
+	appVersion       string
+	dependencyUpdate bool
+	skipRefresh      bool
+
+	// flags for signing
+
+	sign           bool
+	key            string
+	keyring        string
+	passphraseFile string
+	verify         bool
 }
 
-func (act *pushAction) run(ctx context.Context) error { //nolint:gocyclo // Maybe refactor later.
+// pushRollbackTimeout is the timeout for removing uploaded charts when
+// the push fails.
+const pushRollbackTimeout = 30 * time.Second
+
+// pushChart is a chart being pushed.
+type pushChart struct {
+	// arg is the PATH argument the chart comes from.
+	arg string
+
+	// path is the path to the chart archive.
+	path string
+
+	chart helmutil.Chart
+	hash  string
+
+	// key is the key of the chart object relative to the repository root.
+	key string
+
+	// existed is true if the chart object existed before the push.
+	existed bool
+
+	// remoteDigest is the digest of the existing chart, known only with
+	// --idempotent. Empty if the digest is unknown.
+	remoteDigest string
+
+	// uploaded is true if the chart object was uploaded by the push.
+	uploaded bool
+
+	// provPath is the path to the provenance file, either the one next to
+	// the chart archive or the one generated on signing. Empty if the chart
+	// is not signed.
+	provPath string
+
+	result *chartResult
+}
+
+func (act *pushAction) run(ctx context.Context) error {
 	// Sanity check.
 	if act.force && act.ignoreIfExists {
 		act.printer.PrintErrf(
 			"The --force and --ignore-if-exists flags are mutually exclusive and cannot be specified together.\n",
 		)
-		return newSilentError()
+		return withErrorCode(errorCodeBadUsage, newSilentErrorf("--force and --ignore-if-exists flags are mutually exclusive"))
+	}
+	if act.idempotent && (act.force || act.ignoreIfExists) {
+		return newBadUsageError(errors.New("--idempotent flag cannot be used with --force or --ignore-if-exists"))
+	}
+	if err := validateLayout(act.layout); err != nil {
+		return err
+	}
+	if act.concurrency < 1 {
+		return newBadUsageError(errors.New("--concurrency must be a positive number"))
+	}
+	if act.sign && act.key == "" {
+		return newBadUsageError(errors.New("--sign requires --key"))
 	}
 
 	repoEntry, err := helmutil.LookupRepoEntry(act.repoName)
@@ -120,167 +289,595 @@ func (act *pushAction) run(ctx context.Context) error { //nolint:gocyclo // Mayb
 		return err
 	}
 
-	sess, err := awsutil.Session(awsutil.DynamicBucketRegion(repoEntry.URL()))
+	act.result.RepoURL = repoEntry.URL()
+
+	store, err := storage.New(repoEntry.URL())
 	if err != nil {
 		return err
 	}
-	storage := awss3.New(sess)
 
-	fpath, err := filepath.Abs(act.chartPath)
+	paths, err := expandChartPaths(act.chartPaths)
 	if err != nil {
-		return errors.WithMessage(err, "get chart abs path")
+		return err
 	}
 
-	dir := filepath.Dir(fpath)
-	fname := filepath.Base(fpath)
+	settings, err := fetchRepoSettings(ctx, store, repoEntry.URL())
+	if err != nil {
+		return err
+	}
+	if settings.Immutable && act.force {
+		return withErrorCode(errorCodeImmutable, errors.New("the repository is immutable, published chart versions cannot be replaced with --force"))
+	}
+	layout := resolveLayout(act.layout, settings)
+
+	tmpDir, err := os.MkdirTemp("", "helm-s3-push-")
+	if err != nil {
+		return errors.Wrap(err, "create temporary directory for chart packages")
+	}
+	defer os.RemoveAll(tmpDir)
 
-	if err := os.Chdir(dir); err != nil {
-		return errors.Wrapf(err, "change dir to %s", dir)
+	charts, err := act.loadCharts(paths, tmpDir, layout)
+	if err != nil {
+		return err
+	}
+
+	if act.sign {
+		if err := act.signCharts(charts, tmpDir); err != nil {
+			return err
+		}
+	}
+	if act.verify || settings.RequireProvenance {
+		if err := act.verifyCharts(charts, settings); err != nil {
+			return err
+		}
 	}
 
-	// Load chart, calculate required params like hash,
-	// and upload the chart right away.
+	act.result.Charts = make([]chartResult, len(charts))
+	for i, ch := range charts {
+		act.result.Charts[i] = chartResult{
+			Name:    ch.chart.Name(),
+			Version: ch.chart.Version(),
+			URL:     repoEntry.URL() + "/" + ch.key,
+			Digest:  ch.hash,
+		}
+		ch.result = &act.result.Charts[i]
+	}
 
-	chart, err := helmutil.LoadChart(fname)
+	pending, err := act.checkExisting(ctx, store, repoEntry, charts)
 	if err != nil {
 		return err
 	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if !act.dryRun {
+		if err := act.upload(ctx, store, repoEntry, pending); err != nil {
+			act.rollback(ctx, store, repoEntry, pending)
+			return err
+		}
+	}
+
+	// The gap between index fetching and uploading should be as small as
+	// possible to make the best effort to avoid race conditions.
+	// Concurrent updates are detected using conditional writes, and the
+	// index update is retried if the index was modified in between.
+	// See https://github.com/hypnoglow/helm-s3/issues/18 for more info.
+
+	// Fetch current index, update it and upload it back.
 
-	if cachedIndex, err := helmutil.LoadIndex(repoEntry.CacheFile()); err == nil {
-		// if cached index exists, check if the same chart version exists in it.
-		if cachedIndex.Has(chart.Name(), chart.Version()) {
-			if act.ignoreIfExists {
-				return act.ignoreIfExistsError()
+	addCharts := func(idx helmutil.Index) error {
+		for _, ch := range pending {
+			filename, baseURL := indexLocation(repoEntry.URL(), ch.key, act.relative)
+			if err := idx.AddOrReplace(ch.chart.Metadata().Value(), filename, baseURL, ch.hash); err != nil {
+				return errors.WithMessagef(err, "add/replace chart %s %s in the index", ch.chart.Name(), ch.chart.Version())
 			}
-			if !act.force {
-				return act.chartExistsError()
+		}
+		idx.SortEntries()
+		return nil
+	}
+
+	if act.dryRun {
+		idx, _, err := fetchIndex(ctx, store, repoEntry)
+		if err != nil {
+			return err
+		}
+		if err := addCharts(idx); err != nil {
+			return err
+		}
+	} else {
+		if err := act.updateIndex(ctx, store, repoEntry, addCharts); err != nil {
+			act.rollback(ctx, store, repoEntry, pending)
+			return err
+		}
+	}
+
+	if len(charts) == 1 {
+		act.printer.Printf("Successfully uploaded the chart to the repository.\n")
+	} else {
+		act.printer.Printf("Successfully uploaded %d charts to the repository.\n", len(pending))
+	}
+	return nil
+}
+
+// loadCharts loads the charts to push, packaging chart directories into
+// tmpDir.
+func (act *pushAction) loadCharts(paths []chartPath, tmpDir, layout string) ([]*pushChart, error) {
+	dirs := 0
+	for _, p := range paths {
+		if p.dir {
+			dirs++
+		}
+	}
+	if dirs == 0 && (act.version != "" || act.appVersion != "" || act.dependencyUpdate) {
+		return nil, newBadUsageError(errors.New("--version, --app-version and --dependency-update flags can be used only when pushing a chart directory"))
+	}
+	if dirs > 1 && (act.version != "" || act.appVersion != "") {
+		return nil, newBadUsageError(errors.New("--version and --app-version flags can be used only when pushing a single chart directory"))
+	}
+	if act.version != "" {
+		if _, err := semver.NewVersion(act.version); err != nil {
+			return nil, newBadUsageError(errors.Errorf("invalid chart version %q: %s", act.version, err))
+		}
+	}
+
+	charts := make([]*pushChart, 0, len(paths))
+	seen := make(map[string]string)
+	for _, p := range paths {
+		fpath := p.path
+		if p.dir {
+			var err error
+			fpath, err = helmutil.PackageChart(p.path, tmpDir, helmutil.PackageOptions{
+				Version:          act.version,
+				AppVersion:       act.appVersion,
+				DependencyUpdate: act.dependencyUpdate,
+				SkipRefresh:      act.skipRefresh,
+				Out:              printerWriter{act.printer},
+			})
+			if err != nil {
+				return nil, errors.WithMessagef(err, "package chart %s", p.arg)
 			}
+		}
 
-			// fallthrough on --force
+		chart, err := helmutil.LoadChart(fpath)
+		if err != nil {
+			return nil, err
 		}
+
+		id := chart.Name() + " " + chart.Version()
+		if arg, ok := seen[id]; ok {
+			return nil, newBadUsageError(fmt.Errorf("chart %s is pushed twice: from %s and from %s", id, arg, p.arg))
+		}
+		seen[id] = p.arg
+
+		hash, err := helmutil.DigestFile(fpath)
+		if err != nil {
+			return nil, errors.WithMessage(err, "get chart digest")
+		}
+
+		provPath := fpath + ".prov"
+		if _, err := os.Stat(provPath); errors.Is(err, os.ErrNotExist) {
+			provPath = ""
+		} else if err != nil {
+			return nil, errors.Wrap(err, "check prov file")
+		}
+
+		charts = append(charts, &pushChart{
+			arg:      p.arg,
+			path:     fpath,
+			chart:    chart,
+			hash:     hash,
+			key:      chartKey(layout, chart.Name(), filepath.Base(fpath)),
+			provPath: provPath,
+		})
 	}
 
-	hash, err := helmutil.DigestFile(fname)
+	return charts, nil
+}
+
+// signCharts signs the charts, generating their provenance files in tmpDir.
+func (act *pushAction) signCharts(charts []*pushChart, tmpDir string) error {
+	signer, err := helmutil.NewSigner(act.keyring, act.key, passphraseFetcher(act.passphraseFile, os.Stdin))
 	if err != nil {
-		return errors.WithMessage(err, "get chart digest")
+		return err
 	}
 
-	chartFile, err := os.Open(fname)
-	if err != nil {
-		return errors.Wrap(err, "open chart file")
+	for _, ch := range charts {
+		prov, err := signer.Sign(ch.path)
+		if err != nil {
+			return errors.WithMessagef(err, "sign chart %s %s", ch.chart.Name(), ch.chart.Version())
+		}
+		provPath := filepath.Join(tmpDir, filepath.Base(ch.path)+".prov")
+		if err := os.WriteFile(provPath, []byte(prov), 0o644); err != nil {
+			return errors.Wrap(err, "write prov file")
+		}
+		ch.provPath = provPath
 	}
+	return nil
+}
+
+// verifyCharts verifies the provenance files of the charts against the
+// keyring, and enforces the provenance policy of the repository.
+func (act *pushAction) verifyCharts(charts []*pushChart, settings repoSettings) error {
+	for _, ch := range charts {
+		if ch.provPath == "" {
+			err := fmt.Errorf("chart %s %s is not signed: provenance file %s.prov not found", ch.chart.Name(), ch.chart.Version(), ch.path)
+			if settings.RequireProvenance {
+				return withErrorCode(errorCodePolicyViolation, errors.WithMessage(err, "the repository requires provenance"))
+			}
+			return withErrorCode(errorCodeVerificationFailed, err)
+		}
 
-	hasProv := false
-	provFile, err := os.Open(fname + ".prov")
-	switch {
-	case err == nil:
-		hasProv = true
-	case errors.Is(err, os.ErrNotExist):
-		// No provenance file, ignore it.
-	case err != nil:
-		return fmt.Errorf("open prov file: %w", err)
+		if err := verifySignedChart(ch.chart.Name(), ch.chart.Version(), ch.path, ch.provPath, act.keyring, settings); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	exists, err := storage.Exists(ctx, repoEntry.URL()+"/"+fname)
+// checkExisting finds out which charts already exist in the repository, and
+// returns the charts to push. Existing charts are skipped with
+// --ignore-if-exists, and replaced with --force; otherwise, an error is
+// returned and nothing is pushed.
+func (act *pushAction) checkExisting(ctx context.Context, store storage.Storage, repoEntry helmutil.RepoEntry, charts []*pushChart) ([]*pushChart, error) {
+	// If cached index exists, check if the same chart versions exist in it.
+	cachedIndex, err := helmutil.LoadIndex(repoEntry.CacheFile())
 	if err != nil {
-		return errors.WithMessage(err, "check if chart already exists in the repository")
+		cachedIndex = nil
 	}
 
-	if exists {
-		if act.ignoreIfExists {
-			return act.ignoreIfExistsError()
+	err = forEachConcurrently(ctx, len(charts), act.concurrency, func(ctx context.Context, i int) error {
+		ch := charts[i]
+		if act.idempotent {
+			// The digest tells if the chart exists, and the cached index
+			// may be stale, so always check the storage.
+			digest, err := store.ChartDigest(ctx, repoEntry.URL()+"/"+ch.key)
+			if errors.Is(err, storage.ErrObjectNotFound) {
+				return nil
+			}
+			if err != nil {
+				return errors.WithMessage(err, "get digest of the chart existing in the repository")
+			}
+			ch.existed = true
+			ch.remoteDigest = digest
+			return nil
+		}
+
+		if cachedIndex != nil && cachedIndex.Has(ch.chart.Name(), ch.chart.Version()) && !act.force {
+			// The chart exists, no need to check the storage.
+			ch.existed = true
+			return nil
 		}
-		if !act.force {
-			return act.chartExistsError()
+
+		exists, err := store.Exists(ctx, repoEntry.URL()+"/"+ch.key)
+		if err != nil {
+			return errors.WithMessage(err, "check if chart already exists in the repository")
 		}
+		ch.existed = exists
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		// fallthrough on --force
+	if act.idempotent {
+		if err := act.resolveRemoteDigests(ctx, store, repoEntry, charts); err != nil {
+			return nil, err
+		}
 	}
 
-	if !act.dryRun {
-		chartMetaJSON, err := chart.Metadata().MarshalJSON()
+	var pending, conflicts, mismatches []*pushChart
+	for _, ch := range charts {
+		switch {
+		case !ch.existed || act.force:
+			pending = append(pending, ch)
+		case act.ignoreIfExists:
+			act.skipExisting(ch, len(charts))
+		case act.idempotent && ch.remoteDigest == ch.hash:
+			act.skipIdentical(ch, len(charts))
+		case act.idempotent:
+			mismatches = append(mismatches, ch)
+		default:
+			conflicts = append(conflicts, ch)
+		}
+	}
+	if len(mismatches) > 0 {
+		return nil, act.digestMismatchError(mismatches)
+	}
+	if len(conflicts) > 0 {
+		return nil, act.chartExistsError(conflicts)
+	}
+
+	return pending, nil
+}
+
+// upload uploads the charts to the repository concurrently.
+func (act *pushAction) upload(ctx context.Context, store storage.Storage, repoEntry helmutil.RepoEntry, charts []*pushChart) error {
+	return forEachConcurrently(ctx, len(charts), act.concurrency, func(ctx context.Context, i int) error {
+		ch := charts[i]
+
+		chartFile, err := os.Open(ch.path)
+		if err != nil {
+			return errors.Wrap(err, "open chart file")
+		}
+		defer chartFile.Close()
+
+		var provFile io.Reader
+		hasProv := ch.provPath != ""
+		if hasProv {
+			f, err := os.Open(ch.provPath)
+			if err != nil {
+				return fmt.Errorf("open prov file: %w", err)
+			}
+			defer f.Close()
+			provFile = f
+		}
+
+		chartMetaJSON, err := ch.chart.Metadata().MarshalJSON()
 		if err != nil {
 			return err
 		}
-		if _, err := storage.PutChart(
+
+		chartURL, err := store.PutChart(
 			ctx,
-			repoEntry.URL()+"/"+fname,
+			repoEntry.URL()+"/"+ch.key,
 			chartFile,
 			string(chartMetaJSON),
 			act.acl,
-			hash,
+			ch.hash,
 			act.contentType,
 			hasProv,
 			provFile,
-		); err != nil {
-			return errors.WithMessage(err, "upload chart to s3")
+		)
+		if err != nil {
+			return errors.WithMessagef(err, "upload chart %s %s to s3", ch.chart.Name(), ch.chart.Version())
 		}
-	}
-
-	// The gap between index fetching and uploading should be as small as
-	// possible to make the best effort to avoid race conditions.
-	// See https://github.com/hypnoglow/helm-s3/issues/18 for more info.
-
-	// Fetch current index, update it and upload it back.
+		ch.uploaded = true
+		ch.result.URL = chartURL
+		return nil
+	})
+}
 
-	b, err := storage.FetchRaw(ctx, repoEntry.IndexURL())
+// updateIndex adds the charts to the index under the repository lock, and
+// updates the local index.
+func (act *pushAction) updateIndex(ctx context.Context, store storage.Storage, repoEntry helmutil.RepoEntry, addCharts func(idx helmutil.Index) error) error {
+	ctx, unlock, err := lockRepo(ctx, store, repoEntry.URL(), act.acl, act.lock, act.printer)
 	if err != nil {
-		return errors.WithMessage(err, "fetch current repo index")
+		return err
 	}
+	defer unlock()
 
-	idx := helmutil.NewIndex()
-	if err := idx.UnmarshalBinary(b); err != nil {
-		return errors.WithMessage(err, "load index from downloaded file")
+	idx, err := updateIndex(ctx, store, repoEntry, act.acl, act.maxIndexRetries, act.printer, addCharts)
+	if err != nil {
+		return err
 	}
 
-	baseURL := repoEntry.URL()
-	if act.relative {
-		baseURL = ""
+	// The index is updated at this point, so the charts must not be rolled
+	// back even if the local index cannot be updated.
+	if err := idx.WriteFile(repoEntry.CacheFile(), helmutil.DefaultIndexFilePerm); err != nil {
+		act.printer.PrintErrf("[WARNING] failed to update local index: %s\n", err)
 	}
+	return nil
+}
 
-	filename := escapeIfRelative(fname, act.relative)
+// rollback removes the charts uploaded by the push, unless they are in the
+// index: the index update may have succeeded even if it returned an error.
+// Charts that existed before the push were replaced and cannot be restored.
+func (act *pushAction) rollback(ctx context.Context, store storage.Storage, repoEntry helmutil.RepoEntry, charts []*pushChart) {
+	// Roll back even if the operation context is canceled.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), pushRollbackTimeout)
+	defer cancel()
 
-	if err := idx.AddOrReplace(chart.Metadata().Value(), filename, baseURL, hash); err != nil {
-		return errors.WithMessage(err, "add/replace chart in the index")
+	idx, _, err := fetchIndex(ctx, store, repoEntry)
+	if err != nil {
+		act.printer.PrintErrf("[WARNING] failed to fetch the index, uploaded charts are not removed: %s\n", err)
+		return
+	}
+	indexed := make(map[string]bool)
+	for _, entry := range idx.Entries() {
+		indexed[entry.Name+" "+entry.Version+" "+entry.Digest] = true
 	}
-	idx.SortEntries()
-	idx.UpdateGeneratedTime()
 
-	idxReader, err := idx.Reader()
-	if err != nil {
-		return errors.WithMessage(err, "get index reader")
+	for _, ch := range charts {
+		if !ch.uploaded || indexed[ch.chart.Name()+" "+ch.chart.Version()+" "+ch.hash] {
+			continue
+		}
+		if ch.existed {
+			act.printer.PrintErrf("[WARNING] chart %s %s was replaced and cannot be restored.\n", ch.chart.Name(), ch.chart.Version())
+			continue
+		}
+		if err := store.DeleteChart(ctx, repoEntry.URL()+"/"+ch.key); err != nil {
+			act.printer.PrintErrf("[WARNING] failed to remove uploaded chart %s %s: %s\n", ch.chart.Name(), ch.chart.Version(), err)
+			continue
+		}
+		ch.uploaded = false
+		act.printer.PrintErrf("Removed uploaded chart %s %s.\n", ch.chart.Name(), ch.chart.Version())
 	}
+}
 
-	if !act.dryRun {
-		if err := storage.PutIndex(ctx, repoEntry.URL(), act.acl, idxReader); err != nil {
-			return errors.WithMessage(err, "upload index to s3")
+func (act *pushAction) skipExisting(ch *pushChart, total int) {
+	ch.result.Skipped = true
+	if total == 1 {
+		act.printer.Printf(
+			"The chart already exists in the repository, keep existing chart and ignore push.\n",
+		)
+		return
+	}
+	act.printer.Printf(
+		"The chart %s %s already exists in the repository, keep existing chart and ignore push.\n",
+		ch.chart.Name(), ch.chart.Version(),
+	)
+}
+
+// resolveRemoteDigests looks up digests of existing charts that have no
+// digest recorded in the object metadata in the repository index.
+func (act *pushAction) resolveRemoteDigests(ctx context.Context, store storage.Storage, repoEntry helmutil.RepoEntry, charts []*pushChart) error {
+	var idx helmutil.Index
+	for _, ch := range charts {
+		if !ch.existed || ch.remoteDigest != "" {
+			continue
 		}
 
-		if err := idx.WriteFile(repoEntry.CacheFile(), helmutil.DefaultIndexFilePerm); err != nil {
-			return errors.WithMessage(err, "update local index")
+		if idx == nil {
+			var err error
+			idx, _, err = fetchIndex(ctx, store, repoEntry)
+			if err != nil {
+				return err
+			}
+		}
+		for _, entry := range idx.Entries() {
+			if entry.Name == ch.chart.Name() && entry.Version == ch.chart.Version() {
+				ch.remoteDigest = entry.Digest
+				break
+			}
 		}
 	}
-
-	act.printer.Printf("Successfully uploaded the chart to the repository.\n")
 	return nil
 }
 
-func (act *pushAction) ignoreIfExistsError() error {
+func (act *pushAction) skipIdentical(ch *pushChart, total int) {
+	ch.result.Skipped = true
+	if total == 1 {
+		act.printer.Printf("The identical chart already exists in the repository, nothing to push.\n")
+		return
+	}
 	act.printer.Printf(
-		"The chart already exists in the repository, keep existing chart and ignore push.\n",
+		"The identical chart %s %s already exists in the repository, nothing to push.\n",
+		ch.chart.Name(), ch.chart.Version(),
 	)
-	return nil
 }
 
-func (act *pushAction) chartExistsError() error {
+func (act *pushAction) digestMismatchError(mismatches []*pushChart) error {
+	lines := make([]string, 0, len(mismatches))
+	ids := make([]string, 0, len(mismatches))
+	for _, ch := range mismatches {
+		remote := ch.remoteDigest
+		if remote == "" {
+			remote = "unknown"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s: local digest %s, repository digest %s", ch.chart.Name(), ch.chart.Version(), ch.hash, remote))
+		ids = append(ids, ch.chart.Name()+" "+ch.chart.Version())
+	}
+
+	act.printer.PrintErrf(
+		"The charts already exist in the repository with different content:\n\n"+
+			"  %s\n\n"+
+			"Nothing was pushed. Bump the chart version to publish the changes.\n\n",
+		strings.Join(lines, "\n  "),
+	)
+	return withErrorCode(errorCodeDigestMismatch, newSilentErrorf("charts already exist in the repository with different content: %s", strings.Join(ids, ", ")))
+}
+
+func (act *pushAction) chartExistsError(conflicts []*pushChart) error {
+	args := strings.Join(act.chartPaths, " ")
+
+	if len(conflicts) == 1 {
+		act.printer.PrintErrf(
+			"The chart already exists in the repository and cannot be overwritten without an explicit intent.\n\n"+
+				"If you want to replace existing chart, use --force flag:\n\n"+
+				"  helm s3 push --force %[1]s %[2]s\n\n"+
+				"If you want to ignore this error, use --ignore-if-exists flag:\n\n"+
+				"  helm s3 push --ignore-if-exists %[1]s %[2]s\n\n",
+			args,
+			act.repoName,
+		)
+		return withErrorCode(errorCodeChartExists, newSilentErrorf("the chart already exists in the repository"))
+	}
+
+	ids := make([]string, 0, len(conflicts))
+	for _, ch := range conflicts {
+		ids = append(ids, ch.chart.Name()+" "+ch.chart.Version())
+	}
 	act.printer.PrintErrf(
-		"The chart already exists in the repository and cannot be overwritten without an explicit intent.\n\n"+
-			"If you want to replace existing chart, use --force flag:\n\n"+
+		"The charts already exist in the repository and cannot be overwritten without an explicit intent:\n\n"+
+			"  %[3]s\n\n"+
+			"Nothing was pushed. If you want to replace existing charts, use --force flag:\n\n"+
 			"  helm s3 push --force %[1]s %[2]s\n\n"+
 			"If you want to ignore this error, use --ignore-if-exists flag:\n\n"+
 			"  helm s3 push --ignore-if-exists %[1]s %[2]s\n\n",
-		act.chartPath,
+		args,
 		act.repoName,
+		strings.Join(ids, "\n  "),
 	)
-	return newSilentError()
+	return withErrorCode(errorCodeChartExists, newSilentErrorf("charts already exist in the repository: %s", strings.Join(ids, ", ")))
+}
+
+// chartPath is a path to a chart to push.
+type chartPath struct {
+	// arg is the PATH argument the path comes from.
+	arg string
+
+	// path is the absolute path to the chart archive or the chart directory.
+	path string
+
+	// dir is true if the path is a chart directory.
+	dir bool
+}
+
+// expandChartPaths resolves PATH arguments to chart paths. Glob patterns are
+// expanded, and directories that are not charts are replaced with chart
+// archives they contain.
+func expandChartPaths(args []string) ([]chartPath, error) {
+	var paths []chartPath
+	seen := make(map[string]bool)
+	add := func(arg, path string, dir bool) error {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return errors.WithMessage(err, "get chart abs path")
+		}
+		if !seen[abs] {
+			seen[abs] = true
+			paths = append(paths, chartPath{arg: arg, path: abs, dir: dir})
+		}
+		return nil
+	}
+
+	for _, arg := range args {
+		matches := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+			matches, err = filepath.Glob(arg)
+			if err != nil {
+				return nil, newBadUsageError(fmt.Errorf("invalid pattern %q: %v", arg, err))
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no charts match pattern %q", arg)
+			}
+		}
+
+		for _, match := range matches {
+			fi, err := os.Stat(match)
+			if err != nil {
+				return nil, errors.Wrap(err, "stat chart path")
+			}
+			if !fi.IsDir() {
+				if err := add(arg, match, false); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			if _, err := os.Stat(filepath.Join(match, "Chart.yaml")); err == nil {
+				if err := add(arg, match, true); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			archives, err := filepath.Glob(filepath.Join(match, "*.tgz"))
+			if err != nil {
+				return nil, errors.Wrap(err, "find chart archives")
+			}
+			if len(archives) == 0 {
+				return nil, fmt.Errorf("directory %s is neither a chart nor contains chart archives", match)
+			}
+			for _, archive := range archives {
+				if err := add(arg, archive, false); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return paths, nil
 }
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/provenance"
+
+	"github.com/hypnoglow/helm-s3/internal/storage/storagetest"
+)
+
+func TestPush(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+
+	out := env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+	assert.Contains(t, out, "Successfully uploaded the chart to the repository.")
+
+	obj, ok := env.store.Get(env.repoURL + "/foo-1.0.0.tgz")
+	require.True(t, ok)
+	assert.NotEmpty(t, obj.Metadata[storagetest.MetaChartDigest])
+
+	idx := env.index()
+	require.Len(t, idx.Entries["foo"], 1)
+	assert.Equal(t, "1.0.0", idx.Entries["foo"][0].Version)
+	assert.Equal(t, obj.Metadata[storagetest.MetaChartDigest], idx.Entries["foo"][0].Digest)
+	assert.Equal(t, []string{env.repoURL + "/foo-1.0.0.tgz"}, idx.Entries["foo"][0].URLs)
+
+	t.Run("should refuse to overwrite existing chart", func(t *testing.T) {
+		_, stderr, err := env.run("push", env.chart("foo", "1.0.0"), testRepoName)
+		require.Error(t, err)
+		assert.Contains(t, stderr, "The chart already exists in the repository")
+	})
+
+	t.Run("should ignore existing chart with --ignore-if-exists", func(t *testing.T) {
+		out := env.mustRun("push", "--ignore-if-exists", env.chart("foo", "1.0.0"), testRepoName)
+		assert.Contains(t, out, "keep existing chart and ignore push")
+	})
+}
+
+func TestPush_DryRun(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+
+	env.mustRun("push", "--dry-run", env.chart("foo", "1.0.0"), testRepoName)
+
+	_, ok := env.store.Get(env.repoURL + "/foo-1.0.0.tgz")
+	assert.False(t, ok)
+	assert.Empty(t, env.index().Entries)
+}
+
+func TestPush_ConcurrentIndexUpdate(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+
+	// Another process pushes a chart right before the first index upload,
+	// so the upload fails and must be retried against the fresh index.
+	concurrent := env.chart("bar", "1.0.0")
+	pushed := false
+	env.store.SetHook(func(op storagetest.Op, uri string) error {
+		if op != storagetest.OpPutIndex || pushed {
+			return nil
+		}
+		pushed = true
+		env.store.SetHook(nil)
+		env.mustRun("push", concurrent, testRepoName)
+		return nil
+	})
+
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+
+	idx := env.index()
+	assert.Len(t, idx.Entries["foo"], 1)
+	assert.Len(t, idx.Entries["bar"], 1)
+
+	var puts int
+	for _, call := range env.store.Calls() {
+		if call.Op == storagetest.OpPutIndex && strings.HasPrefix(call.URI, env.repoURL) {
+			puts++
+		}
+	}
+	// init, the first attempt, the concurrent push and the retry.
+	assert.Equal(t, 4, puts)
+}
+
+func TestPush_NestedLayout(t *testing.T) {
+	env := newTestEnv(t)
+	env.mustRun("init", "--layout", "nested", env.repoURL)
+	env.addRepo()
+
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+
+	_, ok := env.store.Get(env.repoURL + "/foo/foo-1.0.0.tgz")
+	assert.True(t, ok)
+	_, ok = env.store.Get(env.repoURL + "/foo-1.0.0.tgz")
+	assert.False(t, ok)
+
+	idx := env.index()
+	require.Len(t, idx.Entries["foo"], 1)
+	assert.Equal(t, []string{env.repoURL + "/foo/foo-1.0.0.tgz"}, idx.Entries["foo"][0].URLs)
+
+	t.Run("should use relative nested URL with --relative", func(t *testing.T) {
+		env.mustRun("push", "--relative", env.chart("foo", "1.1.0"), testRepoName)
+
+		idx := env.index()
+		require.Len(t, idx.Entries["foo"], 2)
+		assert.Equal(t, []string{"foo/foo-1.1.0.tgz"}, idx.Entries["foo"][0].URLs)
+	})
+
+	t.Run("should override the repository layout with --layout", func(t *testing.T) {
+		env.mustRun("push", "--layout", "flat", env.chart("bar", "1.0.0"), testRepoName)
+
+		_, ok := env.store.Get(env.repoURL + "/bar-1.0.0.tgz")
+		assert.True(t, ok)
+	})
+
+	t.Run("should delete nested chart", func(t *testing.T) {
+		env.mustRun("delete", "foo", "--version", "1.0.0", testRepoName)
+
+		_, ok := env.store.Get(env.repoURL + "/foo/foo-1.0.0.tgz")
+		assert.False(t, ok)
+	})
+}
+
+func TestPush_BadLayout(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+
+	_, _, err := env.run("push", "--layout", "deep", env.chart("foo", "1.0.0"), testRepoName)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown layout "deep"`)
+}
+
+func TestPush_OutputJSON(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+
+	stdout, stderr, err := env.run("push", "--output", "json", env.chart("foo", "1.0.0"), testRepoName)
+	require.NoError(t, err, stderr)
+	assert.Contains(t, stderr, "Successfully uploaded the chart to the repository.")
+
+	var res commandResult
+	require.NoError(t, json.Unmarshal([]byte(stdout), &res))
+	assert.Equal(t, "push", res.Command)
+	assert.Equal(t, testRepoName, res.Repo)
+	assert.Nil(t, res.Error)
+	require.Len(t, res.Charts, 1)
+	assert.Equal(t, chartResult{
+		Name:    "foo",
+		Version: "1.0.0",
+		URL:     env.repoURL + "/foo-1.0.0.tgz",
+		Digest:  env.index().Entries["foo"][0].Digest,
+		Skipped: false,
+	}, res.Charts[0])
+
+	t.Run("should report skipped chart", func(t *testing.T) {
+		stdout, _, err := env.run("push", "-o", "json", "--ignore-if-exists", env.chart("foo", "1.0.0"), testRepoName)
+		require.NoError(t, err)
+
+		var res commandResult
+		require.NoError(t, json.Unmarshal([]byte(stdout), &res))
+		require.Len(t, res.Charts, 1)
+		assert.True(t, res.Charts[0].Skipped)
+	})
+
+	t.Run("should report error with code", func(t *testing.T) {
+		stdout, _, err := env.run("push", "-o", "json", env.chart("foo", "1.0.0"), testRepoName)
+		require.Error(t, err)
+
+		var res commandResult
+		require.NoError(t, json.Unmarshal([]byte(stdout), &res))
+		require.NotNil(t, res.Error)
+		assert.Equal(t, errorCodeChartExists, res.Error.Code)
+		assert.Equal(t, "the chart already exists in the repository", res.Error.Message)
+	})
+}
+
+func TestPush_ChartDirectory(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	// The dependency update needs the local cache of the repository index,
+	// like after "helm repo add".
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+
+	writeFile := func(path, data string) {
+		t.Helper()
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+	}
+
+	root := t.TempDir()
+	writeFile(filepath.Join(root, "sub", "Chart.yaml"), "apiVersion: v2\nname: sub\nversion: 0.1.0\n")
+	writeFile(filepath.Join(root, "app", "Chart.yaml"), `apiVersion: v2
+name: app
+version: 0.1.0
+appVersion: "1.0"
+dependencies:
+  - name: sub
+    version: 0.1.0
+    repository: file://../sub
+`)
+	writeFile(filepath.Join(root, "app", ".helmignore"), "secret.txt\n")
+	writeFile(filepath.Join(root, "app", "secret.txt"), "secret")
+	writeFile(filepath.Join(root, "app", "public.txt"), "public")
+
+	t.Run("should fail on missing dependencies", func(t *testing.T) {
+		_, _, err := env.run("push", filepath.Join(root, "app"), testRepoName)
+		require.ErrorContains(t, err, "missing in charts/ directory: sub")
+	})
+
+	env.mustRun(
+		"push", "--dependency-update", "--skip-refresh", "--version", "0.2.0", "--app-version", "2.0",
+		filepath.Join(root, "app"), testRepoName,
+	)
+
+	obj, ok := env.store.Get(env.repoURL + "/app-0.2.0.tgz")
+	require.True(t, ok)
+
+	ch, err := loader.LoadArchive(bytes.NewReader(obj.Data))
+	require.NoError(t, err)
+	assert.Equal(t, "0.2.0", ch.Metadata.Version)
+	assert.Equal(t, "2.0", ch.Metadata.AppVersion)
+	require.Len(t, ch.Dependencies(), 1)
+	assert.Equal(t, "sub", ch.Dependencies()[0].Name())
+
+	var files []string
+	for _, f := range ch.Files {
+		files = append(files, f.Name)
+	}
+	assert.Contains(t, files, "public.txt")
+	assert.NotContains(t, files, "secret.txt")
+
+	idx := env.index()
+	require.Len(t, idx.Entries["app"], 1)
+	assert.Equal(t, "2.0", idx.Entries["app"][0].AppVersion)
+
+	t.Run("should refuse version override for chart file", func(t *testing.T) {
+		_, _, err := env.run("push", "--version", "0.3.0", env.chart("foo", "1.0.0"), testRepoName)
+		require.ErrorContains(t, err, "can be used only when pushing a chart directory")
+	})
+
+	t.Run("should refuse invalid version", func(t *testing.T) {
+		_, _, err := env.run("push", "--dependency-update", "--skip-refresh", "--version", "latest", filepath.Join(root, "app"), testRepoName)
+		require.ErrorContains(t, err, `invalid chart version "latest"`)
+		assert.Equal(t, errorCodeBadUsage, errorCode(err))
+	})
+
+	t.Run("should refuse version override for several chart directories", func(t *testing.T) {
+		_, _, err := env.run("push", "--app-version", "3.0", filepath.Join(root, "app"), filepath.Join(root, "sub"), testRepoName)
+		require.ErrorContains(t, err, "can be used only when pushing a single chart directory")
+		assert.Equal(t, errorCodeBadUsage, errorCode(err))
+	})
+}
+
+func TestPush_Multiple(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+
+	dist := filepath.Join(t.TempDir(), "dist")
+	require.NoError(t, os.MkdirAll(dist, 0o755))
+	for _, path := range []string{env.chart("bar", "1.0.0"), env.chart("baz", "1.0.0")} {
+		require.NoError(t, os.Rename(path, filepath.Join(dist, filepath.Base(path))))
+	}
+
+	out := env.mustRun(
+		"push", "--concurrency", "2",
+		env.chart("foo", "1.0.0"), filepath.Join(env.dir, "foo-*.tgz"), dist,
+		testRepoName,
+	)
+	assert.Contains(t, out, "Successfully uploaded 3 charts to the repository.")
+
+	idx := env.index()
+	assert.Len(t, idx.Entries["foo"], 1)
+	assert.Len(t, idx.Entries["bar"], 1)
+	assert.Len(t, idx.Entries["baz"], 1)
+
+	var puts int
+	for _, call := range env.store.Calls() {
+		if call.Op == storagetest.OpPutIndex {
+			puts++
+		}
+	}
+	assert.Equal(t, 2, puts, "init and a single push")
+
+	t.Run("should push nothing if any chart exists", func(t *testing.T) {
+		_, stderr, err := env.run("push", env.chart("qux", "1.0.0"), env.chart("foo", "1.0.0"), testRepoName)
+		require.Error(t, err)
+		assert.Contains(t, stderr, "The chart already exists in the repository")
+
+		_, ok := env.store.Get(env.repoURL + "/qux-1.0.0.tgz")
+		assert.False(t, ok)
+	})
+
+	t.Run("should skip existing charts with --ignore-if-exists", func(t *testing.T) {
+		out := env.mustRun("push", "--ignore-if-exists", env.chart("qux", "1.0.0"), env.chart("foo", "1.0.0"), testRepoName)
+		assert.Contains(t, out, "The chart foo 1.0.0 already exists in the repository, keep existing chart and ignore push.")
+		assert.Contains(t, out, "Successfully uploaded 1 charts to the repository.")
+		assert.Len(t, env.index().Entries["qux"], 1)
+	})
+
+	t.Run("should refuse to push the same chart twice", func(t *testing.T) {
+		_, _, err := env.run("push", filepath.Join(dist, "bar-1.0.0.tgz"), env.chart("bar", "1.0.0"), testRepoName)
+		require.ErrorContains(t, err, "chart bar 1.0.0 is pushed twice")
+	})
+}
+
+func TestPush_Multiple_RollbackOnIndexFailure(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+
+	env.store.FailOn(storagetest.OpPutIndex, errors.New("access denied"))
+
+	_, stderr, err := env.run("push", "--force", env.chartWithDescription("foo", "1.0.0", "changed"), env.chart("bar", "1.0.0"), testRepoName)
+	require.ErrorContains(t, err, "access denied")
+	assert.Contains(t, stderr, "Removed uploaded chart bar 1.0.0.")
+	assert.Contains(t, stderr, "chart foo 1.0.0 was replaced and cannot be restored")
+
+	_, ok := env.store.Get(env.repoURL + "/bar-1.0.0.tgz")
+	assert.False(t, ok)
+	_, ok = env.store.Get(env.repoURL + "/foo-1.0.0.tgz")
+	assert.True(t, ok)
+}
+
+func TestPush_Multiple_RollbackOnUploadFailure(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+
+	env.store.SetHook(func(op storagetest.Op, uri string) error {
+		if op == storagetest.OpPutChart && strings.HasSuffix(uri, "/bar-1.0.0.tgz") {
+			return errors.New("access denied")
+		}
+		return nil
+	})
+
+	_, _, err := env.run("push", "--concurrency", "1", env.chart("foo", "1.0.0"), env.chart("bar", "1.0.0"), testRepoName)
+	require.ErrorContains(t, err, "access denied")
+
+	_, ok := env.store.Get(env.repoURL + "/foo-1.0.0.tgz")
+	assert.False(t, ok)
+	assert.Empty(t, env.index().Entries)
+}
+
+func TestPush_Sign(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	keyring := env.keyring("Test Signer")
+
+	chartPath := env.chart("foo", "1.0.0")
+	env.mustRun("push", "--sign", "--key", "Test Signer", "--keyring", keyring, chartPath, testRepoName)
+
+	prov, ok := env.store.Get(env.repoURL + "/foo-1.0.0.tgz.prov")
+	require.True(t, ok)
+	provPath := filepath.Join(t.TempDir(), "foo-1.0.0.tgz.prov")
+	require.NoError(t, os.WriteFile(provPath, prov.Data, 0o600))
+
+	signatory, err := provenance.NewFromKeyring(keyring, "")
+	require.NoError(t, err)
+	verification, err := signatory.Verify(chartPath, provPath)
+	require.NoError(t, err)
+	assert.Contains(t, verification.SignedBy.Identities, "Test Signer")
+
+	t.Run("should fail on unknown key", func(t *testing.T) {
+		_, _, err := env.run("push", "--sign", "--key", "Somebody Else", "--keyring", keyring, env.chart("foo", "1.1.0"), testRepoName)
+		require.ErrorContains(t, err, "private key not found")
+	})
+
+	t.Run("should require key", func(t *testing.T) {
+		_, _, err := env.run("push", "--sign", "--keyring", keyring, env.chart("foo", "1.1.0"), testRepoName)
+		require.ErrorContains(t, err, "--sign requires --key")
+	})
+}
+
+func TestPush_Verify(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	keyring := env.keyring("Test Signer")
+
+	chartPath := env.chart("foo", "1.0.0")
+	env.sign(chartPath, keyring, "Test Signer")
+	env.mustRun("push", "--verify", "--keyring", keyring, chartPath, testRepoName)
+
+	_, ok := env.store.Get(env.repoURL + "/foo-1.0.0.tgz.prov")
+	assert.True(t, ok)
+
+	t.Run("should verify generated provenance file", func(t *testing.T) {
+		env.mustRun("push", "--sign", "--verify", "--key", "Test Signer", "--keyring", keyring, env.chart("foo", "1.1.0"), testRepoName)
+	})
+
+	t.Run("should fail on unknown signer", func(t *testing.T) {
+		chartPath := env.chart("foo", "1.2.0")
+		env.sign(chartPath, keyring, "Test Signer")
+
+		_, _, err := env.run("push", "--verify", "--keyring", env.keyring("Somebody Else"), chartPath, testRepoName)
+		require.ErrorContains(t, err, "verify chart foo 1.2.0")
+		_, ok := env.store.Get(env.repoURL + "/foo-1.2.0.tgz")
+		assert.False(t, ok)
+	})
+
+	t.Run("should fail on digest mismatch", func(t *testing.T) {
+		chartPath := env.chart("foo", "1.2.0")
+		env.sign(chartPath, keyring, "Test Signer")
+		// Repackage the chart, so that it differs from the signed one.
+		require.Equal(t, chartPath, env.chartWithDescription("foo", "1.2.0", "changed"))
+
+		_, _, err := env.run("push", "--verify", "--keyring", keyring, chartPath, testRepoName)
+		require.ErrorContains(t, err, "verify chart foo 1.2.0")
+		_, ok := env.store.Get(env.repoURL + "/foo-1.2.0.tgz")
+		assert.False(t, ok)
+	})
+
+	t.Run("should fail on missing provenance file", func(t *testing.T) {
+		_, _, err := env.run("push", "--verify", "--keyring", keyring, env.chart("bar", "1.0.0"), testRepoName)
+		require.ErrorContains(t, err, "chart bar 1.0.0 is not signed")
+	})
+}
+
+func TestPush_RequireProvenance(t *testing.T) {
+	env := newTestEnv(t)
+	allowed := env.keyring("Allowed Signer")
+	other := env.keyring("Other Signer")
+
+	// Keyrings are sequences of keys, so they can be concatenated.
+	keyring := filepath.Join(t.TempDir(), "pubring.gpg")
+	a, err := os.ReadFile(allowed)
+	require.NoError(t, err)
+	b, err := os.ReadFile(other)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(keyring, append(a, b...), 0o600))
+
+	env.mustRun("init", "--require-provenance", "--allowed-signer", strings.ToLower(env.fingerprint(allowed)), env.repoURL)
+	env.addRepo()
+
+	chartPath := env.chart("foo", "1.0.0")
+	env.sign(chartPath, allowed, "Allowed Signer")
+	env.mustRun("push", "--keyring", keyring, chartPath, testRepoName)
+	assert.Len(t, env.index().Entries["foo"], 1)
+
+	t.Run("should fail on unsigned chart", func(t *testing.T) {
+		_, _, err := env.run("push", "--keyring", keyring, env.chart("bar", "1.0.0"), testRepoName)
+		require.ErrorContains(t, err, "the repository requires provenance: chart bar 1.0.0 is not signed")
+		assert.Equal(t, errorCodePolicyViolation, errorCode(err))
+	})
+
+	t.Run("should fail on signer not in the allow-list", func(t *testing.T) {
+		chartPath := env.chart("foo", "1.1.0")
+		env.sign(chartPath, other, "Other Signer")
+
+		_, _, err := env.run("push", "--keyring", keyring, chartPath, testRepoName)
+		require.ErrorContains(t, err, `chart foo 1.1.0 is signed by "Other Signer" with key `+env.fingerprint(other)+", which is not allowed")
+		assert.Equal(t, errorCodePolicyViolation, errorCode(err))
+		assert.Len(t, env.index().Entries["foo"], 1)
+	})
+
+	t.Run("should fail on signer not in the keyring", func(t *testing.T) {
+		chartPath := env.chart("foo", "1.1.0")
+		env.sign(chartPath, allowed, "Allowed Signer")
+
+		_, _, err := env.run("push", "--keyring", other, chartPath, testRepoName)
+		require.ErrorContains(t, err, "verify chart foo 1.1.0")
+	})
+}
+
+func TestPush_Immutable(t *testing.T) {
+	env := newTestEnv(t)
+	env.mustRun("init", "--immutable", env.repoURL)
+	env.addRepo()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+
+	_, _, err := env.run("push", "--force", env.chartWithDescription("foo", "1.0.0", "replaced"), testRepoName)
+	require.ErrorContains(t, err, "the repository is immutable")
+	assert.Equal(t, errorCodeImmutable, errorCode(err))
+	assert.Empty(t, env.index().Entries["foo"][0].Description)
+
+	t.Run("should allow ignoring existing charts", func(t *testing.T) {
+		env.mustRun("push", "--ignore-if-exists", env.chart("foo", "1.0.0"), testRepoName)
+	})
+}
+
+func TestPush_Idempotent(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+
+	chartPath := env.chart("foo", "1.0.0")
+	env.mustRun("push", "--idempotent", chartPath, testRepoName)
+	assert.Len(t, env.index().Entries["foo"], 1)
+
+	out := env.mustRun("push", "--idempotent", chartPath, testRepoName)
+	assert.Contains(t, out, "The identical chart already exists in the repository, nothing to push.")
+
+	t.Run("should fail on different content", func(t *testing.T) {
+		_, stderr, err := env.run("push", "--idempotent", env.chartWithDescription("foo", "1.0.0", "changed"), env.chart("bar", "1.0.0"), testRepoName)
+		require.ErrorContains(t, err, "charts already exist in the repository with different content: foo 1.0.0")
+		assert.Equal(t, errorCodeDigestMismatch, errorCode(err))
+		assert.Contains(t, stderr, "foo 1.0.0: local digest ")
+		assert.Empty(t, env.index().Entries["foo"][0].Description)
+		assert.Empty(t, env.index().Entries["bar"], "nothing must be pushed")
+	})
+
+	t.Run("should fall back to the index digest", func(t *testing.T) {
+		// Re-upload the same chart without object metadata.
+		data, err := os.ReadFile(env.chart("foo", "1.0.0"))
+		require.NoError(t, err)
+		env.store.Put(env.repoURL+"/foo-1.0.0.tgz", data, nil)
+
+		out := env.mustRun("push", "--idempotent", env.chart("foo", "1.0.0"), testRepoName)
+		assert.Contains(t, out, "The identical chart already exists in the repository")
+
+		_, _, err = env.run("push", "--idempotent", env.chartWithDescription("foo", "1.0.0", "changed"), testRepoName)
+		assert.Equal(t, errorCodeDigestMismatch, errorCode(err))
+	})
+
+	t.Run("should not be used with --force", func(t *testing.T) {
+		_, _, err := env.run("push", "--idempotent", "--force", chartPath, testRepoName)
+		require.ErrorContains(t, err, "--idempotent flag cannot be used with --force or --ignore-if-exists")
+	})
+}
@@ -3,30 +3,74 @@ package main
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
-	"github.com/hypnoglow/helm-s3/internal/awss3"
-	"github.com/hypnoglow/helm-s3/internal/awsutil"
 	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
 )
 
 const reindexDesc = `This command performs a reindex of the repository.
 
 'helm s3 push' takes one argument:
 - REPO - target repository.
+
+Charts are inspected concurrently, use --concurrency to tune the number of
+requests made at once. The order of entries in the index does not depend on it.
+
+[Incremental reindex]
+
+With --incremental, only charts added or changed since the last reindex are
+inspected; entries of the other charts are kept as they are in the current
+index, and entries of charts that no longer exist are removed. Charts are
+compared by object size and modification time recorded on the last reindex
+in the 'index.yaml.state' file next to the index.
+
+[Layout]
+
+Only charts in the repository root are indexed, unless the repository uses
+the nested layout (see 'helm s3 init --layout') or --layout nested is set;
+then charts in directories under the repository root are indexed as well.
+
+[Dry run]
+
+With --dry-run, the new index is built but not uploaded. Instead, the changes
+it would make to the current index are printed: added and removed chart
+versions, versions with changed digests or URLs, and charts that had to be
+downloaded because their object has no chart metadata.
+
+[Immutability]
+
+If the repository is immutable (see 'helm s3 init --immutable'), reindex fails
+without updating the index if it would change digests of existing chart
+versions, e.g. because a chart object was overwritten in the bucket directly.
 `
 
-const reindexExample = `  helm s3 reindex my-repo - performs a reindex of the repository with name 'my-repo'.`
+const reindexExample = `  helm s3 reindex my-repo - performs a reindex of the repository with name 'my-repo'.
+
+  helm s3 reindex --incremental my-repo - performs a reindex, inspecting only added or changed charts.
+
+  helm s3 reindex --dry-run my-repo - prints changes a reindex would make to the index.`
+
+// defaultReindexConcurrency is the default number of charts inspected
+// concurrently during reindex.
+const defaultReindexConcurrency = 10
 
 func newReindexCommand(opts *options) *cobra.Command {
 	act := &reindexAction{
-		printer:  nil,
-		acl:      "",
-		verbose:  false,
-		repoName: "",
-		relative: false,
+		printer:     nil,
+		result:      &commandResult{Command: "reindex"},
+		acl:         "",
+		verbose:     false,
+		lock:        lockOptions{},
+		repoName:    "",
+		relative:    false,
+		concurrency: defaultReindexConcurrency,
+		incremental: false,
+		dryRun:      false,
+		layout:      "",
 	}
 
 	cmd := &cobra.Command{
@@ -40,27 +84,39 @@ func newReindexCommand(opts *options) *cobra.Command {
 			return nil, cobra.ShellCompDirectiveNoFileComp
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			act.printer = cmd
+			if err := validateOutput(opts.output, outputText, outputJSON); err != nil {
+				return err
+			}
+			act.printer = newPrinter(cmd, opts.output)
 			act.acl = opts.acl
 			act.verbose = opts.verbose
+			act.lock = opts.lock
 			act.repoName = args[0]
-			return act.run(cmd.Context())
+			act.result.Repo = act.repoName
+			act.result.DryRun = act.dryRun
+			return reportResult(cmd, opts.output, act.result, act.run(cmd.Context()))
 		},
 	}
 
 	flags := cmd.Flags()
 	flags.BoolVar(&act.relative, "relative", act.relative, "Use relative chart URLs in the index instead of absolute.")
+	flags.IntVar(&act.concurrency, "concurrency", act.concurrency, "Maximum number of charts inspected concurrently.")
+	flags.BoolVar(&act.incremental, "incremental", act.incremental, "Reuse entries of the current index for charts that have not changed since the last reindex.")
+	flags.BoolVar(&act.dryRun, "dry-run", act.dryRun, "Print changes to the index instead of updating it.")
+	flags.StringVar(&act.layout, "layout", act.layout, "Layout of the repository: flat or nested. Defaults to the layout set on init, or flat.")
 
 	return cmd
 }
 
 type reindexAction struct {
 	printer printer
+	result  *commandResult
 
 	// global flags
 
 	acl     string
 	verbose bool
+	lock    lockOptions
 
 	// args
 
@@ -68,40 +124,165 @@ type reindexAction struct {
 
 	// flags
 
-	relative bool
+	relative    bool
+	concurrency int
+	incremental bool
+	dryRun      bool
+	layout      string
 }
 
 func (act *reindexAction) run(ctx context.Context) error {
+	if act.concurrency < 1 {
+		return newBadUsageError(errors.New("--concurrency must be a positive number"))
+	}
+	if err := validateLayout(act.layout); err != nil {
+		return err
+	}
+
 	repoEntry, err := helmutil.LookupRepoEntry(act.repoName)
 	if err != nil {
 		return err
 	}
 
-	sess, err := awsutil.Session(awsutil.DynamicBucketRegion(repoEntry.URL()))
+	act.result.RepoURL = repoEntry.URL()
+
+	store, err := storage.New(repoEntry.URL())
+	if err != nil {
+		return err
+	}
+
+	settings, err := fetchRepoSettings(ctx, store, repoEntry.URL())
 	if err != nil {
 		return err
 	}
-	storage := awss3.New(sess)
+	layout := resolveLayout(act.layout, settings)
+
+	if !act.dryRun {
+		lockCtx, unlock, err := lockRepo(ctx, store, repoEntry.URL(), act.acl, act.lock, act.printer)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		ctx = lockCtx
+	}
 
-	items, errs := storage.Traverse(ctx, repoEntry.URL())
+	// Remember the state of the current index, so that charts pushed
+	// concurrently during the reindex are not lost silently.
+	cond := storage.Precondition{IfNoneMatch: "*"}
+	b, etag, err := store.FetchRaw(ctx, repoEntry.IndexURL())
+	switch {
+	case err == nil:
+		cond = storage.Precondition{IfMatch: etag}
+	case errors.Is(err, storage.ErrObjectNotFound):
+		// The index does not exist, reindex creates it.
+		b = nil
+	default:
+		return errors.WithMessage(err, "fetch current repo index")
+	}
 
+	current := helmutil.NewIndex()
+	if b != nil {
+		if err := current.UnmarshalBinary(b); err != nil {
+			if act.incremental {
+				return errors.WithMessage(err, "load current repo index")
+			}
+			// The index may be broken, which is a reason to reindex in the
+			// first place. It is rebuilt from scratch anyway.
+			act.printer.PrintErrf("[WARNING] failed to load current repo index, chart creation times are not preserved: %s\n", err)
+			current = helmutil.NewIndex()
+		}
+	}
+
+	// Creation times of charts are carried over from the current index.
+	created := createdByDigest(current)
+
+	// For incremental reindex, entries of unchanged charts are reused from
+	// the current index, so the charts are not loaded again.
+	state := newReindexState()
+	known := make(map[string][]helmutil.IndexEntry)
+	if act.incremental {
+		known = entriesByFilename(current, repoEntry.URL())
+		if b != nil {
+			if state, err = fetchReindexState(ctx, store, repoEntry.URL()); err != nil {
+				return err
+			}
+		}
+	}
+
+	opts := storage.TraverseOptions{
+		Concurrency: act.concurrency,
+		Recursive:   layout == layoutNested,
+	}
+	if act.incremental {
+		opts.Skip = func(info storage.ChartInfo) bool {
+			return state.unchanged(info, entryDigests(known[info.Filename]))
+		}
+	}
+
+	items, errs := store.Traverse(ctx, repoEntry.URL(), opts)
+
+	newState := newReindexState()
+	var downloaded []string
 	builtIndex := make(chan helmutil.Index, 1)
 	go func() {
-		idx := helmutil.NewIndex()
+		// Skipped items have no metadata, their entries are kept as is.
+		var loaded []storage.ChartInfo
+		kept := make(map[string]bool)
 		for item := range items {
-			baseURL := repoEntry.URL()
-			if act.relative {
-				baseURL = ""
+			if item.Downloaded {
+				downloaded = append(downloaded, item.Filename)
 			}
+			if item.Meta == nil {
+				if act.verbose {
+					act.printer.Printf("[DEBUG] Keeping unchanged %s in index.\n", item.Filename)
+				}
+				kept[item.Filename] = true
+				newState.add(item, state.Objects[item.Filename].Digest)
+				continue
+			}
+			loaded = append(loaded, item)
+			newState.add(item, item.Hash)
+		}
 
+		// The current index is kept intact to compare against on dry run.
+		idx := helmutil.NewIndex()
+		if act.incremental && b != nil {
+			// Cannot fail, the same data was unmarshaled before.
+			_ = idx.UnmarshalBinary(b)
+		}
+
+		// Drop entries of vanished and changed charts, the latter are added
+		// again below.
+		for filename, entries := range known {
+			if kept[filename] {
+				continue
+			}
+			for _, entry := range entries {
+				if act.verbose {
+					act.printer.Printf("[DEBUG] Removing %s from index.\n", filename)
+				}
+				if _, err := idx.Delete(entry.Name, entry.Version); err != nil {
+					act.printer.PrintErrf("[ERROR] failed to remove chart from the index: %s", err)
+				}
+			}
+		}
+
+		for _, item := range loaded {
 			if act.verbose {
 				act.printer.Printf("[DEBUG] Adding %s to index.\n", item.Filename)
 			}
 
-			filename := escapeIfRelative(item.Filename, act.relative)
+			filename, baseURL := indexLocation(repoEntry.URL(), item.Filename, act.relative)
 
 			if err := idx.Add(item.Meta.Value(), filename, baseURL, item.Hash); err != nil {
 				act.printer.PrintErrf("[ERROR] failed to add chart to the index: %s", err)
+				continue
+			}
+
+			if t := chartCreated(item, created); !t.IsZero() {
+				if err := idx.SetCreated(item.Meta.Name(), item.Meta.Version(), t); err != nil {
+					act.printer.PrintErrf("[ERROR] failed to set chart creation time in the index: %s", err)
+				}
 			}
 		}
 		idx.SortEntries()
@@ -116,15 +297,45 @@ func (act *reindexAction) run(ctx context.Context) error {
 
 	idx := <-builtIndex
 
+	diff := helmutil.DiffIndex(current, idx)
+	act.result.Reindex = &reindexResult{
+		Charts:     len(idx.Entries()),
+		Added:      len(diff.Added),
+		Removed:    len(diff.Removed),
+		Changed:    len(diff.Changed),
+		Downloaded: len(downloaded),
+	}
+
+	if settings.Immutable {
+		if err := immutableDigestsError(diff, "reindex"); err != nil {
+			return err
+		}
+	}
+
+	if act.dryRun {
+		act.printDiff(diff, downloaded)
+		return nil
+	}
+
 	r, err := idx.Reader()
 	if err != nil {
 		return errors.Wrap(err, "get index reader")
 	}
 
-	if err := storage.PutIndex(ctx, repoEntry.URL(), act.acl, r); err != nil {
+	if err := putIndex(ctx, store, repoEntry.URL(), act.acl, r, cond, settings.IndexGenerations, act.printer); err != nil {
+		if errors.Is(err, storage.ErrPreconditionFailed) {
+			return withErrorCode(errorCodeIndexConflict, errors.New("the index was modified concurrently during reindex, run reindex again"))
+		}
 		return errors.Wrap(err, "upload index to the repository")
 	}
 
+	// The state is written after the index, so that it never describes
+	// objects the index was not built from. Failing to write it only makes
+	// the next incremental reindex load more charts.
+	if err := putReindexState(ctx, store, repoEntry.URL(), act.acl, newState); err != nil {
+		act.printer.PrintErrf("[WARNING] failed to save reindex state: %s\n", err)
+	}
+
 	if err := idx.WriteFile(repoEntry.CacheFile(), helmutil.DefaultIndexFilePerm); err != nil {
 		return errors.WithMessage(err, "update local index")
 	}
@@ -132,3 +343,42 @@ func (act *reindexAction) run(ctx context.Context) error {
 	act.printer.Printf("Repository %s was successfully reindexed.\n", act.repoName)
 	return nil
 }
+
+// printDiff prints changes the reindex would make to the index, and charts
+// that had to be downloaded to obtain their metadata.
+func (act *reindexAction) printDiff(diff helmutil.IndexDiff, downloaded []string) {
+	act.printer.Printf("Dry run: the index of repository %s is not updated.\n", act.repoName)
+
+	printIndexDiff(act.printer, diff)
+
+	if len(downloaded) > 0 {
+		act.printer.Printf("\nDownloaded charts (no chart metadata in the object metadata):\n")
+		for _, filename := range downloaded {
+			act.printer.Printf("  %s\n", filename)
+		}
+	}
+
+	act.printer.Printf(
+		"\nSummary: %d added, %d removed, %d changed, %d downloaded.\n",
+		len(diff.Added), len(diff.Removed), len(diff.Changed), len(downloaded),
+	)
+}
+
+// immutableDigestsError returns an error listing chart versions whose digests
+// are changed by the command, e.g. reindex, if any.
+func immutableDigestsError(diff helmutil.IndexDiff, command string) error {
+	var changed []string
+	for _, change := range diff.Changed {
+		if change.DigestChanged() {
+			changed = append(changed, change.Old.Name+" "+change.Old.Version)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	return withErrorCode(errorCodeImmutable, fmt.Errorf(
+		"the repository is immutable, but %s would change digests of chart versions: %s",
+		command, strings.Join(changed, ", "),
+	))
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hypnoglow/helm-s3/internal/storage/storagetest"
+)
+
+func TestReindex(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+
+	for _, version := range []string{"1.0.0", "1.1.0", "1.2.0"} {
+		env.mustRun("push", env.chart("foo", version), testRepoName)
+	}
+	env.mustRun("push", env.chart("bar", "0.1.0"), testRepoName)
+
+	// Strip the metadata from one of the charts, as if it was uploaded
+	// manually, and reset the index.
+	bar, ok := env.store.Get(env.repoURL + "/bar-0.1.0.tgz")
+	require.True(t, ok)
+	env.store.Put(env.repoURL+"/bar-0.1.0.tgz", bar.Data, nil)
+	env.mustRun("init", "--force", env.repoURL)
+	require.Empty(t, env.index().Entries)
+
+	env.store.SetLatency(20 * time.Millisecond)
+
+	out := env.mustRun("reindex", "--concurrency", "4", testRepoName)
+	assert.Contains(t, out, "Repository test-repo was successfully reindexed.")
+
+	idx := env.index()
+	require.Len(t, idx.Entries["foo"], 3)
+	assert.Equal(t, "1.2.0", idx.Entries["foo"][0].Version)
+	require.Len(t, idx.Entries["bar"], 1)
+	assert.Equal(t, bar.Metadata[storagetest.MetaChartDigest], idx.Entries["bar"][0].Digest)
+}
+
+func TestReindex_Error(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+
+	env.store.FailOn(storagetest.OpLoadChart, errors.New("access denied"))
+
+	_, _, err := env.run("reindex", testRepoName)
+	require.ErrorContains(t, err, "access denied")
+	assert.Len(t, env.index().Entries["foo"], 1)
+}
+
+func TestReindex_BadConcurrency(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+
+	_, _, err := env.run("reindex", "--concurrency", "0", testRepoName)
+	require.Error(t, err)
+	assert.True(t, errorTypeBadUsage.Is(err))
+}
+
+func TestReindex_Incremental(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+	env.mustRun("push", env.chart("foo", "1.1.0"), testRepoName)
+	env.mustRun("push", env.chart("bar", "0.1.0"), testRepoName)
+	env.mustRun("reindex", testRepoName)
+
+	unchanged := env.index().Entries["foo"][1]
+	require.Equal(t, "1.0.0", unchanged.Version)
+
+	// Change the repository behind the plugin's back: remove a chart,
+	// replace a chart and add a new one.
+	require.NoError(t, env.store.DeleteChart(context.Background(), env.repoURL+"/bar-0.1.0.tgz"))
+	replaced, err := os.ReadFile(env.chartWithDescription("foo", "1.1.0", "replaced"))
+	require.NoError(t, err)
+	env.store.Put(env.repoURL+"/foo-1.1.0.tgz", replaced, nil)
+	added, err := os.ReadFile(env.chart("baz", "0.1.0"))
+	require.NoError(t, err)
+	env.store.Put(env.repoURL+"/baz-0.1.0.tgz", added, nil)
+
+	calls := len(env.store.Calls())
+	env.mustRun("reindex", "--incremental", testRepoName)
+
+	var loaded []string
+	for _, call := range env.store.Calls()[calls:] {
+		if call.Op == storagetest.OpLoadChart {
+			loaded = append(loaded, call.URI)
+		}
+	}
+	assert.ElementsMatch(t, []string{
+		env.repoURL + "/baz-0.1.0.tgz",
+		env.repoURL + "/foo-1.1.0.tgz",
+	}, loaded)
+
+	idx := env.index()
+	assert.Empty(t, idx.Entries["bar"])
+	require.Len(t, idx.Entries["baz"], 1)
+	require.Len(t, idx.Entries["foo"], 2)
+	assert.Equal(t, "replaced", idx.Entries["foo"][0].Description)
+	assert.Equal(t, unchanged.Digest, idx.Entries["foo"][1].Digest)
+	assert.Equal(t, unchanged.Created, idx.Entries["foo"][1].Created)
+
+	t.Run("should load nothing when nothing changed", func(t *testing.T) {
+		calls := len(env.store.Calls())
+		env.mustRun("reindex", "--incremental", testRepoName)
+
+		for _, call := range env.store.Calls()[calls:] {
+			assert.NotEqual(t, storagetest.OpLoadChart, call.Op)
+		}
+		assert.Len(t, env.index().Entries["foo"], 2)
+	})
+}
+
+func TestReindex_PreservesCreated(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+	env.mustRun("push", env.chart("bar", "1.0.0"), testRepoName)
+	env.mustRun("push", env.chart("baz", "1.0.0"), testRepoName)
+
+	created := env.index().Entries["foo"][0].Created
+
+	// Forget bar and baz: they are no longer in the index, and baz has
+	// no object metadata, as if it was uploaded manually.
+	env.mustRun("delete", "bar", "--version", "1.0.0", testRepoName)
+	env.mustRun("delete", "baz", "--version", "1.0.0", testRepoName)
+	bar, err := os.ReadFile(env.chart("bar", "1.0.0"))
+	require.NoError(t, err)
+	env.store.Put(env.repoURL+"/bar-1.0.0.tgz", bar, map[string]string{
+		storagetest.MetaChartCreated: "2020-01-02T03:04:05Z",
+	})
+	baz, err := os.ReadFile(env.chart("baz", "1.0.0"))
+	require.NoError(t, err)
+	env.store.Put(env.repoURL+"/baz-1.0.0.tgz", baz, nil)
+	bazObject, ok := env.store.Get(env.repoURL + "/baz-1.0.0.tgz")
+	require.True(t, ok)
+
+	env.mustRun("reindex", testRepoName)
+
+	idx := env.index()
+	assert.True(t, created.Equal(idx.Entries["foo"][0].Created), "created time of unchanged chart must be kept")
+	assert.True(t, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC).Equal(idx.Entries["bar"][0].Created), "created time must be taken from the object metadata")
+	assert.True(t, bazObject.LastModified.Equal(idx.Entries["baz"][0].Created), "created time must fall back to the object modification time")
+}
+
+func TestReindex_DryRun(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+	env.mustRun("push", env.chart("bar", "1.0.0"), testRepoName)
+
+	// Remove bar and replace foo behind the plugin's back, and upload baz
+	// without object metadata.
+	require.NoError(t, env.store.DeleteChart(context.Background(), env.repoURL+"/bar-1.0.0.tgz"))
+	replaced, err := os.ReadFile(env.chartWithDescription("foo", "1.0.0", "replaced"))
+	require.NoError(t, err)
+	env.store.Put(env.repoURL+"/foo-1.0.0.tgz", replaced, nil)
+	added, err := os.ReadFile(env.chart("baz", "0.1.0"))
+	require.NoError(t, err)
+	env.store.Put(env.repoURL+"/baz-0.1.0.tgz", added, nil)
+
+	before, ok := env.store.Get(env.repoURL + "/index.yaml")
+	require.True(t, ok)
+
+	out := env.mustRun("reindex", "--dry-run", testRepoName)
+
+	assert.Contains(t, out, "Added versions:\n  + baz 0.1.0\n")
+	assert.Contains(t, out, "Removed versions:\n  - bar 1.0.0\n")
+	assert.Contains(t, out, "Changed versions:\n  ~ foo 1.0.0\n      digest: ")
+	assert.Contains(t, out, "Downloaded charts (no chart metadata in the object metadata):\n  baz-0.1.0.tgz\n  foo-1.0.0.tgz\n")
+	assert.Contains(t, out, "Summary: 1 added, 1 removed, 1 changed, 2 downloaded.")
+
+	after, ok := env.store.Get(env.repoURL + "/index.yaml")
+	require.True(t, ok)
+	assert.Equal(t, before.ETag, after.ETag, "index must not be updated on dry run")
+	_, ok = env.store.Get(env.repoURL + "/index.yaml.state")
+	assert.False(t, ok, "reindex state must not be saved on dry run")
+}
+
+func TestReindex_NestedLayout(t *testing.T) {
+	env := newTestEnv(t)
+	env.mustRun("init", "--layout", "nested", env.repoURL)
+	env.addRepo()
+
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+	env.mustRun("push", "--layout", "flat", env.chart("bar", "1.0.0"), testRepoName)
+	env.mustRun("init", "--force", env.repoURL)
+	require.Empty(t, env.index().Entries)
+
+	env.mustRun("reindex", testRepoName)
+
+	idx := env.index()
+	require.Len(t, idx.Entries["foo"], 1)
+	assert.Equal(t, []string{env.repoURL + "/foo/foo-1.0.0.tgz"}, idx.Entries["foo"][0].URLs)
+	require.Len(t, idx.Entries["bar"], 1)
+
+	t.Run("should skip nested charts with --layout flat", func(t *testing.T) {
+		env.mustRun("reindex", "--layout", "flat", testRepoName)
+
+		idx := env.index()
+		assert.Empty(t, idx.Entries["foo"])
+		assert.Len(t, idx.Entries["bar"], 1)
+	})
+
+	t.Run("should keep nested charts on incremental reindex", func(t *testing.T) {
+		env.mustRun("reindex", "--relative", testRepoName)
+		env.mustRun("reindex", "--incremental", "--relative", testRepoName)
+
+		idx := env.index()
+		require.Len(t, idx.Entries["foo"], 1)
+		assert.Equal(t, []string{"foo/foo-1.0.0.tgz"}, idx.Entries["foo"][0].URLs)
+	})
+}
+
+func TestReindex_OutputJSON(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+	env.mustRun("push", env.chart("foo", "1.1.0"), testRepoName)
+	env.mustRun("init", "--force", env.repoURL)
+
+	stdout, stderr, err := env.run("reindex", "-o", "json", testRepoName)
+	require.NoError(t, err, stderr)
+
+	var res commandResult
+	require.NoError(t, json.Unmarshal([]byte(stdout), &res))
+	assert.Equal(t, "reindex", res.Command)
+	assert.Equal(t, &reindexResult{Charts: 2, Added: 2}, res.Reindex)
+}
+
+func TestReindex_Immutable(t *testing.T) {
+	env := newTestEnv(t)
+	env.mustRun("init", "--immutable", env.repoURL)
+	env.addRepo()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+
+	// Adding charts is fine.
+	added, err := os.ReadFile(env.chart("bar", "1.0.0"))
+	require.NoError(t, err)
+	env.store.Put(env.repoURL+"/bar-1.0.0.tgz", added, nil)
+	env.mustRun("reindex", testRepoName)
+	assert.Len(t, env.index().Entries["bar"], 1)
+
+	// Replace foo behind the plugin's back.
+	replaced, err := os.ReadFile(env.chartWithDescription("foo", "1.0.0", "replaced"))
+	require.NoError(t, err)
+	env.store.Put(env.repoURL+"/foo-1.0.0.tgz", replaced, nil)
+
+	before, ok := env.store.Get(env.repoURL + "/index.yaml")
+	require.True(t, ok)
+
+	_, _, err = env.run("reindex", testRepoName)
+	require.ErrorContains(t, err, "the repository is immutable, but reindex would change digests of chart versions: foo 1.0.0")
+	assert.Equal(t, errorCodeImmutable, errorCode(err))
+
+	after, ok := env.store.Get(env.repoURL + "/index.yaml")
+	require.True(t, ok)
+	assert.Equal(t, before.ETag, after.ETag, "index must not be updated")
+}
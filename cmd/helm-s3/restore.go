@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+const restoreDesc = `This command restores a prior version of a chart archive from S3 object versions.
+
+'helm s3 restore' takes two arguments:
+- NAME - name of the chart,
+- REPO - target repository.
+
+The object version chosen with --object-version (see 'helm s3 history') is
+copied over the chart archive, so it becomes current again; all versions,
+including the replaced one, are kept. The index entry of the chart version is
+updated with the digest and metadata of the restored archive, keeping its
+creation time, or added back if the chart version was deleted from the index.
+
+If the chart has a provenance file, the provenance file version uploaded along
+with the restored archive is restored as well.
+
+This requires versioning to be enabled for the bucket. Restoring charts is
+not allowed in an immutable repository.
+`
+
+const restoreExample = `  helm s3 restore epicservice --version 0.5.1 --object-version 3HL4kqtJlcpXroDTDmJ.rmSpXd3dIbrHY my-repo - brings back the chart archive replaced by 'helm s3 push --force'.`
+
+func newRestoreCommand(opts *options) *cobra.Command {
+	act := &restoreAction{
+		printer:         nil,
+		result:          &commandResult{Command: "restore"},
+		acl:             "",
+		maxIndexRetries: 0,
+		lock:            lockOptions{},
+		chartName:       "",
+		repoName:        "",
+		version:         "",
+		objectVersion:   "",
+	}
+
+	cmd := &cobra.Command{
+		Use:     "restore NAME REPO",
+		Short:   "Restore a prior version of a chart archive.",
+		Long:    restoreDesc,
+		Example: restoreExample,
+		Args:    wrapPositionalArgsBadUsage(cobra.ExactArgs(2)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// No completions for the NAME and REPO arguments.
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(opts.output, outputText, outputJSON); err != nil {
+				return err
+			}
+			act.printer = newPrinter(cmd, opts.output)
+			act.acl = opts.acl
+			act.maxIndexRetries = opts.maxIndexRetries
+			act.lock = opts.lock
+			act.chartName = args[0]
+			act.repoName = args[1]
+			act.result.Repo = act.repoName
+			return reportResult(cmd, opts.output, act.result, act.run(cmd.Context()))
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&act.version, "version", act.version, "Version of the chart.")
+	flags.StringVar(&act.objectVersion, "object-version", act.objectVersion, "ID of the chart archive object version to restore, see 'helm s3 history'.")
+	_ = cobra.MarkFlagRequired(flags, "version")
+	_ = cobra.MarkFlagRequired(flags, "object-version")
+
+	return cmd
+}
+
+type restoreAction struct {
+	printer printer
+	result  *commandResult
+
+	// global flags
+
+	acl             string
+	maxIndexRetries int
+	lock            lockOptions
+
+	// args
+
+	chartName string
+	repoName  string
+
+	// flags
+
+	version       string
+	objectVersion string
+}
+
+func (act *restoreAction) run(ctx context.Context) error {
+	repoEntry, err := helmutil.LookupRepoEntry(act.repoName)
+	if err != nil {
+		return err
+	}
+
+	act.result.RepoURL = repoEntry.URL()
+
+	store, err := storage.New(repoEntry.URL())
+	if err != nil {
+		return err
+	}
+
+	versioner, err := repoVersioner(store)
+	if err != nil {
+		return err
+	}
+
+	settings, err := fetchRepoSettings(ctx, store, repoEntry.URL())
+	if err != nil {
+		return err
+	}
+	if settings.Immutable {
+		return withErrorCode(errorCodeImmutable, errors.New("the repository is immutable, published chart versions cannot be replaced"))
+	}
+
+	ctx, unlock, err := lockRepo(ctx, store, repoEntry.URL(), act.acl, act.lock, act.printer)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	idx, _, err := fetchIndex(ctx, store, repoEntry)
+	if err != nil {
+		return err
+	}
+	chartURL, _, _ := chartObjectURL(idx, repoEntry.URL(), resolveLayout("", settings), act.chartName, act.version)
+
+	versions, err := objectVersions(ctx, versioner, chartURL)
+	if err != nil {
+		return err
+	}
+	chosen, current, next, err := act.findVersion(versions)
+	if err != nil {
+		return err
+	}
+
+	if !chosen.IsLatest {
+		if err := versioner.RestoreObjectVersion(ctx, chartURL, chosen.VersionID, act.acl); err != nil {
+			return errors.WithMessagef(err, "restore version %s of chart file %s", chosen.VersionID, chartURL)
+		}
+	}
+
+	info, err := act.loadChart(ctx, store, chartURL)
+	if err == nil && (info.Meta.Name() != act.chartName || info.Meta.Version() != act.version) {
+		err = fmt.Errorf("object version %s is chart %s %s, not %s %s", chosen.VersionID, info.Meta.Name(), info.Meta.Version(), act.chartName, act.version)
+	}
+	if err != nil {
+		// Bring back the version that was current, so that the chart archive
+		// matches the index again.
+		if !chosen.IsLatest && current != nil {
+			if rerr := versioner.RestoreObjectVersion(ctx, chartURL, current.VersionID, act.acl); rerr != nil {
+				act.printer.PrintErrf("Failed to bring back version %s of chart file %s: %v\n", current.VersionID, chartURL, rerr)
+			}
+		}
+		return err
+	}
+
+	if err := act.restoreProvenance(ctx, versioner, chartURL, chosen, next); err != nil {
+		return err
+	}
+
+	idx, err = updateIndex(ctx, store, repoEntry, act.acl, act.maxIndexRetries, act.printer, func(idx helmutil.Index) error {
+		return act.updateEntry(idx, repoEntry.URL(), chartURL, info, chosen)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := idx.WriteFile(repoEntry.CacheFile(), helmutil.DefaultIndexFilePerm); err != nil {
+		return errors.WithMessage(err, "update local index")
+	}
+
+	act.result.Charts = []chartResult{{
+		Name:    act.chartName,
+		Version: act.version,
+		URL:     chartURL,
+		Digest:  info.Hash,
+	}}
+
+	act.printer.Printf("Successfully restored version %s of the chart, the index is updated.\n", chosen.VersionID)
+	return nil
+}
+
+// findVersion returns the chosen version of the chart archive, the current
+// version if any, and the version that replaced the chosen one if any.
+func (act *restoreAction) findVersion(versions []storage.ObjectVersion) (chosen storage.ObjectVersion, current, next *storage.ObjectVersion, err error) {
+	// Versions are ordered newest first.
+	found := false
+	for i, v := range versions {
+		if v.IsLatest && !v.DeleteMarker {
+			current = &versions[i]
+		}
+		if v.VersionID == act.objectVersion {
+			chosen, found = v, true
+			if i > 0 {
+				next = &versions[i-1]
+			}
+		}
+	}
+
+	if !found {
+		return chosen, nil, nil, withErrorCode(errorCodeNotFound, fmt.Errorf("version %s of chart %s %s not found, see 'helm s3 history'", act.objectVersion, act.chartName, act.version))
+	}
+	if chosen.DeleteMarker {
+		return chosen, nil, nil, newBadUsageError(fmt.Errorf("version %s of chart %s %s is a delete marker, choose a prior version", act.objectVersion, act.chartName, act.version))
+	}
+	return chosen, current, next, nil
+}
+
+// loadChart downloads the current chart archive and loads its metadata and
+// digest.
+func (act *restoreAction) loadChart(ctx context.Context, store storage.Storage, chartURL string) (storage.ChartInfo, error) {
+	b, _, err := store.FetchRaw(ctx, chartURL)
+	if err != nil {
+		return storage.ChartInfo{}, errors.WithMessage(err, "fetch restored chart file")
+	}
+	return storage.LoadChartInfo(path.Base(chartURL), bytes.NewReader(b))
+}
+
+// restoreProvenance restores the version of the provenance file uploaded
+// along with the chosen version of the chart archive, i.e. after it but
+// before the next version of the archive. If there is no such version, but
+// the provenance file exists, a warning is printed, because it does not
+// match the restored archive.
+func (act *restoreAction) restoreProvenance(ctx context.Context, versioner storage.Versioner, chartURL string, chosen storage.ObjectVersion, next *storage.ObjectVersion) error {
+	provURL := chartURL + ".prov"
+	versions, err := objectVersions(ctx, versioner, provURL)
+	if err != nil {
+		return err
+	}
+
+	var current, match *storage.ObjectVersion
+	for i, v := range versions {
+		if v.IsLatest && !v.DeleteMarker {
+			current = &versions[i]
+		}
+		if match != nil || v.LastModified.Before(chosen.LastModified) {
+			continue
+		}
+		if next != nil && !v.LastModified.Before(next.LastModified) {
+			continue
+		}
+		match = &versions[i]
+	}
+
+	switch {
+	case match != nil && !match.DeleteMarker:
+		if match.IsLatest {
+			return nil
+		}
+		if err := versioner.RestoreObjectVersion(ctx, provURL, match.VersionID, act.acl); err != nil {
+			return errors.WithMessagef(err, "restore version %s of provenance file %s", match.VersionID, provURL)
+		}
+	case current != nil:
+		act.printer.PrintErrf("[WARNING] The provenance file %s does not belong to the restored chart archive, remove it or sign the chart again.\n", provURL)
+	}
+	return nil
+}
+
+// updateEntry adds or replaces the index entry of the chart version with
+// the restored chart, keeping the URL style and the creation time of the
+// existing entry.
+func (act *restoreAction) updateEntry(idx helmutil.Index, repoURL, chartURL string, info storage.ChartInfo, chosen storage.ObjectVersion) error {
+	created := chosen.LastModified
+	relative := false
+	for _, entry := range idx.Entries() {
+		if entry.Name == act.chartName && entry.Version == act.version {
+			if !entry.Created.IsZero() {
+				created = entry.Created
+			}
+			relative = len(entry.URLs) > 0 && !strings.Contains(entry.URLs[0], "://")
+		}
+	}
+
+	key := strings.TrimPrefix(chartURL, strings.TrimSuffix(repoURL, "/")+"/")
+	filename, baseURL := indexLocation(repoURL, key, relative)
+	if err := idx.AddOrReplace(info.Meta.Value(), filename, baseURL, info.Hash); err != nil {
+		return errors.WithMessagef(err, "add/replace chart %s %s in the index", act.chartName, act.version)
+	}
+	if err := idx.SetCreated(act.chartName, act.version, created); err != nil {
+		return err
+	}
+	idx.SortEntries()
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hypnoglow/helm-s3/internal/storage/storagetest"
+)
+
+func TestHistory(t *testing.T) {
+	env := newTestEnv(t)
+	env.store.EnableVersioning()
+	env.init()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+	env.mustRun("push", "--force", env.chartWithDescription("foo", "1.0.0", "replaced"), testRepoName)
+
+	out := env.mustRun("history", "foo", "--version", "1.0.0", testRepoName)
+	assert.Contains(t, out, "Chart archive "+env.repoURL+"/foo-1.0.0.tgz:\n")
+	assert.Contains(t, out, "Index "+env.repoURL+"/index.yaml:\n")
+	assert.Contains(t, out, "VERSION ID")
+
+	stdout, _, err := env.run("history", "-o", "json", "foo", "--version", "1.0.0", testRepoName)
+	require.NoError(t, err)
+	var history chartHistory
+	require.NoError(t, json.Unmarshal([]byte(stdout), &history))
+	require.Len(t, history.Chart.Versions, 2)
+	assert.True(t, history.Chart.Versions[0].IsLatest)
+	assert.False(t, history.Chart.Versions[1].IsLatest)
+	assert.Len(t, history.Index.Versions, 3, "init and two pushes")
+
+	t.Run("should fail if versioning is disabled", func(t *testing.T) {
+		env := newTestEnv(t)
+		env.init()
+
+		_, _, err := env.run("history", "foo", "--version", "1.0.0", testRepoName)
+		require.ErrorContains(t, err, "enable versioning for the bucket")
+		assert.Equal(t, errorCodeVersioningDisabled, errorCode(err))
+	})
+}
+
+func TestRestore(t *testing.T) {
+	env := newTestEnv(t)
+	env.store.EnableVersioning()
+	env.init()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+	original := env.index().Entries["foo"][0]
+	env.mustRun("push", "--force", env.chartWithDescription("foo", "1.0.0", "replaced"), testRepoName)
+	require.NotEqual(t, original.Digest, env.index().Entries["foo"][0].Digest)
+
+	stdout, _, err := env.run("history", "-o", "json", "foo", "--version", "1.0.0", testRepoName)
+	require.NoError(t, err)
+	var history chartHistory
+	require.NoError(t, json.Unmarshal([]byte(stdout), &history))
+	prior := history.Chart.Versions[1].VersionID
+
+	out := env.mustRun("restore", "foo", "--version", "1.0.0", "--object-version", prior, testRepoName)
+	assert.Contains(t, out, "Successfully restored version "+prior+" of the chart")
+
+	entry := env.index().Entries["foo"][0]
+	assert.Equal(t, original.Digest, entry.Digest, "the index digest must match the restored chart")
+	assert.Empty(t, entry.Description)
+	obj, ok := env.store.Get(env.repoURL + "/foo-1.0.0.tgz")
+	require.True(t, ok)
+	assert.Equal(t, original.Digest, obj.Metadata[storagetest.MetaChartDigest])
+
+	t.Run("should fail on unknown version", func(t *testing.T) {
+		_, _, err := env.run("restore", "foo", "--version", "1.0.0", "--object-version", "unknown", testRepoName)
+		require.ErrorContains(t, err, "version unknown of chart foo 1.0.0 not found")
+		assert.Equal(t, errorCodeNotFound, errorCode(err))
+	})
+}
+
+func TestRestore_Deleted(t *testing.T) {
+	env := newTestEnv(t)
+	env.store.EnableVersioning()
+	env.init()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+	original := env.index().Entries["foo"][0]
+	env.mustRun("delete", "foo", "--version", "1.0.0", testRepoName)
+
+	stdout, _, err := env.run("history", "-o", "json", "foo", "--version", "1.0.0", testRepoName)
+	require.NoError(t, err)
+	var history chartHistory
+	require.NoError(t, json.Unmarshal([]byte(stdout), &history))
+	require.Len(t, history.Chart.Versions, 2)
+	require.True(t, history.Chart.Versions[0].DeleteMarker)
+
+	_, _, err = env.run("restore", "foo", "--version", "1.0.0", "--object-version", history.Chart.Versions[0].VersionID, testRepoName)
+	assert.Equal(t, errorCodeBadUsage, errorCode(err), "delete markers cannot be restored")
+
+	env.mustRun("restore", "foo", "--version", "1.0.0", "--object-version", history.Chart.Versions[1].VersionID, testRepoName)
+
+	entries := env.index().Entries["foo"]
+	require.Len(t, entries, 1, "the chart must be added back to the index")
+	assert.Equal(t, original.Digest, entries[0].Digest)
+	assert.Equal(t, original.URLs, entries[0].URLs)
+}
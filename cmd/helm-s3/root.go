@@ -41,6 +41,31 @@ be reasonable to lower the timeout for the most commands, e.g. to 10 seconds.
 In contrast, in cases where you want to reindex big repository with thousands of
 charts, you definitely want to increase the timeout.
 
+[Concurrent updates]
+
+The index file is updated using conditional writes: if another process modified
+the index between fetching and uploading it, the change is re-applied to the
+fresh index and the upload is retried, up to '--max-index-retries' times.
+
+[Locking]
+
+Some S3-compatible backends do not honor conditional writes. For such backends,
+you can enable the repository lock with '--lock' flag: the plugin acquires the
+lock object 'index.yaml.lock' before modifying the repository and releases it
+afterwards. If the repository is locked by another process, the plugin waits for
+up to '--lock-timeout'. While the lock is held, its lease is renewed every third
+of '--lock-ttl'; if it cannot be renewed, the command is aborted. A lock whose
+lease is not renewed for '--lock-ttl' is considered stale and is taken over.
+Use 'helm s3 lock' to inspect and release stuck locks.
+
+[Structured output]
+
+With '--output json', init, push, delete and reindex print a JSON object
+describing the result to stdout instead of human-readable messages: the
+repository, affected charts with their versions, object URLs and digests,
+whether the operation was skipped, and the error with its code on failure.
+Human-readable messages are printed to stderr then.
+
 [Verbose output]
 
 You can enable verbose output with '--verbose' flag.
@@ -77,6 +102,11 @@ func newRootCmd() *cobra.Command {
 	flags.StringVar(&opts.acl, "acl", opts.acl, "S3 Object ACL to use for charts and indexes. Can be sourced from S3_ACL environment variable.")
 	flags.DurationVar(&opts.timeout, "timeout", opts.timeout, "Timeout for the whole operation to complete.")
 	flags.BoolVar(&opts.verbose, "verbose", opts.verbose, "Enable verbose output.")
+	flags.StringVarP(&opts.output, "output", "o", opts.output, "Output format: text or json. The list command also supports table and yaml.")
+	flags.IntVar(&opts.maxIndexRetries, "max-index-retries", opts.maxIndexRetries, "Maximum number of retries when the index is modified concurrently by another process.")
+	flags.BoolVar(&opts.lock.enabled, "lock", opts.lock.enabled, "Lock the repository while modifying it. Can be sourced from HELM_S3_LOCK environment variable.")
+	flags.DurationVar(&opts.lock.timeout, "lock-timeout", opts.lock.timeout, "How long to wait for the repository lock held by another process.")
+	flags.DurationVar(&opts.lock.ttl, "lock-ttl", opts.lock.ttl, "Lease duration of the repository lock, renewed while the lock is held. A lock with an expired lease is considered stale and can be taken over.")
 
 	cmd.SetFlagErrorFunc(func(command *cobra.Command, err error) error {
 		return newBadUsageError(err)
@@ -90,7 +120,17 @@ func newRootCmd() *cobra.Command {
 		newInitCommand(opts),
 		newPushCommand(opts),
 		newReindexCommand(opts),
+		newIndexCommand(opts),
 		newDeleteCommand(opts),
+		newUndeleteCommand(opts),
+		newTrashCommand(opts),
+		newHistoryCommand(opts),
+		newRestoreCommand(opts),
+		newListCommand(opts),
+		newPruneCommand(opts),
+		newPromoteCommand(opts),
+		newConfigCommand(opts),
+		newLockCommand(),
 		newVersionCommand(),
 	)
 
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/openpgp" //nolint:staticcheck // Helm uses it for provenance.
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/provenance"
+	"helm.sh/helm/v3/pkg/repo"
+
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage/storagetest"
+)
+
+// testRepoName is the name of the repository set up by newTestEnv.
+const testRepoName = "test-repo"
+
+// testEnv is an isolated environment to run plugin commands against
+// the in-memory storage.
+type testEnv struct {
+	t *testing.T
+
+	// store is the storage of the repository.
+	store *storagetest.Memory
+
+	// repoURL is the URL of the repository named testRepoName.
+	repoURL string
+
+	// repoConfig is the path to helm repositories file.
+	repoConfig string
+
+	// dir is a temporary directory for chart files.
+	dir string
+}
+
+// newTestEnv sets up helm v3 environment with an empty in-memory storage.
+// Use init to initialize the repository and add it as testRepoName.
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	home := t.TempDir()
+	store := storagetest.NewMemory(t)
+	repoURL := store.URL() + "/charts"
+
+	repoConfig := filepath.Join(home, "repositories.yaml")
+	t.Setenv("HELM_S3_MODE", "3")
+	t.Setenv("HELM_REPOSITORY_CONFIG", repoConfig)
+	t.Setenv("HELM_REPOSITORY_CACHE", filepath.Join(home, "cache"))
+	require.NoError(t, os.MkdirAll(filepath.Join(home, "cache"), 0o755))
+	helmutil.SetupHelm()
+
+	return &testEnv{
+		t:          t,
+		store:      store,
+		repoURL:    repoURL,
+		repoConfig: repoConfig,
+		dir:        t.TempDir(),
+	}
+}
+
+// run runs the plugin command with args, returning its output and error.
+func (e *testEnv) run(args ...string) (stdout, stderr string, err error) {
+	e.t.Helper()
+	return e.runWithInput(nil, args...)
+}
+
+// runWithInput runs the plugin command with args and stdin, returning its
+// output and error.
+func (e *testEnv) runWithInput(stdin io.Reader, args ...string) (stdout, stderr string, err error) {
+	e.t.Helper()
+
+	var outBuf, errBuf bytes.Buffer
+	cmd := newRootCmd()
+	cmd.SetArgs(args)
+	cmd.SetOut(&outBuf)
+	cmd.SetErr(&errBuf)
+	if stdin != nil {
+		cmd.SetIn(stdin)
+	}
+	err = cmd.ExecuteContext(context.Background())
+	return outBuf.String(), errBuf.String(), err
+}
+
+// mustRun runs the plugin command with args and fails the test on error.
+func (e *testEnv) mustRun(args ...string) string {
+	e.t.Helper()
+
+	stdout, stderr, err := e.run(args...)
+	require.NoError(e.t, err, "stdout: %s\nstderr: %s", stdout, stderr)
+	return stdout + stderr
+}
+
+// init initializes the repository and adds it as testRepoName,
+// like "helm repo add" does.
+func (e *testEnv) init() {
+	e.t.Helper()
+
+	e.mustRun("init", e.repoURL)
+	e.addRepo()
+}
+
+// addRepo adds the repository as testRepoName, like "helm repo add" does.
+func (e *testEnv) addRepo() {
+	e.t.Helper()
+
+	repoFile := repo.NewFile()
+	repoFile.Add(&repo.Entry{Name: testRepoName, URL: e.repoURL})
+	require.NoError(e.t, repoFile.WriteFile(e.repoConfig, 0o644))
+}
+
+// chart packages a chart with the name and version, returning its path.
+func (e *testEnv) chart(name, version string) string {
+	e.t.Helper()
+	return e.chartWithDescription(name, version, "")
+}
+
+// chartWithDescription packages a chart with the name, version and
+// description, returning its path. Charts with the same name and version
+// but different descriptions have different digests.
+func (e *testEnv) chartWithDescription(name, version, description string) string {
+	e.t.Helper()
+
+	path, err := chartutil.Save(&chart.Chart{
+		Metadata: &chart.Metadata{
+			APIVersion:  chart.APIVersionV2,
+			Name:        name,
+			Version:     version,
+			Description: description,
+		},
+	}, e.dir)
+	require.NoError(e.t, err)
+	return path
+}
+
+// index fetches the repository index from the storage.
+func (e *testEnv) index() *repo.IndexFile {
+	e.t.Helper()
+
+	obj, ok := e.store.Get(e.repoURL + "/index.yaml")
+	require.True(e.t, ok, "index does not exist")
+
+	path := filepath.Join(e.t.TempDir(), "index.yaml")
+	require.NoError(e.t, os.WriteFile(path, obj.Data, 0o600))
+	idx, err := repo.LoadIndexFile(path)
+	require.NoError(e.t, err)
+	return idx
+}
+
+// keyring creates a keyring with a new signing key with the name, returning
+// the keyring path.
+func (e *testEnv) keyring(name string) string {
+	e.t.Helper()
+
+	entity, err := openpgp.NewEntity(name, "", "", nil)
+	require.NoError(e.t, err)
+
+	path := filepath.Join(e.t.TempDir(), "secring.gpg")
+	f, err := os.Create(path)
+	require.NoError(e.t, err)
+	defer f.Close()
+	require.NoError(e.t, entity.SerializePrivate(f, nil))
+	return path
+}
+
+// sign signs the chart with the key with the name from the keyring, writing
+// the provenance file next to the chart.
+func (e *testEnv) sign(chartPath, keyring, name string) {
+	e.t.Helper()
+
+	signatory, err := provenance.NewFromKeyring(keyring, name)
+	require.NoError(e.t, err)
+	prov, err := signatory.ClearSign(chartPath)
+	require.NoError(e.t, err)
+	require.NoError(e.t, os.WriteFile(chartPath+".prov", []byte(prov), 0o644))
+}
+
+// fingerprint returns the fingerprint of the first key in the keyring, in
+// upper-case hex.
+func (e *testEnv) fingerprint(keyring string) string {
+	e.t.Helper()
+
+	f, err := os.Open(keyring)
+	require.NoError(e.t, err)
+	defer f.Close()
+	entities, err := openpgp.ReadKeyRing(f)
+	require.NoError(e.t, err)
+	return fmt.Sprintf("%X", entities[0].PrimaryKey.Fingerprint)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+const trashDesc = `This command manages charts deleted with 'helm s3 delete --soft'.
+
+Soft-deleted charts are kept in the '.trash/' directory of the repository until
+they are restored with 'helm s3 undelete' or purged.
+`
+
+const trashListDesc = `This command lists soft-deleted charts in the trash of the repository.
+
+'helm s3 trash list' takes one argument:
+- REPO - target repository.
+`
+
+const trashListExample = `  helm s3 trash list my-repo - lists soft-deleted charts of the repository with name 'my-repo'.`
+
+const trashPurgeDesc = `This command removes soft-deleted charts from the trash for good.
+
+'helm s3 trash purge' takes one argument:
+- REPO - target repository.
+
+Use --older-than to remove only charts deleted earlier than the age ago, e.g.
+'30d' or '36h', or --all to empty the trash.
+`
+
+const trashPurgeExample = `  helm s3 trash purge --older-than 30d my-repo - removes charts deleted more than 30 days ago.
+
+  helm s3 trash purge --all my-repo - empties the trash.`
+
+func newTrashCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "trash",
+		Short: "Manage soft-deleted charts.",
+		Long:  trashDesc,
+		Args:  wrapPositionalArgsBadUsage(cobra.NoArgs),
+	}
+
+	cmd.AddCommand(
+		newTrashListCommand(opts),
+		newTrashPurgeCommand(opts),
+	)
+
+	return cmd
+}
+
+func newTrashListCommand(opts *options) *cobra.Command {
+	act := &trashListAction{
+		printer:  nil,
+		output:   outputText,
+		repoName: "",
+	}
+
+	cmd := &cobra.Command{
+		Use:     "list REPO",
+		Aliases: []string{"ls"},
+		Short:   "List soft-deleted charts.",
+		Long:    trashListDesc,
+		Example: trashListExample,
+		Args:    wrapPositionalArgsBadUsage(cobra.ExactArgs(1)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// No completions for the REPO argument.
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			act.printer = cmd
+			act.output = opts.output
+			act.repoName = args[0]
+			return act.run(cmd.Context())
+		},
+	}
+
+	return cmd
+}
+
+type trashListAction struct {
+	printer printer
+
+	// global flags
+
+	output string
+
+	// args
+
+	repoName string
+}
+
+func (act *trashListAction) run(ctx context.Context) error {
+	if err := validateOutput(act.output, outputText, outputTable, outputJSON, outputYAML); err != nil {
+		return err
+	}
+
+	repoEntry, err := helmutil.LookupRepoEntry(act.repoName)
+	if err != nil {
+		return err
+	}
+
+	store, err := storage.New(repoEntry.URL())
+	if err != nil {
+		return err
+	}
+
+	trash, _, err := fetchTrash(ctx, store, repoEntry.URL())
+	if err != nil {
+		return err
+	}
+
+	entries := trash.Entries
+	if entries == nil {
+		entries = make([]trashEntry, 0)
+	}
+	if act.output == outputJSON || act.output == outputYAML {
+		// The index entries are internal, so they are not printed.
+		listed := make([]trashEntry, len(entries))
+		for i, entry := range entries {
+			entry.IndexEntry = nil
+			listed[i] = entry
+		}
+		return printStructured(act.printer, act.output, listed)
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tDELETED\tDIGEST")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", entry.Name, entry.Version, entry.DeletedAt.UTC().Format(time.RFC3339), entry.Digest)
+	}
+	_ = w.Flush()
+
+	act.printer.Printf("%s", b.String())
+	return nil
+}
+
+func newTrashPurgeCommand(opts *options) *cobra.Command {
+	act := &trashPurgeAction{
+		printer:         nil,
+		result:          &commandResult{Command: "trash purge"},
+		acl:             "",
+		maxIndexRetries: 0,
+		lock:            lockOptions{},
+		repoName:        "",
+		olderThan:       "",
+		all:             false,
+	}
+
+	cmd := &cobra.Command{
+		Use:     "purge REPO",
+		Short:   "Remove soft-deleted charts for good.",
+		Long:    trashPurgeDesc,
+		Example: trashPurgeExample,
+		Args:    wrapPositionalArgsBadUsage(cobra.ExactArgs(1)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// No completions for the REPO argument.
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(opts.output, outputText, outputJSON); err != nil {
+				return err
+			}
+			act.printer = newPrinter(cmd, opts.output)
+			act.acl = opts.acl
+			act.maxIndexRetries = opts.maxIndexRetries
+			act.lock = opts.lock
+			act.repoName = args[0]
+			act.result.Repo = act.repoName
+			return reportResult(cmd, opts.output, act.result, act.run(cmd.Context()))
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&act.olderThan, "older-than", act.olderThan, "Remove only charts deleted earlier than the age ago, e.g. 30d.")
+	flags.BoolVar(&act.all, "all", act.all, "Remove all charts from the trash.")
+
+	return cmd
+}
+
+type trashPurgeAction struct {
+	printer printer
+	result  *commandResult
+
+	// global flags
+
+	acl             string
+	maxIndexRetries int
+	lock            lockOptions
+
+	// args
+
+	repoName string
+
+	// flags
+
+	olderThan string
+	all       bool
+}
+
+func (act *trashPurgeAction) run(ctx context.Context) error {
+	if (act.olderThan == "") == !act.all {
+		return newBadUsageError(errors.New("exactly one of --older-than and --all flags is required"))
+	}
+
+	cutoff := time.Now()
+	if act.olderThan != "" {
+		age, err := parseAge(act.olderThan)
+		if err != nil {
+			return newBadUsageError(fmt.Errorf("invalid --older-than value %q: %v", act.olderThan, err))
+		}
+		cutoff = cutoff.Add(-age)
+	}
+
+	repoEntry, err := helmutil.LookupRepoEntry(act.repoName)
+	if err != nil {
+		return err
+	}
+
+	act.result.RepoURL = repoEntry.URL()
+
+	store, err := storage.New(repoEntry.URL())
+	if err != nil {
+		return err
+	}
+
+	ctx, unlock, err := lockRepo(ctx, store, repoEntry.URL(), act.acl, act.lock, act.printer)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Entries are removed from the trash index first; a failure to delete
+	// the chart files afterwards leaves orphans in the trash, not entries
+	// that cannot be restored.
+	var purged []trashEntry
+	err = updateTrash(ctx, store, repoEntry.URL(), act.acl, act.maxIndexRetries, func(trash *trashIndex) error {
+		purged = nil
+		kept := trash.Entries[:0]
+		for _, entry := range trash.Entries {
+			if act.all || entry.DeletedAt.Before(cutoff) {
+				purged = append(purged, entry)
+				continue
+			}
+			kept = append(kept, entry)
+		}
+		trash.Entries = kept
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	act.result.Charts = make([]chartResult, 0, len(purged))
+	for _, entry := range purged {
+		act.result.Charts = append(act.result.Charts, chartResult{
+			Name:    entry.Name,
+			Version: entry.Version,
+			URL:     entry.TrashURL,
+			Digest:  entry.Digest,
+		})
+	}
+	if len(purged) == 0 {
+		act.printer.Printf("Nothing to purge.\n")
+		return nil
+	}
+
+	for _, entry := range purged {
+		if entry.TrashURL == "" {
+			continue
+		}
+		if err := store.DeleteChart(ctx, entry.TrashURL); err != nil {
+			return errors.WithMessage(err, "delete chart file from the trash")
+		}
+	}
+
+	act.printer.Printf("Successfully purged %d chart versions from the trash.\n", len(purged))
+	return nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+const undeleteDesc = `This command restores a chart version deleted with 'helm s3 delete --soft'.
+
+'helm s3 undelete' takes two arguments:
+- NAME - name of the chart to restore,
+- REPO - target repository.
+
+The chart files are moved from the trash back to their original location, and
+the original index entry is added back to the index, with its original
+creation time and digest. If the chart version was soft-deleted several times,
+the most recently deleted one is restored.
+
+The command fails if the chart version exists in the repository.
+`
+
+const undeleteExample = `  helm s3 undelete epicservice --version 0.5.1 my-repo - restores version 0.5.1 of epicservice.`
+
+func newUndeleteCommand(opts *options) *cobra.Command {
+	act := &undeleteAction{
+		printer:         nil,
+		result:          &commandResult{Command: "undelete"},
+		acl:             "",
+		maxIndexRetries: 0,
+		lock:            lockOptions{},
+		chartName:       "",
+		repoName:        "",
+		version:         "",
+	}
+
+	cmd := &cobra.Command{
+		Use:     "undelete NAME REPO",
+		Short:   "Restore a soft-deleted chart to the repository.",
+		Long:    undeleteDesc,
+		Example: undeleteExample,
+		Args:    wrapPositionalArgsBadUsage(cobra.ExactArgs(2)),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// No completions for the NAME and REPO arguments.
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateOutput(opts.output, outputText, outputJSON); err != nil {
+				return err
+			}
+			act.printer = newPrinter(cmd, opts.output)
+			act.acl = opts.acl
+			act.maxIndexRetries = opts.maxIndexRetries
+			act.lock = opts.lock
+			act.chartName = args[0]
+			act.repoName = args[1]
+			act.result.Repo = act.repoName
+			return reportResult(cmd, opts.output, act.result, act.run(cmd.Context()))
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&act.version, "version", act.version, "Version of the chart to restore.")
+	_ = cobra.MarkFlagRequired(flags, "version")
+
+	return cmd
+}
+
+// undeleteRollbackTimeout is the timeout for removing the restored chart file
+// when the index update fails.
+const undeleteRollbackTimeout = 30 * time.Second
+
+type undeleteAction struct {
+	printer printer
+	result  *commandResult
+
+	// global flags
+
+	acl             string
+	maxIndexRetries int
+	lock            lockOptions
+
+	// args
+
+	chartName string
+	repoName  string
+
+	// flags
+
+	version string
+}
+
+func (act *undeleteAction) run(ctx context.Context) error {
+	repoEntry, err := helmutil.LookupRepoEntry(act.repoName)
+	if err != nil {
+		return err
+	}
+
+	act.result.RepoURL = repoEntry.URL()
+
+	store, err := storage.New(repoEntry.URL())
+	if err != nil {
+		return err
+	}
+
+	ctx, unlock, err := lockRepo(ctx, store, repoEntry.URL(), act.acl, act.lock, act.printer)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	trash, _, err := fetchTrash(ctx, store, repoEntry.URL())
+	if err != nil {
+		return err
+	}
+	i := trash.find(act.chartName, act.version)
+	if i == -1 {
+		return withErrorCode(errorCodeChartNotFound, fmt.Errorf("chart %s version %s not found in the trash of the repository %s", act.chartName, act.version, act.repoName))
+	}
+	entry := trash.Entries[i]
+
+	act.result.Charts = []chartResult{{
+		Name:    entry.Name,
+		Version: entry.Version,
+		URL:     entry.URL,
+		Digest:  entry.Digest,
+	}}
+
+	// Check the index before restoring the chart file, so that the file is not
+	// left behind if the version is already back in the index, e.g. under
+	// another URL. The check is repeated on the index update.
+	idx, _, err := fetchIndex(ctx, store, repoEntry)
+	if err != nil {
+		return err
+	}
+	if idx.Has(entry.Name, entry.Version) {
+		return withErrorCode(errorCodeChartExists, fmt.Errorf("chart %s version %s already exists in the repository", entry.Name, entry.Version))
+	}
+
+	if entry.URL != "" {
+		exists, err := store.Exists(ctx, entry.URL)
+		if err != nil {
+			return errors.WithMessage(err, "check if chart file exists")
+		}
+		if exists {
+			return withErrorCode(errorCodeChartExists, fmt.Errorf("chart file %s already exists, delete it to restore the chart", entry.URL))
+		}
+
+		if err := store.CopyChart(ctx, entry.TrashURL, entry.URL, act.acl); err != nil {
+			if errors.Is(err, storage.ErrObjectNotFound) {
+				return fmt.Errorf("chart file %s not found in the trash", entry.TrashURL)
+			}
+			return errors.WithMessage(err, "restore chart file from the trash")
+		}
+	}
+
+	idx, err = updateIndex(ctx, store, repoEntry, act.acl, act.maxIndexRetries, act.printer, func(idx helmutil.Index) error {
+		if idx.Has(entry.Name, entry.Version) {
+			return withErrorCode(errorCodeChartExists, fmt.Errorf("chart %s version %s already exists in the repository", entry.Name, entry.Version))
+		}
+		if err := idx.AddMarshaledEntry(entry.IndexEntry); err != nil {
+			return err
+		}
+		idx.SortEntries()
+		return nil
+	})
+	if err != nil {
+		if entry.URL != "" {
+			act.removeRestored(ctx, store, entry.URL)
+		}
+		return err
+	}
+
+	if err := idx.WriteFile(repoEntry.CacheFile(), helmutil.DefaultIndexFilePerm); err != nil {
+		return errors.WithMessage(err, "update local index")
+	}
+
+	err = updateTrash(ctx, store, repoEntry.URL(), act.acl, act.maxIndexRetries, func(trash *trashIndex) error {
+		trash.remove(entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if entry.TrashURL != "" {
+		if err := store.DeleteChart(ctx, entry.TrashURL); err != nil {
+			return errors.WithMessage(err, "delete chart file from the trash")
+		}
+	}
+
+	act.printer.Printf("Successfully restored the chart to the repository.\n")
+	return nil
+}
+
+// removeRestored removes the chart file restored from the trash when the index
+// update fails. The copy in the trash is kept.
+func (act *undeleteAction) removeRestored(ctx context.Context, store storage.Storage, url string) {
+	// Clean up even if the operation context is canceled.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), undeleteRollbackTimeout)
+	defer cancel()
+
+	if err := store.DeleteChart(ctx, url); err != nil {
+		act.printer.PrintErrf("[WARNING] failed to remove restored chart file %s: %s\n", url, err)
+	}
+}
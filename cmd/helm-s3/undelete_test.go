@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hypnoglow/helm-s3/internal/storage/storagetest"
+)
+
+func TestUndelete(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	keyring := env.keyring("Test Signer")
+	chartPath := env.chart("foo", "1.0.0")
+	env.sign(chartPath, keyring, "Test Signer")
+	env.mustRun("push", chartPath, testRepoName)
+	env.mustRun("push", env.chart("foo", "1.1.0"), testRepoName)
+	original := env.index().Entries["foo"]
+
+	out := env.mustRun("delete", "foo", "--version", "1.0.0", "--soft", testRepoName)
+	assert.Contains(t, out, "The chart files were moved to the trash")
+
+	require.Len(t, env.index().Entries["foo"], 1)
+	_, ok := env.store.Get(env.repoURL + "/foo-1.0.0.tgz")
+	assert.False(t, ok)
+
+	var trashed []string
+	for _, key := range env.store.Keys() {
+		if i := strings.Index(key, ".trash/"); i != -1 {
+			trashed = append(trashed, key[i:])
+		}
+	}
+	require.Len(t, trashed, 3, "chart, provenance and trash index")
+	assert.Contains(t, trashed, ".trash/index.json")
+
+	t.Run("should list the trash", func(t *testing.T) {
+		out := env.mustRun("trash", "list", testRepoName)
+		assert.Contains(t, out, "NAME")
+		assert.Contains(t, out, "foo   1.0.0")
+	})
+
+	out = env.mustRun("undelete", "foo", "--version", "1.0.0", testRepoName)
+	assert.Contains(t, out, "Successfully restored the chart to the repository.")
+
+	assert.Equal(t, original, env.index().Entries["foo"], "the original index entry must be restored")
+	_, ok = env.store.Get(env.repoURL + "/foo-1.0.0.tgz")
+	assert.True(t, ok)
+	_, ok = env.store.Get(env.repoURL + "/foo-1.0.0.tgz.prov")
+	assert.True(t, ok)
+
+	t.Run("should empty the trash", func(t *testing.T) {
+		stdout, _, err := env.run("trash", "list", "-o", "json", testRepoName)
+		require.NoError(t, err)
+		var entries []trashEntry
+		require.NoError(t, json.Unmarshal([]byte(stdout), &entries))
+		assert.Empty(t, entries)
+	})
+
+	t.Run("should fail if the chart is not in the trash", func(t *testing.T) {
+		_, _, err := env.run("undelete", "foo", "--version", "1.0.0", testRepoName)
+		require.ErrorContains(t, err, "chart foo version 1.0.0 not found in the trash")
+		assert.Equal(t, errorCodeChartNotFound, errorCode(err))
+	})
+}
+
+func TestDelete_Soft_SameChartTwice(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	for range 2 {
+		env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+		env.mustRun("delete", "foo", "--version", "1.0.0", "--soft", testRepoName)
+	}
+
+	stdout, _, err := env.run("trash", "list", "-o", "json", testRepoName)
+	require.NoError(t, err)
+	var entries []trashEntry
+	require.NoError(t, json.Unmarshal([]byte(stdout), &entries))
+	require.Len(t, entries, 2)
+	assert.NotEqual(t, entries[0].TrashURL, entries[1].TrashURL, "the trashed copies must not overwrite each other")
+	for _, entry := range entries {
+		_, ok := env.store.Get(entry.TrashURL)
+		assert.True(t, ok)
+	}
+}
+
+func TestUndelete_ChartExists(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+	env.mustRun("delete", "foo", "--version", "1.0.0", "--soft", testRepoName)
+	env.mustRun("push", env.chartWithDescription("foo", "1.0.0", "pushed again"), testRepoName)
+
+	_, _, err := env.run("undelete", "foo", "--version", "1.0.0", testRepoName)
+	require.ErrorContains(t, err, "already exists")
+	assert.Equal(t, errorCodeChartExists, errorCode(err))
+}
+
+func TestUndelete_ChartExistsUnderAnotherURL(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+	env.mustRun("delete", "foo", "--version", "1.0.0", "--soft", testRepoName)
+	env.mustRun("config", "--layout", "nested", testRepoName)
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+
+	_, _, err := env.run("undelete", "foo", "--version", "1.0.0", testRepoName)
+	require.ErrorContains(t, err, "chart foo version 1.0.0 already exists in the repository")
+	assert.Equal(t, errorCodeChartExists, errorCode(err))
+	_, ok := env.store.Get(env.repoURL + "/foo-1.0.0.tgz")
+	assert.False(t, ok, "the chart file must not be restored")
+}
+
+func TestUndelete_IndexFailure(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+	env.mustRun("delete", "foo", "--version", "1.0.0", "--soft", testRepoName)
+
+	env.store.FailOn(storagetest.OpPutIndex, errors.New("access denied"))
+
+	_, _, err := env.run("undelete", "foo", "--version", "1.0.0", testRepoName)
+	require.ErrorContains(t, err, "access denied")
+
+	_, ok := env.store.Get(env.repoURL + "/foo-1.0.0.tgz")
+	assert.False(t, ok, "the restored chart file must be removed")
+
+	stdout, _, err := env.run("trash", "list", "-o", "json", testRepoName)
+	require.NoError(t, err)
+	var entries []trashEntry
+	require.NoError(t, json.Unmarshal([]byte(stdout), &entries))
+	require.Len(t, entries, 1, "the chart must stay in the trash")
+	_, ok = env.store.Get(entries[0].TrashURL)
+	assert.True(t, ok)
+}
+
+func TestTrashPurge(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	env.mustRun("push", env.chart("foo", "1.0.0"), env.chart("foo", "1.1.0"), testRepoName)
+	env.mustRun("delete", "foo", "--all", "--yes", "--soft", testRepoName)
+
+	out := env.mustRun("trash", "purge", "--older-than", "1d", testRepoName)
+	assert.Contains(t, out, "Nothing to purge.")
+
+	out = env.mustRun("trash", "purge", "--all", testRepoName)
+	assert.Contains(t, out, "Successfully purged 2 chart versions from the trash.")
+
+	for _, key := range env.store.Keys() {
+		assert.NotContains(t, key, ".tgz", "chart files must be removed from the trash")
+	}
+
+	_, _, err := env.run("undelete", "foo", "--version", "1.0.0", testRepoName)
+	assert.Equal(t, errorCodeChartNotFound, errorCode(err))
+
+	t.Run("should require exactly one selector", func(t *testing.T) {
+		_, _, err := env.run("trash", "purge", testRepoName)
+		require.ErrorContains(t, err, "exactly one of --older-than and --all flags is required")
+		assert.Equal(t, errorCodeBadUsage, errorCode(err))
+	})
+}
+
+func TestDelete_Soft_TrashFailure(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+
+	env.store.FailOn(storagetest.OpCopyChart, errors.New("access denied"))
+
+	_, _, err := env.run("delete", "foo", "--version", "1.0.0", "--soft", testRepoName)
+	require.ErrorContains(t, err, "access denied")
+
+	// The chart is not in the trash, so it must stay in the index.
+	assert.Len(t, env.index().Entries["foo"], 1)
+	_, ok := env.store.Get(env.repoURL + "/foo-1.0.0.tgz")
+	assert.True(t, ok)
+}
+
+func TestDelete_Soft_IndexFailure(t *testing.T) {
+	env := newTestEnv(t)
+	env.init()
+	env.mustRun("push", env.chart("foo", "1.0.0"), testRepoName)
+
+	env.store.FailOn(storagetest.OpPutIndex, errors.New("access denied"))
+
+	_, _, err := env.run("delete", "foo", "--version", "1.0.0", "--soft", testRepoName)
+	require.ErrorContains(t, err, "access denied")
+
+	// The chart is still in the index, so it must not stay in the trash.
+	assert.Len(t, env.index().Entries["foo"], 1)
+	stdout, _, err := env.run("trash", "list", "-o", "json", testRepoName)
+	require.NoError(t, err)
+	var entries []trashEntry
+	require.NoError(t, json.Unmarshal([]byte(stdout), &entries))
+	assert.Empty(t, entries)
+	for _, key := range env.store.Keys() {
+		if strings.Contains(key, ".trash/") {
+			assert.NotContains(t, key, ".tgz", "chart copies must be removed from the trash")
+		}
+	}
+}
@@ -1,12 +1,30 @@
 package main
 
-import "github.com/hypnoglow/helm-s3/internal/awsutil"
+import (
+	"context"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/hypnoglow/helm-s3/internal/awsutil"
+)
 
 type printer interface {
 	Printf(format string, v ...interface{})
 	PrintErrf(format string, i ...interface{})
 }
 
+// printerWriter is an io.Writer that prints to the printer. It is used to pass
+// the printer to helm libraries.
+type printerWriter struct {
+	p printer
+}
+
+func (w printerWriter) Write(b []byte) (int, error) {
+	w.p.Printf("%s", b)
+	return len(b), nil
+}
+
 // escapeIfRelative escapes chart filename if it is indexed as relative.
 //
 // Note: we escape filename only if 'relative' is set for a few reasons:
@@ -18,10 +36,81 @@ type printer interface {
 //     with the "s3://example-bucket" baseURL will become
 //     "s3://example-bucket/petstore-1.0.0%252B102.tgz".
 //     So if we ever decide to escape, we need to fix this.
+//
+// Filenames of charts in nested directories are escaped segment by segment,
+// so that the directory separators are kept.
 func escapeIfRelative(filename string, relative bool) string {
 	if !relative {
 		return filename
 	}
 
-	return awsutil.EscapePath(filename)
+	segments := strings.Split(filename, "/")
+	for i, segment := range segments {
+		segments[i] = awsutil.EscapePath(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// indexLocation returns the filename and the base URL to add the chart with
+// the key relative to the repository root to the index.
+//
+// Index.Add and Index.AddOrReplace join the base URL with the base name of
+// the filename only, so for charts in nested directories the directory is
+// moved to the base URL. For relative URLs the base URL is empty, and the
+// filename is the whole (escaped) key.
+func indexLocation(repoURL, key string, relative bool) (filename, baseURL string) {
+	if relative {
+		return escapeIfRelative(key, true), ""
+	}
+
+	baseURL = strings.TrimSuffix(repoURL, "/")
+	if dir := path.Dir(key); dir != "." {
+		baseURL += "/" + dir
+	}
+	return path.Base(key), baseURL
+}
+
+// forEachConcurrently calls fn for each index in [0, n), making up to
+// concurrency calls at once. The first error cancels the context passed to
+// the calls and is returned once all started calls are finished.
+func forEachConcurrently(ctx context.Context, n, concurrency int, fn func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	sem := make(chan struct{}, concurrency)
+	for i := 0; i < n; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if err := fn(ctx, i); err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
 }
@@ -2,8 +2,11 @@ package main
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/spf13/cobra"
+
+	"github.com/hypnoglow/helm-s3/internal/storage"
 )
 
 type errorType int
@@ -49,6 +52,74 @@ func newSilentError() error {
 	}
 }
 
+// newSilentErrorf returns a silent error with the message. The message is not
+// printed, because the command has already printed the details, but it is
+// reported in the structured output.
+func newSilentErrorf(format string, v ...interface{}) error {
+	return customError{
+		errType: errorTypeSilent,
+		err:     fmt.Errorf(format, v...),
+	}
+}
+
+// Error codes reported in the structured output.
+const (
+	errorCodeUnknown            = "error"
+	errorCodeBadUsage           = "bad_usage"
+	errorCodeChartExists        = "chart_exists"
+	errorCodeRepoExists         = "repo_exists"
+	errorCodeChartNotFound      = "chart_not_found"
+	errorCodeNotFound           = "not_found"
+	errorCodeIndexConflict      = "index_conflict"
+	errorCodeLocked             = "locked"
+	errorCodeVerificationFailed = "verification_failed"
+	errorCodePolicyViolation    = "policy_violation"
+	errorCodeImmutable          = "immutable"
+	errorCodeDigestMismatch     = "digest_mismatch"
+	errorCodeVersioningDisabled = "versioning_disabled"
+)
+
+// codedError is an error with a code reported in the structured output.
+type codedError struct {
+	code string
+	err  error
+}
+
+func (c codedError) Error() string {
+	return c.err.Error()
+}
+
+func (c codedError) Unwrap() error {
+	return c.err
+}
+
+// withErrorCode annotates the error with the code.
+func withErrorCode(code string, err error) error {
+	return codedError{
+		code: code,
+		err:  err,
+	}
+}
+
+// errorCode returns the code of the error.
+func errorCode(err error) string {
+	var coded codedError
+	switch {
+	case errors.As(err, &coded):
+		return coded.code
+	case errorTypeBadUsage.Is(err):
+		return errorCodeBadUsage
+	case errors.Is(err, storage.ErrPreconditionFailed):
+		return errorCodeIndexConflict
+	case errors.Is(err, storage.ErrObjectNotFound):
+		return errorCodeNotFound
+	case errors.Is(err, storage.ErrVersioningDisabled):
+		return errorCodeVersioningDisabled
+	default:
+		return errorCodeUnknown
+	}
+}
+
 func wrapPositionalArgsBadUsage(f cobra.PositionalArgs) cobra.PositionalArgs {
 	return func(cmd *cobra.Command, args []string) error {
 		err := f(cmd, args)
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+// indexRetryBaseDelay is the base delay between attempts to update the index
+// when it was modified concurrently.
+const indexRetryBaseDelay = 100 * time.Millisecond
+
+// fetchIndex fetches the repository index and returns it along with its ETag.
+func fetchIndex(ctx context.Context, store storage.Storage, repoEntry helmutil.RepoEntry) (helmutil.Index, string, error) {
+	b, etag, err := store.FetchRaw(ctx, repoEntry.IndexURL())
+	if err != nil {
+		return nil, "", errors.WithMessage(err, "fetch current repo index")
+	}
+
+	idx := helmutil.NewIndex()
+	if err := idx.UnmarshalBinary(b); err != nil {
+		return nil, "", errors.WithMessage(err, "load index from downloaded file")
+	}
+
+	return idx, etag, nil
+}
+
+// updateIndex fetches the repository index, applies the change to it and
+// uploads it back.
+//
+// The upload is conditional on the index not being modified since it was
+// fetched, so concurrent updates do not silently overwrite each other.
+// See https://github.com/hypnoglow/helm-s3/issues/18 for more info.
+// If the index was modified concurrently, the whole cycle is repeated,
+// up to maxRetries times. Thus, apply must be safe to call multiple times,
+// each time with a freshly fetched index.
+func updateIndex(
+	ctx context.Context,
+	store storage.Storage,
+	repoEntry helmutil.RepoEntry,
+	acl string,
+	maxRetries int,
+	p printer,
+	apply func(idx helmutil.Index) error,
+) (helmutil.Index, error) {
+	settings, err := fetchRepoSettings(ctx, store, repoEntry.URL())
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		idx, etag, err := fetchIndex(ctx, store, repoEntry)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := apply(idx); err != nil {
+			return nil, err
+		}
+		idx.UpdateGeneratedTime()
+
+		idxReader, err := idx.Reader()
+		if err != nil {
+			return nil, errors.WithMessage(err, "get index reader")
+		}
+
+		err = putIndex(ctx, store, repoEntry.URL(), acl, idxReader, storage.Precondition{IfMatch: etag}, settings.IndexGenerations, p)
+		if err == nil {
+			return idx, nil
+		}
+		if !errors.Is(err, storage.ErrPreconditionFailed) {
+			return nil, errors.WithMessage(err, "upload index to s3")
+		}
+		if attempt >= maxRetries {
+			return nil, withErrorCode(errorCodeIndexConflict, errors.Errorf("index was modified concurrently, gave up after %d retries", maxRetries))
+		}
+
+		if err := sleepBeforeRetry(ctx, attempt); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// putIndex uploads the index of the repository, keeping up to keep previous
+// generations of the index if the storage supports it, see
+// repoSettings.IndexGenerations.
+//
+// Once the index is uploaded, failing to keep the previous generations does
+// not fail the update: the index already reflects the change, so the error
+// is printed as a warning.
+func putIndex(ctx context.Context, store storage.Storage, repoURL, acl string, r io.Reader, cond storage.Precondition, keep int, p printer) error {
+	history, ok := store.(storage.IndexHistory)
+	if !ok || keep < 1 {
+		return store.PutIndex(ctx, repoURL, acl, r, cond)
+	}
+
+	err := history.PutIndexKeeping(ctx, repoURL, acl, r, cond, keep)
+	if errors.Is(err, storage.ErrIndexGenerationsNotKept) {
+		p.PrintErrf("[WARNING] %s\n", err)
+		return nil
+	}
+	return err
+}
+
+// sleepBeforeRetry waits before the next attempt to update the index.
+// The delay grows linearly with the attempt number and is randomized, so that
+// concurrent writers do not collide again.
+func sleepBeforeRetry(ctx context.Context, attempt int) error {
+	delay := time.Duration(attempt+1) * indexRetryBaseDelay
+	delay += rand.N(indexRetryBaseDelay) //nolint:gosec // Jitter does not require a secure random source.
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// printIndexDiff prints the chart versions added, removed and changed in the
+// index.
+func printIndexDiff(p printer, diff helmutil.IndexDiff) {
+	if len(diff.Added) > 0 {
+		p.Printf("\nAdded versions:\n")
+		for _, entry := range diff.Added {
+			p.Printf("  + %s %s\n", entry.Name, entry.Version)
+		}
+	}
+
+	if len(diff.Removed) > 0 {
+		p.Printf("\nRemoved versions:\n")
+		for _, entry := range diff.Removed {
+			p.Printf("  - %s %s\n", entry.Name, entry.Version)
+		}
+	}
+
+	if len(diff.Changed) > 0 {
+		p.Printf("\nChanged versions:\n")
+		for _, change := range diff.Changed {
+			p.Printf("  ~ %s %s\n", change.New.Name, change.New.Version)
+			if change.DigestChanged() {
+				p.Printf("      digest: %s -> %s\n", change.Old.Digest, change.New.Digest)
+			}
+			if change.URLsChanged() {
+				p.Printf("      urls: %s -> %s\n", strings.Join(change.Old.URLs, ", "), strings.Join(change.New.URLs, ", "))
+			}
+		}
+	}
+}
+
+// absoluteChartURL returns the URL of the chart object from the index entry
+// URL. For relative URLs the repository URL is prepended.
+func absoluteChartURL(repoURL, url string) string {
+	if strings.HasPrefix(url, repoURL) {
+		return url
+	}
+	return strings.TrimSuffix(repoURL, "/") + "/" + url
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+// lockPollInterval is the interval between attempts to acquire the repository
+// lock held by another process.
+const lockPollInterval = time.Second
+
+// lockReleaseTimeout is the timeout for releasing the repository lock.
+const lockReleaseTimeout = 30 * time.Second
+
+// errLockLost signals that the lease of the repository lock could not be
+// renewed, so the operation holding the lock was aborted.
+var errLockLost = errors.New("the repository lock lease could not be renewed")
+
+// lockRepo acquires the repository lock if locking is enabled, waiting up to
+// the lock timeout for the lock held by another process to be released.
+//
+// While the lock is held, its lease is renewed every third of the lock TTL,
+// so that operations taking longer than the TTL do not lose the lock to
+// another process. If the lease cannot be renewed, the returned context is
+// canceled to abort the operation, so the operation must use it instead of
+// ctx.
+//
+// It also returns a function that releases the lock; the function must be
+// called when the repository modification is complete. If locking is
+// disabled, ctx is returned as is, and the function is a no-op.
+func lockRepo(
+	ctx context.Context,
+	store storage.Storage,
+	repoURL, acl string,
+	opts lockOptions,
+	p printer,
+) (lockCtx context.Context, unlock func(), err error) {
+	if !opts.enabled {
+		return ctx, func() {}, nil
+	}
+	if opts.ttl <= 0 {
+		return nil, nil, newBadUsageError(errors.New("--lock-ttl must be positive"))
+	}
+
+	locker, err := repoLocker(store)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lock := storage.NewLock(opts.ttl)
+	deadline := time.Now().Add(opts.timeout)
+	for {
+		err := locker.TryLock(ctx, repoURL, acl, lock)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, storage.ErrLockHeld) {
+			return nil, nil, errors.WithMessage(err, "acquire repository lock")
+		}
+		if time.Now().After(deadline) {
+			return nil, nil, withErrorCode(errorCodeLocked, lockHeldError(ctx, locker, repoURL))
+		}
+
+		t := time.NewTimer(lockPollInterval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+
+	lockCtx, abort := context.WithCancelCause(ctx)
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		renewLock(lockCtx, abort, locker, repoURL, acl, lock, opts.ttl)
+	}()
+
+	return lockCtx, func() {
+		abort(nil)
+		<-renewed
+		if cause := context.Cause(lockCtx); errors.Is(cause, errLockLost) {
+			p.PrintErrf("[ERROR] The operation was aborted: %s.\n", cause)
+		}
+
+		// Release the lock even if the operation context is canceled.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), lockReleaseTimeout)
+		defer cancel()
+
+		err := locker.Unlock(ctx, repoURL, lock)
+		if errors.Is(err, storage.ErrLockHeld) {
+			p.PrintErrf("[WARNING] The repository lock lease expired and the lock was taken over by another process.\n")
+			return
+		}
+		if err != nil {
+			p.PrintErrf("[WARNING] Failed to release the repository lock: %s\n", err)
+		}
+	}, nil
+}
+
+// renewLock renews the lease of the lock every third of ttl until ctx is
+// done. If the lock was taken over, or the lease is about to expire because
+// renewals keep failing, it calls abort with errLockLost.
+func renewLock(ctx context.Context, abort context.CancelCauseFunc, locker storage.Locker, repoURL, acl string, lock storage.Lock, ttl time.Duration) {
+	interval := max(ttl/3, time.Millisecond)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		renewed := lock.Renewed(ttl)
+		err := locker.RenewLock(ctx, repoURL, acl, renewed)
+		switch {
+		case err == nil:
+			lock = renewed
+		case ctx.Err() != nil:
+			return
+		case errors.Is(err, storage.ErrLockHeld) || !time.Now().Add(interval).Before(lock.ExpiresAt):
+			// A failed renewal is retried on the next tick only if the lease
+			// outlives it.
+			abort(fmt.Errorf("%w: %w", errLockLost, err))
+			return
+		}
+	}
+}
+
+// lockHeldError returns an error describing the current lock holder.
+func lockHeldError(ctx context.Context, locker storage.Locker, repoURL string) error {
+	lock, err := locker.LockStatus(ctx, repoURL)
+	if err != nil {
+		return errors.New("timed out waiting for the repository lock")
+	}
+
+	return errors.Errorf(
+		"timed out waiting for the repository lock held by %s (pid %d) since %s, expires at %s",
+		lock.Hostname,
+		lock.PID,
+		lock.AcquiredAt.Format(time.RFC3339),
+		lock.ExpiresAt.Format(time.RFC3339),
+	)
+}
+
+// repoLocker returns the storage as storage.Locker, or an error if the storage
+// does not support the repository lock.
+func repoLocker(store storage.Storage) (storage.Locker, error) {
+	locker, ok := store.(storage.Locker)
+	if !ok {
+		return nil, errors.New("the repository storage does not support locking")
+	}
+	return locker, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+// Output formats.
+const (
+	outputText  = "text"
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// validateOutput returns an error if the output format is not one of formats.
+func validateOutput(output string, formats ...string) error {
+	for _, format := range formats {
+		if output == format {
+			return nil
+		}
+	}
+	return newBadUsageError(fmt.Errorf("unknown output format %q, supported formats: %v", output, formats))
+}
+
+// printStructured prints v in the structured output format: JSON or YAML.
+func printStructured(p printer, output string, v interface{}) error {
+	var (
+		b   []byte
+		err error
+	)
+	switch output {
+	case outputJSON:
+		b, err = json.MarshalIndent(v, "", "  ")
+		b = append(b, '\n')
+	case outputYAML:
+		b, err = yaml.Marshal(v)
+	default:
+		return fmt.Errorf("unsupported structured output format %q", output)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "marshal %s output", output)
+	}
+
+	p.Printf("%s", b)
+	return nil
+}
+
+// newPrinter returns the printer for human-readable messages of the command.
+// With structured output, the messages are printed to stderr, so that stdout
+// contains only the result.
+func newPrinter(cmd *cobra.Command, output string) printer {
+	if output == outputJSON {
+		return stderrPrinter{cmd}
+	}
+	return cmd
+}
+
+// stderrPrinter prints all messages to stderr.
+type stderrPrinter struct {
+	printer
+}
+
+func (p stderrPrinter) Printf(format string, v ...interface{}) {
+	p.PrintErrf(format, v...)
+}
+
+// commandResult is the result of a command that modifies the repository,
+// printed with --output json.
+type commandResult struct {
+	Command string `json:"command"`
+
+	// Repo is the name of the repository, or its URI for init.
+	Repo    string `json:"repo"`
+	RepoURL string `json:"repoURL,omitempty"`
+
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// Skipped is true if the command did nothing, e.g. because the
+	// repository already exists and --ignore-if-exists is set.
+	Skipped bool `json:"skipped,omitempty"`
+
+	Charts   []chartResult   `json:"charts,omitempty"`
+	Reindex  *reindexResult  `json:"reindex,omitempty"`
+	Rollback *rollbackResult `json:"rollback,omitempty"`
+
+	Error *resultError `json:"error,omitempty"`
+}
+
+// chartResult describes a chart version affected by the command.
+type chartResult struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+
+	// URL is the URL of the chart object.
+	URL    string `json:"url,omitempty"`
+	Digest string `json:"digest,omitempty"`
+
+	// Skipped is true if the chart was not pushed, because it already exists
+	// and --ignore-if-exists is set.
+	Skipped bool `json:"skipped"`
+}
+
+// reindexResult summarizes changes made by reindex to the index.
+type reindexResult struct {
+	Charts     int `json:"charts"`
+	Added      int `json:"added"`
+	Removed    int `json:"removed"`
+	Changed    int `json:"changed"`
+	Downloaded int `json:"downloaded"`
+}
+
+// rollbackResult summarizes changes made by index rollback to the index.
+type rollbackResult struct {
+	// Generation is the ID of the index generation rolled back to.
+	Generation string `json:"generation"`
+
+	Charts  int `json:"charts"`
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+	Changed int `json:"changed"`
+}
+
+// resultError describes the error the command failed with.
+type resultError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// reportResult prints the result of the command if structured output is
+// requested, and returns the error the command should exit with.
+// With structured output, the error is reported in the result, so the
+// returned error is silent.
+func reportResult(p printer, output string, res *commandResult, err error) error {
+	if output != outputJSON {
+		return err
+	}
+
+	if err != nil {
+		res.Error = &resultError{
+			Code:    errorCode(err),
+			Message: err.Error(),
+		}
+	}
+
+	if perr := printStructured(p, output, res); perr != nil {
+		return perr
+	}
+
+	if err != nil {
+		return newSilentError()
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/pkg/errors"
+
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+)
+
+// defaultKeyring returns the path to the default keyring, the same as helm
+// uses.
+func defaultKeyring() string {
+	if dir := os.Getenv("GNUPGHOME"); dir != "" {
+		return filepath.Join(dir, "pubring.gpg")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".gnupg", "pubring.gpg")
+}
+
+// passphraseFetcher returns a function that reads the passphrase of the signing
+// key from the first line of the file, or stdin if the file is "-". If the file
+// is not set, the function returns an error, because the key is encrypted.
+func passphraseFetcher(file string, stdin io.Reader) helmutil.PassphraseFetcher {
+	return func(name string) ([]byte, error) {
+		if file == "" {
+			return nil, fmt.Errorf("the key %q is encrypted, provide its passphrase with --passphrase-file", name)
+		}
+
+		r := stdin
+		if file != "-" {
+			f, err := os.Open(file)
+			if err != nil {
+				return nil, errors.Wrap(err, "open passphrase file")
+			}
+			defer f.Close()
+			r = f
+		}
+
+		passphrase, _, err := bufio.NewReader(r).ReadLine()
+		if err != nil {
+			return nil, errors.Wrap(err, "read passphrase")
+		}
+		return passphrase, nil
+	}
+}
+
+// verifySignedChart verifies the chart file against its provenance file with
+// the keyring, and checks the signer against the provenance policy of the
+// repository.
+func verifySignedChart(name, version, chartPath, provPath, keyring string, settings repoSettings) error {
+	ver, err := helmutil.VerifyChart(chartPath, provPath, keyring)
+	if err != nil {
+		return withErrorCode(errorCodeVerificationFailed, errors.WithMessagef(err, "verify chart %s %s", name, version))
+	}
+
+	if settings.RequireProvenance && len(settings.AllowedSigners) > 0 && !slices.Contains(settings.AllowedSigners, ver.Fingerprint) {
+		return withErrorCode(errorCodePolicyViolation, fmt.Errorf(
+			"chart %s %s is signed by %q with key %s, which is not allowed by the repository policy",
+			name, version, ver.SignedBy, ver.Fingerprint,
+		))
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+// reindexStateFileName is the name of the file that records the state of
+// chart objects the index was built from. It resides next to the index file.
+const reindexStateFileName = "index.yaml.state"
+
+// reindexState describes chart objects as they were when the index was
+// reindexed the last time. Incremental reindex uses it to find out which
+// objects were not changed since then, so that their entries can be reused.
+type reindexState struct {
+	Objects map[string]reindexObjectState `json:"objects"`
+}
+
+// reindexObjectState describes a chart object.
+type reindexObjectState struct {
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+	Digest       string    `json:"digest"`
+}
+
+// newReindexState returns an empty reindex state.
+func newReindexState() *reindexState {
+	return &reindexState{Objects: make(map[string]reindexObjectState)}
+}
+
+// add records the chart object with the digest of its index entry.
+func (s *reindexState) add(info storage.ChartInfo, digest string) {
+	s.Objects[info.Filename] = reindexObjectState{
+		Size:         info.Size,
+		LastModified: info.LastModified.UTC(),
+		Digest:       digest,
+	}
+}
+
+// unchanged returns true if the chart object has the same size and
+// modification time as recorded, and the recorded digest is one of digests.
+func (s *reindexState) unchanged(info storage.ChartInfo, digests []string) bool {
+	obj, ok := s.Objects[info.Filename]
+	if !ok || obj.Size != info.Size || !obj.LastModified.Equal(info.LastModified) {
+		return false
+	}
+
+	for _, digest := range digests {
+		if digest == obj.Digest {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchReindexState fetches the reindex state of the repository.
+// If the state does not exist, an empty state is returned.
+func fetchReindexState(ctx context.Context, store storage.Storage, repoURL string) (*reindexState, error) {
+	b, _, err := store.FetchRaw(ctx, reindexStateFileURL(repoURL))
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return newReindexState(), nil
+	}
+	if err != nil {
+		return nil, errors.WithMessage(err, "fetch reindex state")
+	}
+
+	state := newReindexState()
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, errors.Wrap(err, "unmarshal reindex state")
+	}
+	if state.Objects == nil {
+		state.Objects = make(map[string]reindexObjectState)
+	}
+
+	return state, nil
+}
+
+// putReindexState uploads the reindex state of the repository.
+func putReindexState(ctx context.Context, store storage.Storage, repoURL, acl string, state *reindexState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return errors.Wrap(err, "marshal reindex state")
+	}
+
+	return store.PutRaw(ctx, reindexStateFileURL(repoURL), acl, bytes.NewReader(b), storage.Precondition{})
+}
+
+// reindexStateFileURL returns reindex state file URL for the provided
+// repository URL.
+func reindexStateFileURL(repoURL string) string {
+	return strings.TrimSuffix(repoURL, "/") + "/" + reindexStateFileName
+}
+
+// entriesByFilename groups index entries by the chart filename they refer to,
+// relative to the repository root. Entries referring to charts outside of
+// the repository are omitted.
+func entriesByFilename(idx helmutil.Index, repoURL string) map[string][]helmutil.IndexEntry {
+	entries := make(map[string][]helmutil.IndexEntry)
+	for _, entry := range idx.Entries() {
+		if filename, ok := entryFilename(entry, repoURL); ok {
+			entries[filename] = append(entries[filename], entry)
+		}
+	}
+	return entries
+}
+
+// entryFilename returns the chart filename the index entry refers to,
+// relative to the repository root. Both absolute and relative (escaped)
+// chart URLs are supported.
+func entryFilename(entry helmutil.IndexEntry, repoURL string) (string, bool) {
+	if len(entry.URLs) == 0 {
+		return "", false
+	}
+
+	u := entry.URLs[0]
+	prefix := strings.TrimSuffix(repoURL, "/") + "/"
+	if strings.HasPrefix(u, prefix) {
+		return strings.TrimPrefix(u, prefix), true
+	}
+
+	if strings.Contains(u, "://") {
+		// The chart is located outside of the repository.
+		return "", false
+	}
+
+	filename, err := url.PathUnescape(u)
+	if err != nil {
+		return "", false
+	}
+	return filename, true
+}
+
+// entryDigests returns digests of the index entries.
+func entryDigests(entries []helmutil.IndexEntry) []string {
+	digests := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		digests = append(digests, entry.Digest)
+	}
+	return digests
+}
+
+// createdByDigest returns creation times of charts in the index by their
+// digests.
+func createdByDigest(idx helmutil.Index) map[string]time.Time {
+	created := make(map[string]time.Time)
+	for _, entry := range idx.Entries() {
+		if entry.Digest == "" || entry.Created.IsZero() {
+			continue
+		}
+		if t, ok := created[entry.Digest]; !ok || entry.Created.Before(t) {
+			created[entry.Digest] = entry.Created
+		}
+	}
+	return created
+}
+
+// chartCreated returns the time the chart was created: the time from
+// the current index if the chart is unchanged (has the same digest),
+// otherwise the time the chart was pushed, or the time the chart object was
+// last modified if the push time is unknown. It returns zero time if none of
+// these is known.
+func chartCreated(info storage.ChartInfo, createdByDigest map[string]time.Time) time.Time {
+	if t, ok := createdByDigest[info.Hash]; ok {
+		return t
+	}
+	if !info.Created.IsZero() {
+		return info.Created
+	}
+	return info.LastModified
+}
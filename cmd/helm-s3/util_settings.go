@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+// repoSettingsFileName is the name of the file that stores repository
+// settings. It resides next to the index file.
+const repoSettingsFileName = "index.yaml.settings"
+
+// Repository layouts.
+const (
+	// layoutFlat places all charts in the repository root:
+	// charts/foo-1.0.0.tgz.
+	layoutFlat = "flat"
+
+	// layoutNested places charts in directories named after charts:
+	// charts/foo/foo-1.0.0.tgz.
+	layoutNested = "nested"
+)
+
+// repoSettings are settings of the repository, shared by all its users.
+type repoSettings struct {
+	// Layout is the layout of chart objects in the repository.
+	// Empty value means flat layout.
+	Layout string `json:"layout,omitempty"`
+
+	// RequireProvenance requires charts pushed to the repository to have
+	// a provenance file that verifies.
+	RequireProvenance bool `json:"requireProvenance,omitempty"`
+
+	// AllowedSigners are fingerprints of keys charts pushed to the repository
+	// may be signed with, in upper-case hex. Empty value means any key.
+	AllowedSigners []string `json:"allowedSigners,omitempty"`
+
+	// Immutable forbids replacing published chart versions: push refuses
+	// --force, delete requires --allow-immutable-delete, and reindex refuses
+	// to change digests of existing versions.
+	Immutable bool `json:"immutable,omitempty"`
+
+	// IndexGenerations is the number of previous generations of the index
+	// kept on every index update, so that the index can be rolled back with
+	// 'helm s3 index rollback'. Zero means previous generations are not kept,
+	// except as object versions in buckets with versioning enabled.
+	IndexGenerations int `json:"indexGenerations,omitempty"`
+}
+
+// fetchRepoSettings fetches settings of the repository.
+// If the settings do not exist, zero settings are returned.
+func fetchRepoSettings(ctx context.Context, store storage.Storage, repoURL string) (repoSettings, error) {
+	settings, _, err := fetchRepoSettingsWithCond(ctx, store, repoURL)
+	return settings, err
+}
+
+// fetchRepoSettingsWithCond fetches settings of the repository, along with
+// the precondition to update them with, so that concurrent updates are not
+// lost. If the settings do not exist, zero settings are returned.
+func fetchRepoSettingsWithCond(ctx context.Context, store storage.Storage, repoURL string) (repoSettings, storage.Precondition, error) {
+	b, etag, err := store.FetchRaw(ctx, repoSettingsFileURL(repoURL))
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return repoSettings{}, storage.Precondition{IfNoneMatch: "*"}, nil
+	}
+	if err != nil {
+		return repoSettings{}, storage.Precondition{}, errors.WithMessage(err, "fetch repository settings")
+	}
+
+	var settings repoSettings
+	if err := json.Unmarshal(b, &settings); err != nil {
+		return repoSettings{}, storage.Precondition{}, errors.Wrap(err, "unmarshal repository settings")
+	}
+
+	return settings, storage.Precondition{IfMatch: etag}, nil
+}
+
+// putRepoSettings uploads settings of the repository. If cond is not empty,
+// the settings are uploaded only if the precondition holds.
+func putRepoSettings(ctx context.Context, store storage.Storage, repoURL, acl string, settings repoSettings, cond storage.Precondition) error {
+	b, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal repository settings")
+	}
+
+	if err := store.PutRaw(ctx, repoSettingsFileURL(repoURL), acl, bytes.NewReader(b), cond); err != nil {
+		return errors.WithMessage(err, "upload repository settings")
+	}
+
+	return nil
+}
+
+// repoSettingsFileURL returns repository settings file URL for the provided
+// repository URL.
+func repoSettingsFileURL(repoURL string) string {
+	return strings.TrimSuffix(repoURL, "/") + "/" + repoSettingsFileName
+}
+
+// validateLayout returns an error if the layout is unknown.
+// Empty layout is valid and means the layout is not set.
+func validateLayout(layout string) error {
+	switch layout {
+	case "", layoutFlat, layoutNested:
+		return nil
+	default:
+		return newBadUsageError(fmt.Errorf("unknown layout %q, supported layouts: %s, %s", layout, layoutFlat, layoutNested))
+	}
+}
+
+// validateIndexGenerations returns an error if the number of index
+// generations to keep is negative.
+func validateIndexGenerations(n int) error {
+	if n < 0 {
+		return newBadUsageError(fmt.Errorf("invalid number of index generations %d, must not be negative", n))
+	}
+	return nil
+}
+
+// resolveLayout returns the layout set by the flag, if any, otherwise
+// the layout from the repository settings.
+func resolveLayout(flag string, settings repoSettings) string {
+	if flag != "" {
+		return flag
+	}
+	if settings.Layout != "" {
+		return settings.Layout
+	}
+	return layoutFlat
+}
+
+// chartKey returns the key of the chart file relative to the repository root
+// in the layout.
+func chartKey(layout, chartName, filename string) string {
+	if layout == layoutNested {
+		return chartName + "/" + filename
+	}
+	return filename
+}
+
+// normalizeFingerprints returns key fingerprints in upper-case hex without
+// spaces, as helm and gpg print them, e.g.
+// "6A2B 7A4C ..." or "6a2b7a4c..." become "6A2B7A4C...".
+func normalizeFingerprints(fingerprints []string) ([]string, error) {
+	normalized := make([]string, 0, len(fingerprints))
+	for _, fp := range fingerprints {
+		n := strings.ToUpper(strings.ReplaceAll(fp, " ", ""))
+		if b, err := hex.DecodeString(n); err != nil || len(b) == 0 {
+			return nil, newBadUsageError(fmt.Errorf("invalid key fingerprint %q", fp))
+		}
+		if !slices.Contains(normalized, n) {
+			normalized = append(normalized, n)
+		}
+	}
+	return normalized, nil
+}
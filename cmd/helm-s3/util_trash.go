@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+const (
+	// trashDir is the directory, relative to the repository root, soft-deleted
+	// charts are moved to. Like other directories with names starting with
+	// a dot, it is not traversed by reindex.
+	trashDir = ".trash"
+
+	// trashIndexFileName is the name of the file in the trash directory that
+	// records the index entries of soft-deleted charts.
+	trashIndexFileName = "index.json"
+
+	// trashTimeFormat is the format of the deletion time in the trash keys.
+	// It has nanosecond precision, so that the same chart deleted twice in
+	// a second is not overwritten in the trash.
+	trashTimeFormat = "20060102T150405.000000000Z"
+)
+
+// trashIndex records the soft-deleted charts of the repository.
+type trashIndex struct {
+	Entries []trashEntry `json:"entries"`
+}
+
+// trashEntry describes a soft-deleted chart version.
+type trashEntry struct {
+	Name      string    `json:"name"`
+	Version   string    `json:"version"`
+	Digest    string    `json:"digest,omitempty"`
+	DeletedAt time.Time `json:"deletedAt"`
+
+	// URL is the original URL of the chart object, where it is restored to.
+	// Empty if the index entry had no URL.
+	URL string `json:"url,omitempty"`
+
+	// TrashURL is the URL of the chart object in the trash.
+	TrashURL string `json:"trashURL,omitempty"`
+
+	// IndexEntry is the removed index entry as is, see
+	// helmutil.Index.MarshalEntry.
+	IndexEntry json.RawMessage `json:"indexEntry"`
+}
+
+// find returns the position of the most recently deleted entry of the chart
+// version, or -1 if the trash has no such entry.
+func (t *trashIndex) find(name, version string) int {
+	found := -1
+	for i, entry := range t.Entries {
+		if entry.Name != name || entry.Version != version {
+			continue
+		}
+		if found == -1 || entry.DeletedAt.After(t.Entries[found].DeletedAt) {
+			found = i
+		}
+	}
+	return found
+}
+
+// remove removes the entry with the trash URL and deletion time from the
+// trash, if exists.
+func (t *trashIndex) remove(entry trashEntry) {
+	for i, e := range t.Entries {
+		if e.Name == entry.Name && e.Version == entry.Version && e.TrashURL == entry.TrashURL && e.DeletedAt.Equal(entry.DeletedAt) {
+			t.Entries = append(t.Entries[:i], t.Entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// fetchTrash fetches the trash index of the repository, along with
+// the precondition to update it with. If the trash index does not exist,
+// an empty one is returned.
+func fetchTrash(ctx context.Context, store storage.Storage, repoURL string) (trashIndex, storage.Precondition, error) {
+	b, etag, err := store.FetchRaw(ctx, trashIndexFileURL(repoURL))
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return trashIndex{}, storage.Precondition{IfNoneMatch: "*"}, nil
+	}
+	if err != nil {
+		return trashIndex{}, storage.Precondition{}, errors.WithMessage(err, "fetch trash index")
+	}
+
+	var trash trashIndex
+	if err := json.Unmarshal(b, &trash); err != nil {
+		return trashIndex{}, storage.Precondition{}, errors.Wrap(err, "unmarshal trash index")
+	}
+
+	return trash, storage.Precondition{IfMatch: etag}, nil
+}
+
+// updateTrash fetches the trash index of the repository, applies the change
+// to it and uploads it back. Like updateIndex, it repeats the whole cycle if
+// the trash index was modified concurrently, up to maxRetries times.
+func updateTrash(
+	ctx context.Context,
+	store storage.Storage,
+	repoURL string,
+	acl string,
+	maxRetries int,
+	apply func(trash *trashIndex) error,
+) error {
+	for attempt := 0; ; attempt++ {
+		trash, cond, err := fetchTrash(ctx, store, repoURL)
+		if err != nil {
+			return err
+		}
+
+		if err := apply(&trash); err != nil {
+			return err
+		}
+
+		b, err := json.MarshalIndent(trash, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "marshal trash index")
+		}
+
+		err = store.PutRaw(ctx, trashIndexFileURL(repoURL), acl, bytes.NewReader(b), cond)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, storage.ErrPreconditionFailed) {
+			return errors.WithMessage(err, "upload trash index")
+		}
+		if attempt >= maxRetries {
+			return withErrorCode(errorCodeIndexConflict, errors.Errorf("trash index was modified concurrently, gave up after %d retries", maxRetries))
+		}
+
+		if err := sleepBeforeRetry(ctx, attempt); err != nil {
+			return err
+		}
+	}
+}
+
+// moveToTrash copies the charts of the index entries to the trash and records
+// the entries in the trash index. The original chart objects and the index
+// entries are left in place, the caller removes them once the entries are
+// recorded.
+func moveToTrash(ctx context.Context, store storage.Storage, repoURL, acl string, maxRetries int, entries []trashEntry) error {
+	for _, entry := range entries {
+		if entry.URL == "" {
+			continue
+		}
+		if err := store.CopyChart(ctx, entry.URL, entry.TrashURL, acl); err != nil {
+			if errors.Is(err, storage.ErrObjectNotFound) {
+				return errors.Errorf("chart file %s not found", entry.URL)
+			}
+			return errors.WithMessagef(err, "move chart file %s to the trash", entry.URL)
+		}
+	}
+
+	return updateTrash(ctx, store, repoURL, acl, maxRetries, func(trash *trashIndex) error {
+		trash.Entries = append(trash.Entries, entries...)
+		return nil
+	})
+}
+
+// discardTrashEntries removes the entries recorded by moveToTrash from the
+// trash index, and deletes their chart copies from the trash.
+func discardTrashEntries(ctx context.Context, store storage.Storage, repoURL, acl string, maxRetries int, entries []trashEntry) error {
+	err := updateTrash(ctx, store, repoURL, acl, maxRetries, func(trash *trashIndex) error {
+		for _, entry := range entries {
+			trash.remove(entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.TrashURL == "" {
+			continue
+		}
+		if err := store.DeleteChart(ctx, entry.TrashURL); err != nil {
+			return errors.WithMessage(err, "delete chart file from the trash")
+		}
+	}
+	return nil
+}
+
+// newTrashEntry returns the trash entry for the index entry of the chart
+// version removed from the index.
+func newTrashEntry(repoURL string, entry helmutil.IndexEntry, marshaled []byte, deletedAt time.Time) trashEntry {
+	te := trashEntry{
+		Name:       entry.Name,
+		Version:    entry.Version,
+		Digest:     entry.Digest,
+		DeletedAt:  deletedAt.UTC().Truncate(time.Second),
+		IndexEntry: marshaled,
+	}
+	if len(entry.URLs) == 0 {
+		return te
+	}
+
+	te.URL = absoluteChartURL(repoURL, entry.URLs[0])
+
+	filename, ok := entryFilename(entry, repoURL)
+	if !ok {
+		filename = path.Base(te.URL)
+	}
+	te.TrashURL = strings.TrimSuffix(repoURL, "/") + "/" + trashDir + "/" + deletedAt.UTC().Format(trashTimeFormat) + "/" + filename
+	return te
+}
+
+// trashIndexFileURL returns the trash index file URL for the provided
+// repository URL.
+func trashIndexFileURL(repoURL string) string {
+	return strings.TrimSuffix(repoURL, "/") + "/" + trashDir + "/" + trashIndexFileName
+}
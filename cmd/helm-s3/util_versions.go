@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+// repoVersioner returns the storage as a storage.Versioner, if it supports
+// object versions.
+func repoVersioner(store storage.Storage) (storage.Versioner, error) {
+	versioner, ok := store.(storage.Versioner)
+	if !ok {
+		return nil, withErrorCode(errorCodeVersioningDisabled, errors.New("the repository storage does not support object versions"))
+	}
+	return versioner, nil
+}
+
+// objectVersions returns versions of the object by uri, with a helpful error
+// if versioning is not enabled.
+func objectVersions(ctx context.Context, versioner storage.Versioner, uri string) ([]storage.ObjectVersion, error) {
+	versions, err := versioner.ObjectVersions(ctx, uri)
+	if errors.Is(err, storage.ErrVersioningDisabled) {
+		return nil, errors.WithMessage(err, "previous versions of objects are not kept, enable versioning for the bucket")
+	}
+	if err != nil {
+		return nil, errors.WithMessagef(err, "list versions of %s", uri)
+	}
+	return versions, nil
+}
+
+// chartObjectURL returns the URL of the chart object of the chart version:
+// the URL from the index entry if the version is in the index, otherwise
+// the URL the chart would be pushed to in the repository layout.
+// The entry is returned as well, if found.
+func chartObjectURL(idx helmutil.Index, repoURL, layout, name, version string) (string, helmutil.IndexEntry, bool) {
+	for _, entry := range idx.Entries() {
+		if entry.Name != name || entry.Version != version {
+			continue
+		}
+		if filename, ok := entryFilename(entry, repoURL); ok {
+			return strings.TrimSuffix(repoURL, "/") + "/" + filename, entry, true
+		}
+		if len(entry.URLs) > 0 {
+			return entry.URLs[0], entry, true
+		}
+	}
+
+	key := chartKey(layout, name, name+"-"+version+".tgz")
+	return strings.TrimSuffix(repoURL, "/") + "/" + key, helmutil.IndexEntry{}, false
+}
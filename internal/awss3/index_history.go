@@ -0,0 +1,206 @@
+package awss3
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/pkg/errors"
+
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+// Interface guards.
+var _ storage.IndexHistory = (*Storage)(nil)
+
+// PutIndexKeeping puts the index file like PutIndex, keeping up to keep
+// previous generations of the index. If versioning is enabled for the bucket,
+// previous generations are the versions of the index object, and their expiry
+// is left to the bucket lifecycle rules. Otherwise, the replaced index is
+// copied to an index.yaml.<timestamp> backup object. In both cases, backup
+// objects beyond keep are deleted.
+// uri must be in the form of s3 protocol: s3://bucket-name/key[...].
+func (s *Storage) PutIndexKeeping(ctx context.Context, uri string, acl string, r io.Reader, cond storage.Precondition, keep int) error {
+	if keep < 1 {
+		return s.PutIndex(ctx, uri, acl, r, cond)
+	}
+
+	bucket, _, err := parseURI(uri)
+	if err != nil {
+		return err
+	}
+	status, err := s.bucketVersioning(ctx, bucket)
+	if err != nil {
+		return err
+	}
+
+	var prev []byte
+	if status != s3.BucketVersioningStatusEnabled {
+		prev, _, err = s.FetchRaw(ctx, helmutil.IndexFileURL(uri))
+		if err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+			return errors.WithMessage(err, "fetch index to back up")
+		}
+	}
+
+	if err := s.PutIndex(ctx, uri, acl, r, cond); err != nil {
+		return err
+	}
+
+	// The index is updated at this point, so the errors below must not fail
+	// the update, see storage.ErrIndexGenerationsNotKept.
+	if prev != nil {
+		if err := storage.PutIndexBackup(ctx, s, uri, acl, prev, time.Now()); err != nil {
+			return fmt.Errorf("%w: %w", storage.ErrIndexGenerationsNotKept, errors.WithMessage(err, "upload index backup to s3"))
+		}
+	}
+
+	generations, err := s.IndexGenerations(ctx, uri)
+	if err != nil {
+		return fmt.Errorf("%w: %w", storage.ErrIndexGenerationsNotKept, err)
+	}
+	for _, g := range storage.ExpiredIndexGenerations(storage.IndexBackups(generations), keep) {
+		backupURI, _ := storage.IndexGenerationURI(uri, g.ID)
+		if err := s.Delete(ctx, backupURI); err != nil {
+			return fmt.Errorf("%w: %w", storage.ErrIndexGenerationsNotKept, errors.WithMessage(err, "delete expired index backup"))
+		}
+	}
+
+	return nil
+}
+
+// IndexGenerations returns versions of the index object if the bucket keeps
+// versions, and index backup objects, newest first.
+// uri must be in the form of s3 protocol: s3://bucket-name/key[...].
+func (s *Storage) IndexGenerations(ctx context.Context, uri string) ([]storage.IndexGeneration, error) {
+	indexURI := helmutil.IndexFileURL(uri)
+	bucket, key, err := parseURI(indexURI)
+	if err != nil {
+		return nil, err
+	}
+
+	var generations []storage.IndexGeneration
+	versions, err := s.ObjectVersions(ctx, indexURI)
+	switch {
+	case errors.Is(err, storage.ErrVersioningDisabled):
+		current, err := s.currentIndexGeneration(ctx, bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		if current != nil {
+			generations = append(generations, *current)
+		}
+	case err != nil:
+		return nil, err
+	default:
+		for _, v := range versions {
+			if v.DeleteMarker {
+				continue
+			}
+			generations = append(generations, storage.IndexGeneration{
+				ID:           v.VersionID,
+				LastModified: v.LastModified,
+				Size:         v.Size,
+				Current:      v.IsLatest,
+			})
+		}
+	}
+
+	prefix := strings.TrimSuffix(key, "index.yaml")
+	err = s3.New(s.session).ListObjectsV2PagesWithContext(
+		ctx,
+		&s3.ListObjectsV2Input{
+			Bucket:    aws.String(bucket),
+			Prefix:    aws.String(prefix + "index.yaml."),
+			Delimiter: aws.String("/"),
+		},
+		func(page *s3.ListObjectsV2Output, _ bool) bool {
+			for _, obj := range page.Contents {
+				id, t, ok := storage.ParseIndexBackupName(path.Base(aws.StringValue(obj.Key)))
+				if !ok {
+					continue
+				}
+				generations = append(generations, storage.IndexGeneration{
+					ID:           id,
+					LastModified: t,
+					Size:         aws.Int64Value(obj.Size),
+				})
+			}
+			return true
+		},
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "list s3 index backups")
+	}
+
+	storage.SortIndexGenerations(generations)
+	return generations, nil
+}
+
+// FetchIndexGeneration downloads the index backup object by id, or the
+// version of the index object by id.
+// uri must be in the form of s3 protocol: s3://bucket-name/key[...].
+func (s *Storage) FetchIndexGeneration(ctx context.Context, uri, id string) ([]byte, error) {
+	if genURI, ok := storage.IndexGenerationURI(uri, id); ok {
+		b, _, err := s.FetchRaw(ctx, genURI)
+		return b, err
+	}
+
+	bucket, key, err := parseURI(helmutil.IndexFileURL(uri))
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &aws.WriteAtBuffer{}
+	_, err = s3manager.NewDownloader(s.session).DownloadWithContext(
+		ctx,
+		buf,
+		&s3.GetObjectInput{
+			Bucket:    aws.String(bucket),
+			Key:       aws.String(key),
+			VersionId: aws.String(id),
+		},
+	)
+	if err != nil {
+		if ae, ok := err.(awserr.Error); ok {
+			// S3 responds with InvalidArgument to a malformed version ID, and
+			// with MethodNotAllowed to a get of a delete marker.
+			switch ae.Code() {
+			case s3.ErrCodeNoSuchKey, "NoSuchVersion", "InvalidArgument", "MethodNotAllowed":
+				return nil, storage.ErrObjectNotFound
+			}
+		}
+		return nil, errors.Wrap(err, "fetch s3 object version")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// currentIndexGeneration returns the current index as a generation, or nil if
+// the index does not exist.
+func (s *Storage) currentIndexGeneration(ctx context.Context, bucket, key string) (*storage.IndexGeneration, error) {
+	out, err := s3.New(s.session).HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if ae, ok := err.(awserr.Error); ok && ae.Code() == "NotFound" {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "head s3 index object")
+	}
+
+	return &storage.IndexGeneration{
+		ID:           storage.CurrentIndexGeneration,
+		LastModified: aws.TimeValue(out.LastModified),
+		Size:         aws.Int64Value(out.ContentLength),
+		Current:      true,
+	}, nil
+}
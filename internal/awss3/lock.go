@@ -0,0 +1,190 @@
+package awss3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+const (
+	// lockFileName is the name of the lock object that guards repository
+	// writes. It resides next to the index file.
+	lockFileName = "index.yaml.lock"
+
+	// lockSettleDelay is the time to wait after writing the lock object before
+	// reading it back to verify the ownership.
+	lockSettleDelay = 250 * time.Millisecond
+)
+
+// TryLock makes a single attempt to acquire the lock on the repository.
+// If the repository is locked by another owner and the lock is not expired,
+// storage.ErrLockHeld is returned. An expired lock is taken over.
+// repoURI must be in the form of s3 protocol: s3://bucket-name/key[...].
+func (s *Storage) TryLock(ctx context.Context, repoURI, acl string, lock storage.Lock) error {
+	current, etag, err := s.fetchLock(ctx, repoURI)
+	cond := storage.Precondition{IfNoneMatch: "*"}
+	switch {
+	case err == nil:
+		if current.Owner != lock.Owner && !current.Expired(time.Now()) {
+			return storage.ErrLockHeld
+		}
+		// Take over the stale lock, unless someone else does it first.
+		cond = storage.Precondition{IfMatch: etag}
+	case errors.Is(err, storage.ErrObjectNotFound):
+		// The repository is not locked.
+	default:
+		return err
+	}
+
+	b, err := json.Marshal(lock)
+	if err != nil {
+		return errors.Wrap(err, "marshal lock")
+	}
+
+	bucket, key, err := parseURI(lockFileURL(repoURI))
+	if err != nil {
+		return err
+	}
+
+	if err := s.putObjectConditional(ctx, bucket, key, acl, bytes.NewReader(b), cond); err != nil {
+		if errors.Is(err, storage.ErrPreconditionFailed) {
+			return storage.ErrLockHeld
+		}
+		return errors.WithMessage(err, "upload lock")
+	}
+
+	// Not all S3-compatible backends honor conditional writes, so read the
+	// lock back to verify it was not overwritten by a concurrent writer.
+	t := time.NewTimer(lockSettleDelay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+	}
+
+	current, _, err = s.fetchLock(ctx, repoURI)
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return storage.ErrLockHeld
+	}
+	if err != nil {
+		return err
+	}
+	if current.Owner != lock.Owner {
+		return storage.ErrLockHeld
+	}
+
+	return nil
+}
+
+// Unlock releases the lock on the repository. If the lock is now owned by
+// another owner (e.g. the lease expired and the lock was taken over),
+// the lock is kept and storage.ErrLockHeld is returned.
+// repoURI must be in the form of s3 protocol: s3://bucket-name/key[...].
+func (s *Storage) Unlock(ctx context.Context, repoURI string, lock storage.Lock) error {
+	current, etag, err := s.fetchLock(ctx, repoURI)
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if current.Owner != lock.Owner {
+		return storage.ErrLockHeld
+	}
+
+	bucket, key, err := parseURI(lockFileURL(repoURI))
+	if err != nil {
+		return err
+	}
+
+	// The lock object must not be changed since it was fetched, so that the
+	// lock taken over concurrently is not deleted.
+	if err := s.deleteObjectConditional(ctx, bucket, key, storage.Precondition{IfMatch: etag}); err != nil {
+		if errors.Is(err, storage.ErrPreconditionFailed) {
+			return storage.ErrLockHeld
+		}
+		return errors.WithMessage(err, "delete lock")
+	}
+
+	return nil
+}
+
+// RenewLock extends the lease of the lock on the repository to
+// lock.ExpiresAt. If the repository is not locked by the owner of the lock
+// anymore, storage.ErrLockHeld is returned.
+// repoURI must be in the form of s3 protocol: s3://bucket-name/key[...].
+func (s *Storage) RenewLock(ctx context.Context, repoURI, acl string, lock storage.Lock) error {
+	current, etag, err := s.fetchLock(ctx, repoURI)
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return storage.ErrLockHeld
+	}
+	if err != nil {
+		return err
+	}
+	if current.Owner != lock.Owner {
+		return storage.ErrLockHeld
+	}
+
+	b, err := json.Marshal(lock)
+	if err != nil {
+		return errors.Wrap(err, "marshal lock")
+	}
+
+	bucket, key, err := parseURI(lockFileURL(repoURI))
+	if err != nil {
+		return err
+	}
+
+	// The lock object must not be changed since it was fetched, so that the
+	// lock taken over concurrently is not overwritten.
+	if err := s.putObjectConditional(ctx, bucket, key, acl, bytes.NewReader(b), storage.Precondition{IfMatch: etag}); err != nil {
+		if errors.Is(err, storage.ErrPreconditionFailed) {
+			return storage.ErrLockHeld
+		}
+		return errors.WithMessage(err, "upload lock")
+	}
+
+	return nil
+}
+
+// ForceUnlock releases the lock on the repository regardless of its owner.
+// repoURI must be in the form of s3 protocol: s3://bucket-name/key[...].
+func (s *Storage) ForceUnlock(ctx context.Context, repoURI string) error {
+	return s.Delete(ctx, lockFileURL(repoURI))
+}
+
+// LockStatus returns the current lock on the repository.
+// If the repository is not locked, storage.ErrObjectNotFound is returned.
+// repoURI must be in the form of s3 protocol: s3://bucket-name/key[...].
+func (s *Storage) LockStatus(ctx context.Context, repoURI string) (storage.Lock, error) {
+	lock, _, err := s.fetchLock(ctx, repoURI)
+	return lock, err
+}
+
+// fetchLock fetches the lock object and returns the lock along with the object ETag.
+func (s *Storage) fetchLock(ctx context.Context, repoURI string) (storage.Lock, string, error) {
+	b, etag, err := s.FetchRaw(ctx, lockFileURL(repoURI))
+	if err != nil {
+		return storage.Lock{}, "", err
+	}
+
+	var lock storage.Lock
+	if err := json.Unmarshal(b, &lock); err != nil {
+		return storage.Lock{}, "", errors.Wrap(err, "unmarshal lock")
+	}
+
+	return lock, etag, nil
+}
+
+// lockFileURL returns lock file URL for the provided repository URL.
+func lockFileURL(repoURI string) string {
+	return strings.TrimSuffix(repoURI, "/") + "/" + lockFileName
+}
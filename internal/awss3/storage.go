@@ -5,18 +5,24 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/pkg/errors"
 
+	"github.com/hypnoglow/helm-s3/internal/awsutil"
 	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
 )
 
 const (
@@ -28,14 +34,34 @@ const (
 	s3MetadataSoftLimitBytes = 1900
 )
 
+// Interface guards.
 var (
-	// ErrBucketNotFound signals that a bucket was not found.
-	ErrBucketNotFound = errors.New("bucket not found")
-
-	// ErrObjectNotFound signals that an object was not found.
-	ErrObjectNotFound = errors.New("object not found")
+	_ storage.Storage = (*Storage)(nil)
+	_ storage.Locker  = (*Storage)(nil)
 )
 
+func init() {
+	storage.Register("s3", func(uri string) (storage.Storage, error) {
+		sess, err := awsutil.Session(awsutil.DynamicBucketRegion(uri))
+		if err != nil {
+			return nil, err
+		}
+		return New(sess), nil
+	})
+}
+
+// preconditionHeaders returns HTTP headers representing the precondition.
+func preconditionHeaders(p storage.Precondition) map[string]string {
+	h := make(map[string]string, 2)
+	if p.IfMatch != "" {
+		h["If-Match"] = p.IfMatch
+	}
+	if p.IfNoneMatch != "" {
+		h["If-None-Match"] = p.IfNoneMatch
+	}
+	return h
+}
+
 // New returns a new Storage.
 func New(session *session.Session) *Storage {
 	return &Storage{session: session}
@@ -56,17 +82,18 @@ type Storage struct {
 }
 
 // Traverse traverses all charts in the repository.
-func (s *Storage) Traverse(ctx context.Context, repoURI string) (<-chan ChartInfo, <-chan error) {
-	charts := make(chan ChartInfo, 1)
+// Chart objects are inspected concurrently according to opts.Concurrency.
+func (s *Storage) Traverse(ctx context.Context, repoURI string, opts storage.TraverseOptions) (<-chan storage.ChartInfo, <-chan error) {
+	charts := make(chan storage.ChartInfo, 1)
 	errs := make(chan error, 1)
-	go s.traverse(ctx, repoURI, charts, errs)
+	go s.traverse(ctx, repoURI, opts, charts, errs)
 	return charts, errs
 }
 
 // traverse traverses all charts in the repository.
 // It writes an info item about every chart to items, and errors to errs.
 // It always closes both channels when returns.
-func (s *Storage) traverse(ctx context.Context, repoURI string, items chan<- ChartInfo, errs chan<- error) { //nolint:funcorder // TODO: needs fixing
+func (s *Storage) traverse(ctx context.Context, repoURI string, opts storage.TraverseOptions, items chan<- storage.ChartInfo, errs chan<- error) { //nolint:funcorder // TODO: needs fixing
 	defer close(items)
 	defer close(errs)
 
@@ -78,6 +105,86 @@ func (s *Storage) traverse(ctx context.Context, repoURI string, items chan<- Cha
 
 	client := s3.New(s.session)
 
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Only the first error is reported, the subsequent ones are most likely
+	// caused by the cancellation.
+	var (
+		errOnce  sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	// Objects are listed sequentially, while chart metadata is loaded
+	// concurrently by LoadOrdered, which keeps the listing order.
+	loads := make(chan storage.LoadFunc)
+	listed := make(chan struct{})
+	go func() {
+		defer close(listed)
+		defer close(loads)
+
+		err := s.listCharts(ctx, client, bucket, prefixKey, opts, func(key string, obj *s3.Object) bool {
+			info := storage.ChartInfo{
+				Filename:     key,
+				Size:         aws.Int64Value(obj.Size),
+				LastModified: aws.TimeValue(obj.LastModified),
+			}
+
+			load := func(ctx context.Context) (storage.ChartInfo, error) {
+				loaded, err := s.loadChartInfo(ctx, client, bucket, key, obj.Key)
+				loaded.Size, loaded.LastModified = info.Size, info.LastModified
+				return loaded, err
+			}
+			if opts.Skip != nil && opts.Skip(info) {
+				load = func(ctx context.Context) (storage.ChartInfo, error) {
+					return info, nil
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return false
+			case loads <- load:
+				return true
+			}
+		})
+		if err != nil {
+			fail(err)
+		}
+	}()
+
+	if err := storage.LoadOrdered(ctx, opts.Concurrency, loads, items); err != nil {
+		fail(err)
+	}
+	<-listed
+
+	if firstErr != nil {
+		errs <- firstErr
+	}
+}
+
+// listCharts lists chart objects in the repository and calls fn for every
+// chart, with the key relative to the repository root and the listed object.
+// Listing stops when fn returns false.
+func (s *Storage) listCharts(
+	ctx context.Context,
+	client *s3.S3,
+	bucket, prefixKey string,
+	opts storage.TraverseOptions,
+	fn func(key string, obj *s3.Object) bool,
+) error {
+	// List only objects under the repository "directory", so that sibling
+	// prefixes like "charts-old/" are not mistaken for nested directories
+	// of the "charts" repository.
+	if prefixKey != "" && !strings.HasSuffix(prefixKey, "/") {
+		prefixKey += "/"
+	}
+
 	var continuationToken *string
 	for {
 		listOut, err := client.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
@@ -86,8 +193,7 @@ func (s *Storage) traverse(ctx context.Context, repoURI string, items chan<- Cha
 			ContinuationToken: continuationToken,
 		})
 		if err != nil {
-			errs <- errors.Wrap(err, "list s3 bucket objects")
-			return
+			return errors.Wrap(err, "list s3 bucket objects")
 		}
 
 		for _, obj := range listOut.Contents {
@@ -97,108 +203,102 @@ func (s *Storage) traverse(ctx context.Context, repoURI string, items chan<- Cha
 			// s3://bucket/repo/subdir OR s3://bucket/repo/subdir/
 			key = strings.TrimPrefix(key, "/")
 
-			if strings.Contains(key, "/") {
-				// This is a subfolder. Ignore it, because chart repository
-				// is flat and cannot contain nested directories.
+			if !opts.IsChartKey(key) {
 				continue
 			}
 
-			if !strings.HasSuffix(key, ".tgz") {
-				// Ignore any file that isn't a chart
-				// This could include index.yaml
-				// or any other kind of file that might be in the repo
-				continue
-			}
-
-			metaOut, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
-				Bucket: aws.String(bucket),
-				Key:    obj.Key,
-			})
-			if err != nil {
-				errs <- fmt.Errorf("head s3 object %q: %s", key, err)
-				return
-			}
-
-			reindexItem := ChartInfo{Filename: key}
-
-			serializedChartMeta, hasMeta := metaOut.Metadata[strings.Title(metaChartMetadata)] //nolint:staticcheck // Safe use of strings.Title
-			chartDigest, hasDigest := metaOut.Metadata[strings.Title(metaChartDigest)]         //nolint:staticcheck // Safe use of strings.Title
-			if !hasMeta || !hasDigest {
-				// Some charts in the repository can have no metadata.
-				//
-				// This might happen in few cases:
-				// - Chart was uploaded manually, not using 'helm s3 push';
-				// - Chart was pushed before we started adding metadata to objects;
-				// - Chart metadata was too big to add to the S3 object metadata (see issues
-				//   https://github.com/hypnoglow/helm-s3/issues/120 and
-				//   https://github.com/hypnoglow/helm-s3/issues/112 )
-				//
-				// In this case we have to download the ch file itself.
-				objectOut, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
-					Bucket: aws.String(bucket),
-					Key:    obj.Key,
-				})
-				if err != nil {
-					errs <- fmt.Errorf("get s3 object %q: %s", key, err)
-					return
-				}
-
-				buf := &bytes.Buffer{}
-				tr := io.TeeReader(objectOut.Body, buf)
-
-				ch, err := helmutil.LoadArchive(tr)
-				objectOut.Body.Close()
-				if err != nil {
-					errs <- fmt.Errorf("load archive from s3 object %q: %s", key, err)
-					return
-				}
-
-				digest, err := helmutil.Digest(buf)
-				if err != nil {
-					errs <- fmt.Errorf("get chart hash for %q: %s", key, err)
-					return
-				}
-
-				reindexItem.Meta = ch.Metadata()
-				reindexItem.Hash = digest
-			} else {
-				meta := helmutil.NewChartMetadata()
-				if err := meta.UnmarshalJSON([]byte(*serializedChartMeta)); err != nil {
-					errs <- fmt.Errorf("unserialize chart meta for %q: %s", key, err)
-					return
-				}
-
-				reindexItem.Meta = meta
-				reindexItem.Hash = *chartDigest
+			if !fn(key, obj) {
+				return nil
 			}
-
-			// process meta and hash
-			items <- reindexItem
 		}
 
 		// Decide if need to load more objects.
 		if listOut.NextContinuationToken == nil {
-			break
+			return nil
 		}
 		continuationToken = listOut.NextContinuationToken
 	}
 }
 
-// ChartInfo contains info about particular chart.
-type ChartInfo struct {
-	Meta     helmutil.ChartMetadata
-	Filename string
-	Hash     string
+// loadChartInfo returns info about the chart object, preferring the object
+// metadata and falling back to downloading the chart.
+func (s *Storage) loadChartInfo(ctx context.Context, client *s3.S3, bucket, key string, objKey *string) (storage.ChartInfo, error) {
+	metaOut, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    objKey,
+	})
+	if err != nil {
+		return storage.ChartInfo{}, fmt.Errorf("head s3 object %q: %s", key, err)
+	}
+
+	created := parseCreated(metaOut.Metadata[strings.Title(metaChartCreated)]) //nolint:staticcheck // Safe use of strings.Title
+
+	serializedChartMeta, hasMeta := metaOut.Metadata[strings.Title(metaChartMetadata)] //nolint:staticcheck // Safe use of strings.Title
+	chartDigest, hasDigest := metaOut.Metadata[strings.Title(metaChartDigest)]         //nolint:staticcheck // Safe use of strings.Title
+	if !hasMeta || !hasDigest {
+		// Some charts in the repository can have no metadata.
+		//
+		// This might happen in few cases:
+		// - Chart was uploaded manually, not using 'helm s3 push';
+		// - Chart was pushed before we started adding metadata to objects;
+		// - Chart metadata was too big to add to the S3 object metadata (see issues
+		//   https://github.com/hypnoglow/helm-s3/issues/120 and
+		//   https://github.com/hypnoglow/helm-s3/issues/112 )
+		//
+		// In this case we have to download the ch file itself.
+		objectOut, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    objKey,
+		})
+		if err != nil {
+			return storage.ChartInfo{}, fmt.Errorf("get s3 object %q: %s", key, err)
+		}
+		defer objectOut.Body.Close()
+
+		info, err := storage.LoadChartInfo(key, objectOut.Body)
+		info.Created = created
+		info.Downloaded = true
+		return info, err
+	}
+
+	meta := helmutil.NewChartMetadata()
+	if err := meta.UnmarshalJSON([]byte(*serializedChartMeta)); err != nil {
+		return storage.ChartInfo{}, fmt.Errorf("unserialize chart meta for %q: %s", key, err)
+	}
+
+	return storage.ChartInfo{
+		Meta:     meta,
+		Filename: key,
+		Hash:     *chartDigest,
+		Created:  created,
+	}, nil
 }
 
-// FetchRaw downloads the object from URI and returns it in the form of byte slice.
+// parseCreated parses the chart push time recorded in the object metadata.
+// It returns zero time if the value is missing or malformed.
+func parseCreated(v *string) time.Time {
+	if v == nil {
+		return time.Time{}
+	}
+
+	created, err := time.Parse(time.RFC3339, *v)
+	if err != nil {
+		return time.Time{}
+	}
+	return created
+}
+
+// FetchRaw downloads the object from URI and returns it in the form of byte slice,
+// along with the object ETag. The ETag can be used as a precondition for
+// subsequent writes of the same object.
 // uri must be in the form of s3 protocol: s3://bucket-name/key[...].
-func (s *Storage) FetchRaw(ctx context.Context, uri string) ([]byte, error) {
+func (s *Storage) FetchRaw(ctx context.Context, uri string) ([]byte, string, error) {
 	bucket, key, err := parseURI(uri)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
+	etag := &etagRecorder{}
 	buf := &aws.WriteAtBuffer{}
 	_, err = s3manager.NewDownloader(s.session).DownloadWithContext(
 		ctx,
@@ -206,20 +306,49 @@ func (s *Storage) FetchRaw(ctx context.Context, uri string) ([]byte, error) {
 		&s3.GetObjectInput{
 			Bucket: aws.String(bucket),
 			Key:    aws.String(key),
-		})
+		},
+		s3manager.WithDownloaderRequestOptions(etag.record),
+	)
 	if err != nil {
 		if ae, ok := err.(awserr.Error); ok {
 			if ae.Code() == s3.ErrCodeNoSuchBucket {
-				return nil, ErrBucketNotFound
+				return nil, "", storage.ErrBucketNotFound
 			}
 			if ae.Code() == s3.ErrCodeNoSuchKey {
-				return nil, ErrObjectNotFound
+				return nil, "", storage.ErrObjectNotFound
 			}
 		}
-		return nil, errors.Wrap(err, "fetch object from s3")
+		return nil, "", errors.Wrap(err, "fetch object from s3")
 	}
 
-	return buf.Bytes(), nil
+	return buf.Bytes(), etag.value(), nil
+}
+
+// etagRecorder records the ETag of the object being downloaded.
+// The downloader may fetch object parts concurrently, so the access is guarded.
+type etagRecorder struct {
+	mu   sync.Mutex
+	etag string
+}
+
+// record is a request option that captures the ETag response header.
+func (r *etagRecorder) record(req *request.Request) {
+	req.Handlers.Complete.PushBack(func(req *request.Request) {
+		if req.HTTPResponse == nil {
+			return
+		}
+		if v := req.HTTPResponse.Header.Get("ETag"); v != "" {
+			r.mu.Lock()
+			r.etag = v
+			r.mu.Unlock()
+		}
+	})
+}
+
+func (r *etagRecorder) value() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.etag
 }
 
 // Exists returns true if an object exists in the storage.
@@ -244,6 +373,32 @@ func (s *Storage) Exists(ctx context.Context, uri string) (bool, error) {
 	return true, nil
 }
 
+// ChartDigest returns the chart digest recorded in the object metadata.
+// uri must be in the form of s3 protocol: s3://bucket-name/key[...].
+func (s *Storage) ChartDigest(ctx context.Context, uri string) (string, error) {
+	bucket, key, err := parseURI(uri)
+	if err != nil {
+		return "", err
+	}
+
+	metaOut, err := s3.New(s.session).HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if ae, ok := err.(awserr.Error); ok && ae.Code() == "NotFound" {
+			return "", storage.ErrObjectNotFound
+		}
+		return "", errors.Wrap(err, "head s3 object")
+	}
+
+	chartDigest := metaOut.Metadata[strings.Title(metaChartDigest)] //nolint:staticcheck // Safe use of strings.Title
+	if chartDigest == nil {
+		return "", nil
+	}
+	return *chartDigest, nil
+}
+
 // PutChart puts the chart file to the storage.
 // uri must be in the form of s3 protocol: s3://bucket-name/key[...].
 func (s *Storage) PutChart(
@@ -272,7 +427,7 @@ func (s *Storage) PutChart(
 			ContentType:          aws.String(contentType),
 			ServerSideEncryption: getSSE(),
 			Body:                 r,
-			Metadata:             assembleObjectMetadata(chartMeta, chartDigest),
+			Metadata:             assembleObjectMetadata(chartMeta, chartDigest, time.Now()),
 		},
 	)
 	if err != nil {
@@ -299,8 +454,10 @@ func (s *Storage) PutChart(
 }
 
 // PutIndex puts the index file to the storage.
+// If cond is not empty, the index is written only if the precondition holds,
+// otherwise storage.ErrPreconditionFailed is returned.
 // uri must be in the form of s3 protocol: s3://bucket-name/key[...].
-func (s *Storage) PutIndex(ctx context.Context, uri string, acl string, r io.Reader) error {
+func (s *Storage) PutIndex(ctx context.Context, uri string, acl string, r io.Reader, cond storage.Precondition) error {
 	if strings.HasPrefix(uri, "index.yaml") {
 		return errors.New("uri must not contain \"index.yaml\" suffix, it appends automatically")
 	}
@@ -310,6 +467,14 @@ func (s *Storage) PutIndex(ctx context.Context, uri string, acl string, r io.Rea
 	if err != nil {
 		return err
 	}
+
+	if !cond.IsZero() {
+		if err := s.putObjectConditional(ctx, bucket, key, acl, r, cond); err != nil {
+			return errors.WithMessage(err, "upload index to S3 bucket")
+		}
+		return nil
+	}
+
 	_, err = s3manager.NewUploader(s.session).UploadWithContext(
 		ctx,
 		&s3manager.UploadInput{
@@ -326,6 +491,90 @@ func (s *Storage) PutIndex(ctx context.Context, uri string, acl string, r io.Rea
 	return nil
 }
 
+// PutRaw puts the object to the storage with a single PutObject request.
+// If cond is not empty, the object is written only if the precondition holds,
+// otherwise storage.ErrPreconditionFailed is returned.
+// uri must be in the form of s3 protocol: s3://bucket-name/key[...].
+func (s *Storage) PutRaw(ctx context.Context, uri string, acl string, r io.Reader, cond storage.Precondition) error {
+	bucket, key, err := parseURI(uri)
+	if err != nil {
+		return err
+	}
+
+	return s.putObjectConditional(ctx, bucket, key, acl, r, cond)
+}
+
+// putObjectConditional puts the object to the storage with a single PutObject
+// request carrying the precondition headers. Multipart upload is not used here,
+// because conditionally written objects (like the index file) are small enough
+// and conditional headers are only meaningful for a single write.
+func (s *Storage) putObjectConditional(ctx context.Context, bucket, key, acl string, r io.Reader, cond storage.Precondition) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "read object body")
+	}
+
+	_, err = s3.New(s.session).PutObjectWithContext(
+		ctx,
+		&s3.PutObjectInput{
+			Bucket:               aws.String(bucket),
+			Key:                  aws.String(key),
+			ACL:                  aws.String(acl),
+			ServerSideEncryption: getSSE(),
+			Body:                 bytes.NewReader(b),
+		},
+		request.WithSetRequestHeaders(preconditionHeaders(cond)),
+	)
+	if err != nil {
+		if isPreconditionFailed(err) {
+			return storage.ErrPreconditionFailed
+		}
+		return errors.Wrap(err, "upload object to s3")
+	}
+
+	return nil
+}
+
+// deleteObjectConditional deletes the object with a single DeleteObject
+// request carrying the precondition headers. If the precondition does not
+// hold, storage.ErrPreconditionFailed is returned. Backends that do not
+// support conditional deletes ignore the headers.
+func (s *Storage) deleteObjectConditional(ctx context.Context, bucket, key string, cond storage.Precondition) error {
+	_, err := s3.New(s.session).DeleteObjectWithContext(
+		ctx,
+		&s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		},
+		request.WithSetRequestHeaders(preconditionHeaders(cond)),
+	)
+	if err != nil {
+		if isPreconditionFailed(err) {
+			return storage.ErrPreconditionFailed
+		}
+		return errors.Wrap(err, "delete object from s3")
+	}
+
+	return nil
+}
+
+// isPreconditionFailed returns true if the error signals that a conditional
+// write was rejected. Besides "412 Precondition Failed", S3 may respond with
+// "409 Conflict" when a concurrent conditional write is in progress.
+func isPreconditionFailed(err error) bool {
+	var reqErr awserr.RequestFailure
+	if !errors.As(err, &reqErr) {
+		return false
+	}
+
+	switch reqErr.StatusCode() {
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		return true
+	default:
+		return false
+	}
+}
+
 // IndexExists returns true if index file exists in the storage for repository
 // with the provided uri.
 // uri must be in the form of s3 protocol: s3://bucket-name/key[...].
@@ -391,6 +640,61 @@ func (s *Storage) DeleteChart(ctx context.Context, uri string) error {
 	return nil
 }
 
+// CopyChart copies the chart object from srcURI to dstURI along with its
+// metadata, and the .prov file if exists, with server-side CopyObject
+// requests. The requests are made to the destination bucket, which may be
+// in another region than the source one.
+// uris must be in the form of s3 protocol: s3://bucket-name/key[...].
+func (s *Storage) CopyChart(ctx context.Context, srcURI, dstURI string, acl string) error {
+	if err := s.copyObject(ctx, srcURI, dstURI, acl); err != nil {
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			return err
+		}
+		return fmt.Errorf("copy chart object in s3: %w", err)
+	}
+
+	if err := s.copyObject(ctx, srcURI+".prov", dstURI+".prov", acl); err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+		return fmt.Errorf("copy prov object in s3: %w", err)
+	}
+
+	return nil
+}
+
+// copyObject copies the object with a single CopyObject request, which keeps
+// the object metadata. Charts are far below the 5 GB limit of the request.
+func (s *Storage) copyObject(ctx context.Context, srcURI, dstURI string, acl string) error {
+	srcBucket, srcKey, err := parseURI(srcURI)
+	if err != nil {
+		return err
+	}
+	dstBucket, dstKey, err := parseURI(dstURI)
+	if err != nil {
+		return err
+	}
+
+	_, err = s3.New(s.session).CopyObjectWithContext(
+		ctx,
+		&s3.CopyObjectInput{
+			Bucket:               aws.String(dstBucket),
+			Key:                  aws.String(dstKey),
+			CopySource:           aws.String(url.PathEscape(srcBucket + "/" + srcKey)),
+			MetadataDirective:    aws.String(s3.MetadataDirectiveCopy),
+			ACL:                  aws.String(acl),
+			ServerSideEncryption: getSSE(),
+		},
+	)
+	if err != nil {
+		if ae, ok := err.(awserr.Error); ok {
+			if ae.Code() == s3.ErrCodeNoSuchKey || ae.Code() == "NotFound" {
+				return storage.ErrObjectNotFound
+			}
+		}
+		return errors.Wrap(err, "copy s3 object")
+	}
+
+	return nil
+}
+
 // parseURI returns bucket and key from URIs like:
 //   - s3://bucket-name/dir
 //   - s3://bucket-name/dir/file.ext
@@ -415,13 +719,17 @@ func parseURI(uri string) (bucket, key string, err error) {
 // To mitigate the issue with large charts which metadata is more than 2 KB,
 // we simply drop it. This affects 'reindex' operation, so that it has to download
 // the chart file (GET Request) instead of only fetching its metadata (HEAD request).
-func assembleObjectMetadata(chartMeta, chartDigest string) map[string]*string {
+func assembleObjectMetadata(chartMeta, chartDigest string, created time.Time) map[string]*string {
 	meta := map[string]*string{
 		metaChartMetadata: aws.String(chartMeta),
 		metaChartDigest:   aws.String(chartDigest),
+		metaChartCreated:  aws.String(created.UTC().Format(time.RFC3339)),
 	}
 	if objectMetadataSize(meta) > s3MetadataSoftLimitBytes {
-		return nil
+		// The push time is small and is still worth keeping.
+		return map[string]*string{
+			metaChartCreated: meta[metaChartCreated],
+		}
 	}
 
 	return meta
@@ -447,4 +755,8 @@ const (
 
 	// metaChartDigest is a s3 object metadata key that represents chart digest.
 	metaChartDigest = "chart-digest"
+
+	// metaChartCreated is a s3 object metadata key that represents the time
+	// the chart was pushed.
+	metaChartCreated = "chart-created"
 )
@@ -0,0 +1,127 @@
+package awss3
+
+import (
+	"context"
+	"net/url"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+// Interface guards.
+var _ storage.Versioner = (*Storage)(nil)
+
+// ObjectVersions returns versions of the object by uri, including delete
+// markers, newest first.
+// uri must be in the form of s3 protocol: s3://bucket-name/key[...].
+func (s *Storage) ObjectVersions(ctx context.Context, uri string) ([]storage.ObjectVersion, error) {
+	bucket, key, err := parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := s.bucketVersioning(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	// Versions are kept when versioning is suspended after being enabled.
+	if status == "" {
+		return nil, storage.ErrVersioningDisabled
+	}
+
+	var versions []storage.ObjectVersion
+	err = s3.New(s.session).ListObjectVersionsPagesWithContext(
+		ctx,
+		&s3.ListObjectVersionsInput{
+			Bucket: aws.String(bucket),
+			Prefix: aws.String(key),
+		},
+		func(page *s3.ListObjectVersionsOutput, _ bool) bool {
+			// The prefix also matches e.g. the .prov object of the chart.
+			for _, v := range page.Versions {
+				if aws.StringValue(v.Key) != key {
+					continue
+				}
+				versions = append(versions, storage.ObjectVersion{
+					VersionID:    aws.StringValue(v.VersionId),
+					LastModified: aws.TimeValue(v.LastModified),
+					Size:         aws.Int64Value(v.Size),
+					ETag:         aws.StringValue(v.ETag),
+					IsLatest:     aws.BoolValue(v.IsLatest),
+				})
+			}
+			for _, m := range page.DeleteMarkers {
+				if aws.StringValue(m.Key) != key {
+					continue
+				}
+				versions = append(versions, storage.ObjectVersion{
+					VersionID:    aws.StringValue(m.VersionId),
+					LastModified: aws.TimeValue(m.LastModified),
+					IsLatest:     aws.BoolValue(m.IsLatest),
+					DeleteMarker: true,
+				})
+			}
+			return true
+		},
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "list s3 object versions")
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+	return versions, nil
+}
+
+// RestoreObjectVersion makes the version of the object by uri current with
+// a server-side CopyObject request, which keeps the object metadata.
+// uri must be in the form of s3 protocol: s3://bucket-name/key[...].
+func (s *Storage) RestoreObjectVersion(ctx context.Context, uri, versionID, acl string) error {
+	bucket, key, err := parseURI(uri)
+	if err != nil {
+		return err
+	}
+
+	_, err = s3.New(s.session).CopyObjectWithContext(
+		ctx,
+		&s3.CopyObjectInput{
+			Bucket:               aws.String(bucket),
+			Key:                  aws.String(key),
+			CopySource:           aws.String(url.PathEscape(bucket+"/"+key) + "?versionId=" + url.QueryEscape(versionID)),
+			MetadataDirective:    aws.String(s3.MetadataDirectiveCopy),
+			ACL:                  aws.String(acl),
+			ServerSideEncryption: getSSE(),
+		},
+	)
+	if err != nil {
+		if ae, ok := err.(awserr.Error); ok {
+			// S3 responds with InvalidArgument to a malformed version ID, and
+			// with InvalidRequest to a copy of a delete marker.
+			switch ae.Code() {
+			case s3.ErrCodeNoSuchKey, "NoSuchVersion", "InvalidArgument", "InvalidRequest":
+				return storage.ErrObjectNotFound
+			}
+		}
+		return errors.Wrap(err, "copy s3 object version")
+	}
+
+	return nil
+}
+
+// bucketVersioning returns the versioning status of the bucket: empty if
+// versioning was never enabled, "Enabled" or "Suspended".
+func (s *Storage) bucketVersioning(ctx context.Context, bucket string) (string, error) {
+	out, err := s3.New(s.session).GetBucketVersioningWithContext(ctx, &s3.GetBucketVersioningInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "get s3 bucket versioning")
+	}
+	return aws.StringValue(out.Status), nil
+}
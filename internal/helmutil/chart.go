@@ -42,6 +42,12 @@ type ChartMetadata interface {
 	// UnmarshalJSON unmarshals chart metadata from JSON.
 	UnmarshalJSON([]byte) error
 
+	// Name returns chart name.
+	Name() string
+
+	// Version returns chart version.
+	Version() string
+
 	// Value returns underlying chart metadata value.
 	Value() interface{}
 }
@@ -60,6 +60,14 @@ func (c *chartMetadataV2) UnmarshalJSON(b []byte) error {
 	return json.Unmarshal(b, c.meta)
 }
 
+func (c *chartMetadataV2) Name() string {
+	return c.meta.GetName()
+}
+
+func (c *chartMetadataV2) Version() string {
+	return c.meta.GetVersion()
+}
+
 func (c *chartMetadataV2) Value() interface{} {
 	return c.meta
 }
@@ -63,6 +63,20 @@ func (c *chartMetadataV3) UnmarshalJSON(b []byte) error {
 	return json.Unmarshal(b, c.meta)
 }
 
+func (c *chartMetadataV3) Name() string {
+	if c.meta == nil {
+		return ""
+	}
+	return c.meta.Name
+}
+
+func (c *chartMetadataV3) Version() string {
+	if c.meta == nil {
+		return ""
+	}
+	return c.meta.Version
+}
+
 func (c *chartMetadataV3) Value() interface{} {
 	return c.meta
 }
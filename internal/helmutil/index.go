@@ -3,8 +3,19 @@ package helmutil
 import (
 	"io"
 	"os"
+	"time"
 )
 
+// IndexEntry describes a chart version in the index.
+type IndexEntry struct {
+	Name       string
+	Version    string
+	AppVersion string
+	Created    time.Time
+	Digest     string
+	URLs       []string
+}
+
 // Index describes helm chart repo index.
 type Index interface {
 	// Add adds chart version to the index.
@@ -21,17 +32,40 @@ type Index interface {
 	AddOrReplace(metadata interface{}, filename, baseURL, digest string) error
 
 	// Delete removes chart version from the index and returns url to the deleted item.
+	// If no versions of the chart remain, the chart is removed from the index.
 	Delete(name, version string) (url string, err error)
 
 	// Has returns true if the index has an entry for a chart with the given name and exact version.
 	Has(name, version string) bool
 
+	// SetCreated sets the time the chart version with the given name and exact
+	// version was created.
+	SetCreated(name, version string, created time.Time) error
+
+	// MarshalEntry encodes the chart version with the given name and exact
+	// version to JSON, keeping all its fields, e.g. to restore it later with
+	// AddMarshaledEntry.
+	MarshalEntry(name, version string) ([]byte, error)
+
+	// AddMarshaledEntry adds the chart version encoded by MarshalEntry to
+	// the index as is. Returns an error if the version already exists.
+	//
+	// Note: this can leave the index in an unsorted state.
+	AddMarshaledEntry(data []byte) error
+
+	// Entries returns all chart versions in the index, ordered by chart name,
+	// and by version in the order they are stored in the index.
+	Entries() []IndexEntry
+
 	// SortEntries sorts the entries by version in descending order.
 	SortEntries()
 
 	// UpdateGeneratedTime updates time when the index was generated.
 	UpdateGeneratedTime()
 
+	// Generated returns time when the index was generated.
+	Generated() time.Time
+
 	// MarshalBinary encodes index to a binary form.
 	MarshalBinary() (data []byte, err error)
 
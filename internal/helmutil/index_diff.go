@@ -0,0 +1,79 @@
+package helmutil
+
+import (
+	"slices"
+)
+
+// IndexDiff describes differences between two indexes.
+type IndexDiff struct {
+	// Added are chart versions present only in the new index.
+	Added []IndexEntry
+
+	// Removed are chart versions present only in the old index.
+	Removed []IndexEntry
+
+	// Changed are chart versions present in both indexes, but with
+	// different digests or URLs.
+	Changed []IndexEntryChange
+}
+
+// IndexEntryChange describes a chart version changed between two indexes.
+type IndexEntryChange struct {
+	Old IndexEntry
+	New IndexEntry
+}
+
+// DigestChanged returns true if the chart version digest was changed.
+func (c IndexEntryChange) DigestChanged() bool {
+	return c.Old.Digest != c.New.Digest
+}
+
+// URLsChanged returns true if the chart version URLs were changed.
+func (c IndexEntryChange) URLsChanged() bool {
+	return !slices.Equal(c.Old.URLs, c.New.URLs)
+}
+
+// IsEmpty returns true if there are no differences.
+func (d IndexDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// DiffIndex returns differences between the old and the new index.
+// Chart versions are matched by chart name and version. The result follows
+// the order of Index.Entries: added and changed versions in the order of
+// the new index, removed ones in the order of the old index.
+func DiffIndex(oldIdx, newIdx Index) IndexDiff {
+	type key struct{ name, version string }
+
+	oldEntries := oldIdx.Entries()
+	oldByKey := make(map[key]IndexEntry, len(oldEntries))
+	for _, entry := range oldEntries {
+		oldByKey[key{entry.Name, entry.Version}] = entry
+	}
+
+	var diff IndexDiff
+	seen := make(map[key]bool)
+	for _, entry := range newIdx.Entries() {
+		k := key{entry.Name, entry.Version}
+		seen[k] = true
+
+		oldEntry, ok := oldByKey[k]
+		if !ok {
+			diff.Added = append(diff.Added, entry)
+			continue
+		}
+
+		change := IndexEntryChange{Old: oldEntry, New: entry}
+		if change.DigestChanged() || change.URLsChanged() {
+			diff.Changed = append(diff.Changed, change)
+		}
+	}
+
+	for _, entry := range oldEntries {
+		if !seen[key{entry.Name, entry.Version}] {
+			diff.Removed = append(diff.Removed, entry)
+		}
+	}
+
+	return diff
+}
@@ -0,0 +1,49 @@
+package helmutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+func TestDiffIndex(t *testing.T) {
+	add := func(t *testing.T, idx Index, name, version, baseURL, digest string) {
+		t.Helper()
+		md := &chart.Metadata{Name: name, Version: version}
+		require.NoError(t, idx.Add(md, name+"-"+version+".tgz", baseURL, digest))
+	}
+
+	old := newIndexV3()
+	add(t, old, "foo", "1.0.0", "s3://bucket/charts", "sha256:1")
+	add(t, old, "foo", "1.1.0", "s3://bucket/charts", "sha256:2")
+	add(t, old, "bar", "0.1.0", "s3://bucket/charts", "sha256:3")
+	add(t, old, "baz", "0.1.0", "s3://bucket/charts", "sha256:4")
+
+	newIdx := newIndexV3()
+	add(t, newIdx, "foo", "1.0.0", "s3://bucket/charts", "sha256:1")
+	add(t, newIdx, "foo", "1.1.0", "s3://bucket/charts", "sha256:22")
+	add(t, newIdx, "baz", "0.1.0", "", "sha256:4")
+	add(t, newIdx, "qux", "0.1.0", "s3://bucket/charts", "sha256:5")
+
+	diff := DiffIndex(old, newIdx)
+
+	require.Len(t, diff.Added, 1)
+	assert.Equal(t, "qux", diff.Added[0].Name)
+
+	require.Len(t, diff.Removed, 1)
+	assert.Equal(t, "bar", diff.Removed[0].Name)
+
+	require.Len(t, diff.Changed, 2)
+	assert.Equal(t, "baz", diff.Changed[0].New.Name)
+	assert.False(t, diff.Changed[0].DigestChanged())
+	assert.True(t, diff.Changed[0].URLsChanged())
+	assert.Equal(t, "foo", diff.Changed[1].New.Name)
+	assert.Equal(t, "1.1.0", diff.Changed[1].New.Version)
+	assert.True(t, diff.Changed[1].DigestChanged())
+	assert.False(t, diff.Changed[1].URLsChanged())
+
+	assert.False(t, diff.IsEmpty())
+	assert.True(t, DiffIndex(newIdx, newIdx).IsEmpty())
+}
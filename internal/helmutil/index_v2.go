@@ -2,10 +2,12 @@ package helmutil
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 
 	"github.com/Masterminds/semver"
@@ -102,6 +104,9 @@ func (idx *IndexV2) Delete(name, version string) (url string, err error) {
 					idx.index.Entries[chartName][:i],
 					idx.index.Entries[chartName][i+1:]...,
 				)
+				if len(idx.index.Entries[chartName]) == 0 {
+					delete(idx.index.Entries, chartName)
+				}
 				if len(chartVersion.URLs) > 0 {
 					return chartVersion.URLs[0], nil
 				}
@@ -117,6 +122,66 @@ func (idx *IndexV2) Has(name, version string) bool {
 	return idx.index.Has(name, version)
 }
 
+func (idx *IndexV2) SetCreated(name, version string, created time.Time) error {
+	for _, chartVersion := range idx.index.Entries[name] {
+		if chartVersion.Version == version {
+			chartVersion.Created = created
+			return nil
+		}
+	}
+
+	return fmt.Errorf("chart %s version %s not found in index", name, version)
+}
+
+func (idx *IndexV2) MarshalEntry(name, version string) ([]byte, error) {
+	for _, chartVersion := range idx.index.Entries[name] {
+		if chartVersion.Version == version {
+			return json.Marshal(chartVersion)
+		}
+	}
+
+	return nil, fmt.Errorf("chart %s version %s not found in index", name, version)
+}
+
+func (idx *IndexV2) AddMarshaledEntry(data []byte) error {
+	cv := &repo.ChartVersion{}
+	if err := json.Unmarshal(data, cv); err != nil {
+		return errors.Wrap(err, "unmarshal index entry")
+	}
+	if cv.Metadata == nil || cv.Name == "" {
+		return errors.New("index entry has no chart name")
+	}
+	if idx.index.Has(cv.Name, cv.Version) {
+		return fmt.Errorf("chart %s version %s already exists in index", cv.Name, cv.Version)
+	}
+
+	idx.index.Entries[cv.Name] = append(idx.index.Entries[cv.Name], cv)
+	return nil
+}
+
+func (idx *IndexV2) Entries() []IndexEntry {
+	names := make([]string, 0, len(idx.index.Entries))
+	for name := range idx.index.Entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var entries []IndexEntry
+	for _, name := range names {
+		for _, cv := range idx.index.Entries[name] {
+			entries = append(entries, IndexEntry{
+				Name:       cv.Name,
+				Version:    cv.Version,
+				AppVersion: cv.AppVersion,
+				Created:    cv.Created,
+				Digest:     cv.Digest,
+				URLs:       cv.URLs,
+			})
+		}
+	}
+	return entries
+}
+
 func (idx *IndexV2) SortEntries() {
 	idx.index.SortEntries()
 }
@@ -125,6 +190,10 @@ func (idx *IndexV2) UpdateGeneratedTime() {
 	idx.index.Generated = time.Now().UTC()
 }
 
+func (idx *IndexV2) Generated() time.Time {
+	return idx.index.Generated
+}
+
 func (idx *IndexV2) MarshalBinary() (data []byte, err error) {
 	return yaml.Marshal(idx.index)
 }
@@ -135,3 +135,37 @@ func TestIndexV2_UpdateGeneratedTime(t *testing.T) {
 	generatedNew := idx.index.Generated
 	assert.True(t, generatedNew.After(generatedOld), "Expected %s greater than %s", generatedNew.String(), generatedOld.String())
 }
+
+func TestIndexV2_Entries(t *testing.T) {
+	idx := newIndexV2()
+	for _, md := range []*chart.Metadata{
+		{Name: "foo", Version: "0.1.0", AppVersion: "1.0"},
+		{Name: "bar", Version: "1.0.0"},
+		{Name: "foo", Version: "0.2.0", AppVersion: "2.0"},
+	} {
+		require.NoError(t, idx.Add(md, md.Name+"-"+md.Version+".tgz", "s3://bucket/charts", "sha256:"+md.Version))
+	}
+	idx.SortEntries()
+
+	entries := idx.Entries()
+	require.Len(t, entries, 3)
+
+	assert.Equal(t, "bar", entries[0].Name)
+	assert.Equal(t, "foo", entries[1].Name)
+	assert.Equal(t, "0.2.0", entries[1].Version)
+	assert.Equal(t, "2.0", entries[1].AppVersion)
+	assert.Equal(t, "sha256:0.2.0", entries[1].Digest)
+	assert.Equal(t, []string{"s3://bucket/charts/foo-0.2.0.tgz"}, entries[1].URLs)
+	assert.Equal(t, "0.1.0", entries[2].Version)
+}
+
+func TestIndexV2_SetCreated(t *testing.T) {
+	idx := newIndexV2()
+	require.NoError(t, idx.Add(&chart.Metadata{Name: "foo", Version: "0.1.0"}, "foo-0.1.0.tgz", "", "sha256:1"))
+
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, idx.SetCreated("foo", "0.1.0", created))
+	assert.Equal(t, created, idx.Entries()[0].Created)
+
+	assert.Error(t, idx.SetCreated("foo", "0.2.0", created))
+}
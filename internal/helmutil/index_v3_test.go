@@ -135,3 +135,85 @@ func TestIndexV3_UpdateGeneratedTime(t *testing.T) {
 	generatedNew := idx.index.Generated
 	assert.True(t, generatedNew.After(generatedOld), "Expected %s greater than %s", generatedNew.String(), generatedOld.String())
 }
+
+func TestIndexV3_Entries(t *testing.T) {
+	idx := newIndexV3()
+	for _, md := range []*chart.Metadata{
+		{Name: "foo", Version: "0.1.0", AppVersion: "1.0"},
+		{Name: "bar", Version: "1.0.0"},
+		{Name: "foo", Version: "0.2.0", AppVersion: "2.0"},
+	} {
+		require.NoError(t, idx.Add(md, md.Name+"-"+md.Version+".tgz", "s3://bucket/charts", "sha256:"+md.Version))
+	}
+	idx.SortEntries()
+
+	entries := idx.Entries()
+	require.Len(t, entries, 3)
+
+	assert.Equal(t, "bar", entries[0].Name)
+	assert.Equal(t, "foo", entries[1].Name)
+	assert.Equal(t, "0.2.0", entries[1].Version)
+	assert.Equal(t, "2.0", entries[1].AppVersion)
+	assert.Equal(t, "sha256:0.2.0", entries[1].Digest)
+	assert.Equal(t, []string{"s3://bucket/charts/foo-0.2.0.tgz"}, entries[1].URLs)
+	assert.False(t, entries[1].Created.IsZero())
+	assert.Equal(t, "0.1.0", entries[2].Version)
+}
+
+func TestIndexV3_SetCreated(t *testing.T) {
+	idx := newIndexV3()
+	require.NoError(t, idx.Add(&chart.Metadata{Name: "foo", Version: "0.1.0"}, "foo-0.1.0.tgz", "", "sha256:1"))
+
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, idx.SetCreated("foo", "0.1.0", created))
+	assert.Equal(t, created, idx.Entries()[0].Created)
+
+	assert.Error(t, idx.SetCreated("foo", "0.2.0", created))
+}
+
+func TestIndexV3_Delete(t *testing.T) {
+	idx := newIndexV3()
+	require.NoError(t, idx.Add(&chart.Metadata{Name: "foo", Version: "0.1.0"}, "foo-0.1.0.tgz", "s3://charts", "sha256:1"))
+	require.NoError(t, idx.Add(&chart.Metadata{Name: "foo", Version: "0.2.0"}, "foo-0.2.0.tgz", "s3://charts", "sha256:2"))
+
+	url, err := idx.Delete("foo", "0.1.0")
+	require.NoError(t, err)
+	assert.Equal(t, "s3://charts/foo-0.1.0.tgz", url)
+	assert.Len(t, idx.index.Entries["foo"], 1)
+
+	_, err = idx.Delete("foo", "0.1.0")
+	assert.Error(t, err)
+
+	t.Run("should remove the chart when no versions remain", func(t *testing.T) {
+		_, err := idx.Delete("foo", "0.2.0")
+		require.NoError(t, err)
+		assert.NotContains(t, idx.index.Entries, "foo")
+	})
+}
+
+func TestIndexV3_MarshalEntry(t *testing.T) {
+	idx := newIndexV3()
+	require.NoError(t, idx.Add(&chart.Metadata{Name: "foo", Version: "0.1.0", AppVersion: "1.0"}, "foo-0.1.0.tgz", "s3://charts", "sha256:1"))
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, idx.SetCreated("foo", "0.1.0", created))
+
+	data, err := idx.MarshalEntry("foo", "0.1.0")
+	require.NoError(t, err)
+
+	_, err = idx.MarshalEntry("foo", "0.2.0")
+	assert.Error(t, err)
+
+	assert.Error(t, idx.AddMarshaledEntry(data), "must not add an existing version")
+
+	_, err = idx.Delete("foo", "0.1.0")
+	require.NoError(t, err)
+	require.NoError(t, idx.AddMarshaledEntry(data))
+	assert.Equal(t, []IndexEntry{{
+		Name:       "foo",
+		Version:    "0.1.0",
+		AppVersion: "1.0",
+		Created:    created,
+		Digest:     "sha256:1",
+		URLs:       []string{"s3://charts/foo-0.1.0.tgz"},
+	}}, idx.Entries())
+}
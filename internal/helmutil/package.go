@@ -0,0 +1,35 @@
+package helmutil
+
+import (
+	"io"
+)
+
+// PackageOptions are options of packaging a chart directory.
+type PackageOptions struct {
+	// Version overrides the chart version, if set.
+	Version string
+
+	// AppVersion overrides the chart app version, if set.
+	AppVersion string
+
+	// DependencyUpdate updates dependencies of the chart in the charts/
+	// directory before packaging, like "helm dependency update" does.
+	DependencyUpdate bool
+
+	// SkipRefresh skips updating the local cache of repository indexes
+	// on the dependency update.
+	SkipRefresh bool
+
+	// Out receives messages of the dependency update.
+	Out io.Writer
+}
+
+// PackageChart packages the chart directory into an archive in the dest
+// directory, like "helm package" does, and returns the archive path.
+// Files matching patterns in .helmignore file are not packaged.
+func PackageChart(dir, dest string, opts PackageOptions) (string, error) {
+	if IsHelm3() {
+		return packageChartV3(dir, dest, opts)
+	}
+	return packageChartV2(dir, dest, opts)
+}
@@ -0,0 +1,93 @@
+package helmutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/downloader"
+	"k8s.io/helm/pkg/getter"
+	"k8s.io/helm/pkg/helm/environment"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+func packageChartV2(dir, dest string, opts PackageOptions) (string, error) {
+	if opts.DependencyUpdate {
+		if err := updateDependenciesV2(dir, opts.Out, opts.SkipRefresh); err != nil {
+			return "", err
+		}
+	}
+
+	ch, err := chartutil.LoadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to load chart directory: %s", err.Error())
+	}
+
+	if opts.Version != "" {
+		if _, err := semver.NewVersion(opts.Version); err != nil {
+			return "", fmt.Errorf("invalid chart version %q: %s", opts.Version, err.Error())
+		}
+		ch.Metadata.Version = opts.Version
+	}
+	if opts.AppVersion != "" {
+		ch.Metadata.AppVersion = opts.AppVersion
+	}
+
+	if err := checkDependenciesV2(ch); err != nil {
+		return "", err
+	}
+
+	fpath, err := chartutil.Save(ch, dest)
+	if err != nil {
+		return "", fmt.Errorf("failed to package chart: %s", err.Error())
+	}
+	return fpath, nil
+}
+
+func updateDependenciesV2(dir string, out io.Writer, skipRefresh bool) error {
+	man := &downloader.Manager{
+		Out:        out,
+		ChartPath:  dir,
+		HelmHome:   helm2Home,
+		Getters:    getter.All(environment.EnvSettings{Home: helm2Home}),
+		SkipUpdate: skipRefresh,
+	}
+	if err := man.Update(); err != nil {
+		return fmt.Errorf("failed to update chart dependencies: %s", err.Error())
+	}
+	return nil
+}
+
+// checkDependenciesV2 returns an error if any of the dependencies declared in
+// requirements.yaml is missing in the charts/ directory.
+func checkDependenciesV2(ch *chart.Chart) error {
+	reqs, err := chartutil.LoadRequirements(ch)
+	if errors.Is(err, chartutil.ErrRequirementsNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load chart requirements: %s", err.Error())
+	}
+
+	var missing []string
+	for _, dep := range reqs.Dependencies {
+		found := false
+		for _, sub := range ch.GetDependencies() {
+			if sub.GetMetadata().GetName() == dep.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, dep.Name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("found in requirements.yaml, but missing in charts/ directory: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
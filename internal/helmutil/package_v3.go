@@ -0,0 +1,96 @@
+package helmutil
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/downloader"
+	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/registry"
+)
+
+func packageChartV3(dir, dest string, opts PackageOptions) (string, error) {
+	if opts.DependencyUpdate {
+		if err := updateDependenciesV3(dir, opts.Out, opts.SkipRefresh); err != nil {
+			return "", err
+		}
+	}
+
+	ch, err := loader.LoadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to load chart directory: %s", err.Error())
+	}
+
+	if opts.Version != "" {
+		if _, err := semver.NewVersion(opts.Version); err != nil {
+			return "", fmt.Errorf("invalid chart version %q: %s", opts.Version, err.Error())
+		}
+		ch.Metadata.Version = opts.Version
+	}
+	if opts.AppVersion != "" {
+		ch.Metadata.AppVersion = opts.AppVersion
+	}
+
+	if err := checkDependenciesV3(ch); err != nil {
+		return "", err
+	}
+
+	fpath, err := chartutil.Save(ch, dest)
+	if err != nil {
+		return "", fmt.Errorf("failed to package chart: %s", err.Error())
+	}
+	return fpath, nil
+}
+
+func updateDependenciesV3(dir string, out io.Writer, skipRefresh bool) error {
+	registryClient, err := registry.NewClient(
+		registry.ClientOptCredentialsFile(helm3Env.RegistryConfig),
+		registry.ClientOptWriter(out),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create registry client: %s", err.Error())
+	}
+
+	man := &downloader.Manager{
+		Out:              out,
+		ChartPath:        dir,
+		Debug:            helm3Env.Debug,
+		Getters:          getter.All(helm3Env),
+		RegistryClient:   registryClient,
+		RepositoryConfig: helm3Env.RepositoryConfig,
+		RepositoryCache:  helm3Env.RepositoryCache,
+		SkipUpdate:       skipRefresh,
+	}
+	if err := man.Update(); err != nil {
+		return fmt.Errorf("failed to update chart dependencies: %s", err.Error())
+	}
+	return nil
+}
+
+// checkDependenciesV3 returns an error if any of the dependencies declared in
+// Chart.yaml is missing in the charts/ directory.
+func checkDependenciesV3(ch *chart.Chart) error {
+	var missing []string
+	for _, dep := range ch.Metadata.Dependencies {
+		found := false
+		for _, sub := range ch.Dependencies() {
+			if sub.Name() == dep.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, dep.Name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("found in Chart.yaml, but missing in charts/ directory: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
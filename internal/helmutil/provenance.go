@@ -2,6 +2,7 @@ package helmutil
 
 import (
 	"io"
+	"sort"
 )
 
 // Digest hashes a reader and returns a SHA256 digest.
@@ -19,3 +20,60 @@ func DigestFile(filename string) (string, error) {
 	}
 	return digestFileV2(filename)
 }
+
+// PassphraseFetcher returns the passphrase of the key with the name.
+type PassphraseFetcher func(name string) ([]byte, error)
+
+// Signer signs chart archives, producing provenance files.
+type Signer interface {
+	// Sign signs the chart archive and returns the provenance file content.
+	Sign(chartPath string) (string, error)
+}
+
+// NewSigner returns a signer that signs with the key from the keyring.
+// The key is looked up by its name, like "helm package --sign --key" does.
+// If the key is encrypted, it is decrypted with the passphrase returned by
+// passphrase.
+func NewSigner(keyring, key string, passphrase PassphraseFetcher) (Signer, error) {
+	if IsHelm3() {
+		return newSignerV3(keyring, key, passphrase)
+	}
+	return newSignerV2(keyring, key, passphrase)
+}
+
+// Verification describes the verified provenance of a chart.
+type Verification struct {
+	// SignedBy is the name of the key the chart is signed with.
+	SignedBy string
+
+	// Fingerprint is the fingerprint of the key the chart is signed with,
+	// in upper-case hex.
+	Fingerprint string
+
+	// FileHash is the verified chart digest, e.g. "sha256:abc...".
+	FileHash string
+}
+
+// VerifyChart verifies the chart archive against its provenance file: the
+// provenance file must be signed with a key from the keyring, and must contain
+// the digest of the archive.
+func VerifyChart(chartPath, provPath, keyring string) (Verification, error) {
+	if IsHelm3() {
+		return verifyChartV3(chartPath, provPath, keyring)
+	}
+	return verifyChartV2(chartPath, provPath, keyring)
+}
+
+// entityName returns the name of the first identity of a key, in
+// the lexicographical order for determinism.
+func entityName[T any](identities map[string]T) string {
+	names := make([]string, 0, len(identities))
+	for name := range identities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
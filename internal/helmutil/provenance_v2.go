@@ -1,6 +1,7 @@
 package helmutil
 
 import (
+	"fmt"
 	"io"
 
 	"k8s.io/helm/pkg/provenance"
@@ -13,3 +14,45 @@ func digestV2(in io.Reader) (string, error) {
 func digestFileV2(filename string) (string, error) {
 	return provenance.DigestFile(filename)
 }
+
+// signerV2 implements Signer in Helm v2.
+type signerV2 struct {
+	signatory *provenance.Signatory
+}
+
+func newSignerV2(keyring, key string, passphrase PassphraseFetcher) (signerV2, error) {
+	signatory, err := provenance.NewFromKeyring(keyring, key)
+	if err != nil {
+		return signerV2{}, fmt.Errorf("failed to load signing key: %s", err.Error())
+	}
+	if err := signatory.DecryptKey(provenance.PassphraseFetcher(passphrase)); err != nil {
+		return signerV2{}, fmt.Errorf("failed to decrypt signing key: %s", err.Error())
+	}
+	return signerV2{signatory: signatory}, nil
+}
+
+func (s signerV2) Sign(chartPath string) (string, error) {
+	prov, err := s.signatory.ClearSign(chartPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign chart: %s", err.Error())
+	}
+	return prov, nil
+}
+
+func verifyChartV2(chartPath, provPath, keyring string) (Verification, error) {
+	signatory, err := provenance.NewFromKeyring(keyring, "")
+	if err != nil {
+		return Verification{}, fmt.Errorf("failed to load keyring: %s", err.Error())
+	}
+
+	ver, err := signatory.Verify(chartPath, provPath)
+	if err != nil {
+		return Verification{}, fmt.Errorf("failed to verify chart provenance: %s", err.Error())
+	}
+
+	return Verification{
+		SignedBy:    entityName(ver.SignedBy.Identities),
+		Fingerprint: fmt.Sprintf("%X", ver.SignedBy.PrimaryKey.Fingerprint),
+		FileHash:    ver.FileHash,
+	}, nil
+}
@@ -1,6 +1,7 @@
 package helmutil
 
 import (
+	"fmt"
 	"io"
 
 	"helm.sh/helm/v3/pkg/provenance"
@@ -13,3 +14,45 @@ func digestV3(in io.Reader) (string, error) {
 func digestFileV3(filename string) (string, error) {
 	return provenance.DigestFile(filename)
 }
+
+// signerV3 implements Signer in Helm v3.
+type signerV3 struct {
+	signatory *provenance.Signatory
+}
+
+func newSignerV3(keyring, key string, passphrase PassphraseFetcher) (signerV3, error) {
+	signatory, err := provenance.NewFromKeyring(keyring, key)
+	if err != nil {
+		return signerV3{}, fmt.Errorf("failed to load signing key: %s", err.Error())
+	}
+	if err := signatory.DecryptKey(provenance.PassphraseFetcher(passphrase)); err != nil {
+		return signerV3{}, fmt.Errorf("failed to decrypt signing key: %s", err.Error())
+	}
+	return signerV3{signatory: signatory}, nil
+}
+
+func (s signerV3) Sign(chartPath string) (string, error) {
+	prov, err := s.signatory.ClearSign(chartPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign chart: %s", err.Error())
+	}
+	return prov, nil
+}
+
+func verifyChartV3(chartPath, provPath, keyring string) (Verification, error) {
+	signatory, err := provenance.NewFromKeyring(keyring, "")
+	if err != nil {
+		return Verification{}, fmt.Errorf("failed to load keyring: %s", err.Error())
+	}
+
+	ver, err := signatory.Verify(chartPath, provPath)
+	if err != nil {
+		return Verification{}, fmt.Errorf("failed to verify chart provenance: %s", err.Error())
+	}
+
+	return Verification{
+		SignedBy:    entityName(ver.SignedBy.Identities),
+		Fingerprint: fmt.Sprintf("%X", ver.SignedBy.PrimaryKey.Fingerprint),
+		FileHash:    ver.FileHash,
+	}, nil
+}
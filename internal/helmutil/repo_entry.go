@@ -44,3 +44,16 @@ func LookupRepoEntryByURL(url string) (RepoEntry, bool, error) {
 	}
 	return lookupByURLV2(url)
 }
+
+// LookupRepoEntryByChartURL returns an entry from helm's repositories.yaml
+// file for the repository the chart URL belongs to, i.e. the entry with the
+// longest repo URL that is a prefix of the chart URL. If not found, returns
+// false and <nil> error.
+// If repositories.yaml file is not found, errors.Is(err, fs.ErrNotExist) will
+// return true.
+func LookupRepoEntryByChartURL(chartURL string) (RepoEntry, bool, error) {
+	if IsHelm3() {
+		return lookupByChartURLV3(chartURL)
+	}
+	return lookupByChartURLV2(chartURL)
+}
@@ -63,3 +63,25 @@ func lookupByURLV2(url string) (RepoEntryV2, bool, error) {
 
 	return RepoEntryV2{}, false, nil
 }
+
+func lookupByChartURLV2(chartURL string) (RepoEntryV2, bool, error) {
+	repoFile, err := helm2LoadRepoFile(repoFilePathV2())
+	if err != nil {
+		return RepoEntryV2{}, false, fmt.Errorf("load repo file: %w", err)
+	}
+
+	var found RepoEntryV2
+	ok := false
+	for _, entry := range repoFile.Repositories {
+		prefix := strings.TrimSuffix(entry.URL, "/") + "/"
+		if !strings.HasPrefix(chartURL, prefix) {
+			continue
+		}
+		if !ok || len(entry.URL) > len(found.entry.URL) {
+			found = RepoEntryV2{entry: entry}
+			ok = true
+		}
+	}
+
+	return found, ok, nil
+}
@@ -60,3 +60,25 @@ func lookupByURLV3(url string) (RepoEntryV3, bool, error) {
 
 	return RepoEntryV3{}, false, nil
 }
+
+func lookupByChartURLV3(chartURL string) (RepoEntryV3, bool, error) {
+	repoFile, err := helm3LoadRepoFile(repoFilePathV3())
+	if err != nil {
+		return RepoEntryV3{}, false, fmt.Errorf("load repo file: %w", err)
+	}
+
+	var found RepoEntryV3
+	ok := false
+	for _, entry := range repoFile.Repositories {
+		prefix := strings.TrimSuffix(entry.URL, "/") + "/"
+		if !strings.HasPrefix(chartURL, prefix) {
+			continue
+		}
+		if !ok || len(entry.URL) > len(found.entry.URL) {
+			found = RepoEntryV3{entry: entry}
+			ok = true
+		}
+	}
+
+	return found, ok, nil
+}
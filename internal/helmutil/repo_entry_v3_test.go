@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"helm.sh/helm/v3/pkg/cli"
 	"helm.sh/helm/v3/pkg/repo"
 )
@@ -210,3 +211,62 @@ func TestLookupV3(t *testing.T) {
 		})
 	}
 }
+
+func TestLookupByChartURLV3(t *testing.T) {
+	helm3LoadRepoFile = func(path string) (file *repo.File, e error) {
+		return &repo.File{
+			Repositories: []*repo.Entry{
+				{
+					Name: "my-charts",
+					URL:  "s3://my-charts",
+				},
+				{
+					Name: "my-charts-stable",
+					URL:  "s3://my-charts/stable/",
+				},
+				{
+					Name: "my-charts-other",
+					URL:  "s3://my-charts-other",
+				},
+			},
+		}, nil
+	}
+	helm3Env = cli.New()
+
+	testCases := map[string]struct {
+		chartURL      string
+		expectedName  string
+		expectedFound bool
+	}{
+		"should find entry by chart URL": {
+			chartURL:      "s3://my-charts/foo-1.0.0.tgz",
+			expectedName:  "my-charts",
+			expectedFound: true,
+		},
+		"should find entry by chart URL in nested directory": {
+			chartURL:      "s3://my-charts/foo/foo-1.0.0.tgz",
+			expectedName:  "my-charts",
+			expectedFound: true,
+		},
+		"should prefer the longest matching repo URL": {
+			chartURL:      "s3://my-charts/stable/foo-1.0.0.tgz",
+			expectedName:  "my-charts-stable",
+			expectedFound: true,
+		},
+		"should not match repo URL that is not a directory prefix": {
+			chartURL:      "s3://my-charts-other2/foo-1.0.0.tgz",
+			expectedFound: false,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			entry, found, err := lookupByChartURLV3(tc.chartURL)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedFound, found)
+			if found {
+				assert.Equal(t, tc.expectedName, entry.Name())
+			}
+		})
+	}
+}
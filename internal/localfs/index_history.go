@@ -0,0 +1,120 @@
+package localfs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+// Interface guards.
+var _ storage.IndexHistory = (*Storage)(nil)
+
+// PutIndexKeeping puts the index file like PutIndex, keeping the replaced
+// index as an index.yaml.<timestamp> backup file, and removes backups beyond
+// keep.
+// uri must be in the form of file protocol: file:///path[...].
+func (s *Storage) PutIndexKeeping(ctx context.Context, uri string, acl string, r io.Reader, cond storage.Precondition, keep int) error {
+	if keep < 1 {
+		return s.PutIndex(ctx, uri, acl, r, cond)
+	}
+
+	prev, _, err := s.FetchRaw(ctx, helmutil.IndexFileURL(uri))
+	if err != nil && !errors.Is(err, storage.ErrObjectNotFound) {
+		return errors.WithMessage(err, "read index file")
+	}
+
+	if err := s.PutIndex(ctx, uri, acl, r, cond); err != nil {
+		return err
+	}
+	if prev == nil {
+		return nil
+	}
+
+	// The index is updated at this point, so the errors below must not fail
+	// the update, see storage.ErrIndexGenerationsNotKept.
+	if err := storage.PutIndexBackup(ctx, s, uri, acl, prev, time.Now()); err != nil {
+		return fmt.Errorf("%w: %w", storage.ErrIndexGenerationsNotKept, errors.WithMessage(err, "write index backup file"))
+	}
+
+	generations, err := s.IndexGenerations(ctx, uri)
+	if err != nil {
+		return fmt.Errorf("%w: %w", storage.ErrIndexGenerationsNotKept, err)
+	}
+	for _, g := range storage.ExpiredIndexGenerations(generations, keep) {
+		backupURI, _ := storage.IndexGenerationURI(uri, g.ID)
+		if err := s.Delete(ctx, backupURI); err != nil {
+			return fmt.Errorf("%w: %w", storage.ErrIndexGenerationsNotKept, errors.WithMessage(err, "delete expired index backup file"))
+		}
+	}
+
+	return nil
+}
+
+// IndexGenerations returns the current index and its backup files, newest
+// first.
+// uri must be in the form of file protocol: file:///path[...].
+func (s *Storage) IndexGenerations(ctx context.Context, uri string) ([]storage.IndexGeneration, error) {
+	path, err := parseURI(helmutil.IndexFileURL(uri))
+	if err != nil {
+		return nil, err
+	}
+
+	var generations []storage.IndexGeneration
+	info, err := os.Stat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, errors.Wrap(err, "stat index file")
+	default:
+		generations = append(generations, storage.IndexGeneration{
+			ID:           storage.CurrentIndexGeneration,
+			LastModified: info.ModTime(),
+			Size:         info.Size(),
+			Current:      true,
+		})
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, errors.Wrap(err, "read repository directory")
+	}
+	for _, entry := range entries {
+		id, t, ok := storage.ParseIndexBackupName(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, errors.Wrap(err, "stat index backup file")
+		}
+		generations = append(generations, storage.IndexGeneration{
+			ID:           id,
+			LastModified: t,
+			Size:         info.Size(),
+		})
+	}
+
+	storage.SortIndexGenerations(generations)
+	return generations, nil
+}
+
+// FetchIndexGeneration reads the current index or its backup file by id.
+// uri must be in the form of file protocol: file:///path[...].
+func (s *Storage) FetchIndexGeneration(ctx context.Context, uri, id string) ([]byte, error) {
+	genURI, ok := storage.IndexGenerationURI(uri, id)
+	if !ok {
+		return nil, storage.ErrObjectNotFound
+	}
+
+	b, _, err := s.FetchRaw(ctx, genURI)
+	return b, err
+}
@@ -0,0 +1,191 @@
+package localfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+// lockFileName is the name of the lock file that guards repository writes.
+// It resides next to the index file.
+const lockFileName = "index.yaml.lock"
+
+// TryLock makes a single attempt to acquire the lock on the repository.
+// If the repository is locked by another owner and the lock is not expired,
+// storage.ErrLockHeld is returned. An expired lock is taken over.
+// repoURI must be in the form of file protocol: file:///path[...].
+func (s *Storage) TryLock(ctx context.Context, repoURI, acl string, lock storage.Lock) error {
+	path, err := lockFilePath(repoURI)
+	if err != nil {
+		return err
+	}
+
+	current, err := readLock(path)
+	switch {
+	case err == nil:
+		if current.Owner == lock.Owner {
+			return nil
+		}
+		if !current.Expired(time.Now()) {
+			return storage.ErrLockHeld
+		}
+		// Take over the stale lock.
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return errors.Wrap(err, "remove stale lock file")
+		}
+	case errors.Is(err, storage.ErrObjectNotFound):
+		// The repository is not locked.
+	default:
+		return err
+	}
+
+	b, err := json.Marshal(lock)
+	if err != nil {
+		return errors.Wrap(err, "marshal lock")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), dirPerm); err != nil {
+		return err
+	}
+
+	// Creating the file exclusively is atomic, so only one owner can succeed.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, filePerm)
+	if errors.Is(err, fs.ErrExist) {
+		return storage.ErrLockHeld
+	}
+	if err != nil {
+		return errors.Wrap(err, "create lock file")
+	}
+
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "write lock file")
+	}
+
+	return nil
+}
+
+// Unlock releases the lock on the repository. If the lock is now owned by
+// another owner (e.g. the lease expired and the lock was taken over),
+// the lock is kept and storage.ErrLockHeld is returned.
+// repoURI must be in the form of file protocol: file:///path[...].
+func (s *Storage) Unlock(ctx context.Context, repoURI string, lock storage.Lock) error {
+	path, err := lockFilePath(repoURI)
+	if err != nil {
+		return err
+	}
+
+	current, err := readLock(path)
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if current.Owner != lock.Owner {
+		return storage.ErrLockHeld
+	}
+
+	return s.ForceUnlock(ctx, repoURI)
+}
+
+// RenewLock extends the lease of the lock on the repository to
+// lock.ExpiresAt. If the repository is not locked by the owner of the lock
+// anymore, storage.ErrLockHeld is returned.
+// repoURI must be in the form of file protocol: file:///path[...].
+func (s *Storage) RenewLock(ctx context.Context, repoURI, acl string, lock storage.Lock) error {
+	path, err := lockFilePath(repoURI)
+	if err != nil {
+		return err
+	}
+
+	current, err := readLock(path)
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return storage.ErrLockHeld
+	}
+	if err != nil {
+		return err
+	}
+	if current.Owner != lock.Owner {
+		return storage.ErrLockHeld
+	}
+
+	b, err := json.Marshal(lock)
+	if err != nil {
+		return errors.Wrap(err, "marshal lock")
+	}
+
+	// The file is replaced atomically, so readers never see a partial lock.
+	if err := writeFile(path, bytes.NewReader(b)); err != nil {
+		return errors.Wrap(err, "write lock file")
+	}
+
+	return nil
+}
+
+// ForceUnlock releases the lock on the repository regardless of its owner.
+// repoURI must be in the form of file protocol: file:///path[...].
+func (s *Storage) ForceUnlock(ctx context.Context, repoURI string) error {
+	path, err := lockFilePath(repoURI)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return errors.Wrap(err, "remove lock file")
+	}
+
+	return nil
+}
+
+// LockStatus returns the current lock on the repository.
+// If the repository is not locked, storage.ErrObjectNotFound is returned.
+// repoURI must be in the form of file protocol: file:///path[...].
+func (s *Storage) LockStatus(ctx context.Context, repoURI string) (storage.Lock, error) {
+	path, err := lockFilePath(repoURI)
+	if err != nil {
+		return storage.Lock{}, err
+	}
+
+	return readLock(path)
+}
+
+// readLock reads the lock file.
+func readLock(path string) (storage.Lock, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return storage.Lock{}, storage.ErrObjectNotFound
+	}
+	if err != nil {
+		return storage.Lock{}, errors.Wrap(err, "read lock file")
+	}
+
+	var lock storage.Lock
+	if err := json.Unmarshal(b, &lock); err != nil {
+		return storage.Lock{}, errors.Wrap(err, "unmarshal lock")
+	}
+
+	return lock, nil
+}
+
+// lockFilePath returns lock file path for the provided repository URL.
+func lockFilePath(repoURI string) (string, error) {
+	dir, err := parseURI(repoURI)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, lockFileName), nil
+}
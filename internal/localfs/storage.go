@@ -0,0 +1,456 @@
+// Package localfs implements chart repository storage on the local file system,
+// for repositories with URIs like file:///path/to/repo.
+package localfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+const (
+	// dirPerm is the permissions for directories created by the storage.
+	dirPerm = 0o755
+
+	// filePerm is the permissions for files created by the storage.
+	filePerm = 0o644
+)
+
+// Interface guards.
+var (
+	_ storage.Storage = (*Storage)(nil)
+	_ storage.Locker  = (*Storage)(nil)
+)
+
+func init() {
+	storage.Register("file", func(uri string) (storage.Storage, error) {
+		return New(), nil
+	})
+}
+
+// New returns a new Storage.
+func New() *Storage {
+	return &Storage{}
+}
+
+// Storage provides an interface to work with chart repositories located
+// in the local file system by file protocol.
+//
+// Object keys are mapped to file paths, so the repository layout is the same
+// as in S3. Since files have no user-defined metadata, the chart metadata and
+// digest are always read from the chart files themselves.
+type Storage struct{}
+
+// Traverse traverses all charts in the repository.
+// Charts are loaded one by one, so opts.Concurrency is ignored.
+func (s *Storage) Traverse(ctx context.Context, repoURI string, opts storage.TraverseOptions) (<-chan storage.ChartInfo, <-chan error) {
+	charts := make(chan storage.ChartInfo, 1)
+	errs := make(chan error, 1)
+	go s.traverse(ctx, repoURI, opts, charts, errs)
+	return charts, errs
+}
+
+// FetchRaw reads the file by uri and returns its contents, along with the ETag
+// computed from the contents.
+// uri must be in the form of file protocol: file:///path[...].
+func (s *Storage) FetchRaw(ctx context.Context, uri string) ([]byte, string, error) {
+	path, err := parseURI(uri)
+	if err != nil {
+		return nil, "", err
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, "", storage.ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, "", errors.Wrap(err, "read file")
+	}
+
+	return b, etag(b), nil
+}
+
+// Exists returns true if a file exists in the storage.
+func (s *Storage) Exists(ctx context.Context, uri string) (bool, error) {
+	path, err := parseURI(uri)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "stat file")
+	}
+
+	return true, nil
+}
+
+// ChartDigest returns empty string if the chart file exists, because files
+// have no metadata to record the digest in.
+func (s *Storage) ChartDigest(ctx context.Context, uri string) (string, error) {
+	exists, err := s.Exists(ctx, uri)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", storage.ErrObjectNotFound
+	}
+	return "", nil
+}
+
+// PutChart puts the chart file to the storage.
+// Chart metadata, digest, ACL and content type are not stored, because
+// files have no metadata.
+// uri must be in the form of file protocol: file:///path[...].
+func (s *Storage) PutChart(
+	ctx context.Context,
+	uri string,
+	r io.Reader,
+	chartMeta, acl string,
+	chartDigest string,
+	contentType string,
+	prov bool,
+	provReader io.Reader,
+) (string, error) {
+	path, err := parseURI(uri)
+	if err != nil {
+		return "", err
+	}
+
+	if err := writeFile(path, r); err != nil {
+		return "", fmt.Errorf("write chart file: %w", err)
+	}
+
+	if prov {
+		if err := writeFile(path+".prov", provReader); err != nil {
+			return "", fmt.Errorf("write prov file: %w", err)
+		}
+	}
+
+	return uri, nil
+}
+
+// PutIndex puts the index file to the storage.
+// If cond is not empty, the index is written only if the precondition holds,
+// otherwise storage.ErrPreconditionFailed is returned. Note that the IfMatch
+// precondition is checked on a best-effort basis: unlike S3, the file system
+// cannot check it atomically with the write, so use the repository lock to
+// guard concurrent writes.
+// uri must be in the form of file protocol: file:///path[...].
+func (s *Storage) PutIndex(ctx context.Context, uri string, acl string, r io.Reader, cond storage.Precondition) error {
+	if strings.HasPrefix(uri, "index.yaml") {
+		return errors.New("uri must not contain \"index.yaml\" suffix, it appends automatically")
+	}
+
+	path, err := parseURI(helmutil.IndexFileURL(uri))
+	if err != nil {
+		return err
+	}
+
+	if err := s.putConditional(path, r, cond); err != nil {
+		return errors.WithMessage(err, "write index file")
+	}
+
+	return nil
+}
+
+// PutRaw puts the file to the storage by uri.
+// The precondition is checked the same way as in PutIndex.
+// uri must be in the form of file protocol: file:///path[...].
+func (s *Storage) PutRaw(ctx context.Context, uri string, acl string, r io.Reader, cond storage.Precondition) error {
+	path, err := parseURI(uri)
+	if err != nil {
+		return err
+	}
+
+	if err := s.putConditional(path, r, cond); err != nil {
+		return errors.WithMessage(err, "write file")
+	}
+
+	return nil
+}
+
+// IndexExists returns true if index file exists in the storage for repository
+// with the provided uri.
+// uri must be in the form of file protocol: file:///path[...].
+func (s *Storage) IndexExists(ctx context.Context, uri string) (bool, error) {
+	if strings.HasPrefix(uri, "index.yaml") {
+		return false, errors.New("uri must not contain \"index.yaml\" suffix, it appends automatically")
+	}
+
+	return s.Exists(ctx, helmutil.IndexFileURL(uri))
+}
+
+// Delete deletes the file by uri. Deleting a file that does not exist is not
+// an error.
+// uri must be in the form of file protocol: file:///path[...].
+func (s *Storage) Delete(ctx context.Context, uri string) error {
+	path, err := parseURI(uri)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return errors.Wrap(err, "delete file")
+	}
+
+	return nil
+}
+
+// CopyChart copies the chart file from srcURI to dstURI, and the .prov file
+// if exists.
+// uris must be in the form of file protocol: file:///path[...].
+func (s *Storage) CopyChart(ctx context.Context, srcURI, dstURI string, acl string) error {
+	if err := copyFile(srcURI, dstURI); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return storage.ErrObjectNotFound
+		}
+		return fmt.Errorf("copy chart file: %w", err)
+	}
+
+	if err := copyFile(srcURI+".prov", dstURI+".prov"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("copy prov file: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteChart deletes the chart file by uri. Also deletes .prov file if exists.
+// uri must be in the form of file protocol: file:///path[...].
+func (s *Storage) DeleteChart(ctx context.Context, uri string) error {
+	if err := s.Delete(ctx, uri); err != nil {
+		return fmt.Errorf("delete chart file: %w", err)
+	}
+
+	if err := s.Delete(ctx, uri+".prov"); err != nil {
+		return fmt.Errorf("delete prov file: %w", err)
+	}
+
+	return nil
+}
+
+// traverse traverses all charts in the repository.
+// It writes an info item about every chart to items, and errors to errs.
+// It always closes both channels when returns.
+func (s *Storage) traverse(ctx context.Context, repoURI string, opts storage.TraverseOptions, items chan<- storage.ChartInfo, errs chan<- error) {
+	defer close(items)
+	defer close(errs)
+
+	dir, err := parseURI(repoURI)
+	if err != nil {
+		errs <- err
+		return
+	}
+
+	if _, err := os.Stat(dir); err != nil {
+		errs <- errors.Wrap(err, "read repository directory")
+		return
+	}
+
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+
+		if entry.IsDir() {
+			if path != dir && (!opts.Recursive || strings.HasPrefix(entry.Name(), ".")) {
+				// Ignore the subdirectory, because the repository layout is
+				// flat, or the directory is hidden.
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !opts.IsChartKey(key) {
+			return nil
+		}
+
+		fi, err := entry.Info()
+		if err != nil {
+			return errors.Wrap(err, "stat chart file")
+		}
+
+		info := storage.ChartInfo{
+			Filename:     key,
+			Size:         fi.Size(),
+			LastModified: fi.ModTime(),
+		}
+		if opts.Skip == nil || !opts.Skip(info) {
+			loaded, err := loadChartInfo(path)
+			if err != nil {
+				return err
+			}
+			loaded.Filename = key
+			loaded.Size, loaded.LastModified = info.Size, info.LastModified
+			loaded.Downloaded = true
+			info = loaded
+		}
+
+		items <- info
+		return nil
+	})
+	if err != nil {
+		errs <- err
+	}
+}
+
+// putConditional writes the file if the precondition holds.
+func (s *Storage) putConditional(path string, r io.Reader, cond storage.Precondition) error {
+	if cond.IfNoneMatch == "*" {
+		// Creating the file exclusively is atomic, so no concurrent writer can
+		// create the file in between.
+		if err := os.MkdirAll(filepath.Dir(path), dirPerm); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, filePerm)
+		if errors.Is(err, fs.ErrExist) {
+			return storage.ErrPreconditionFailed
+		}
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, r)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+
+	if !cond.IsZero() {
+		b, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			if cond.IfMatch != "" {
+				return storage.ErrPreconditionFailed
+			}
+		case err != nil:
+			return err
+		default:
+			current := etag(b)
+			if cond.IfMatch != "" && cond.IfMatch != current {
+				return storage.ErrPreconditionFailed
+			}
+			if cond.IfNoneMatch != "" && cond.IfNoneMatch == current {
+				return storage.ErrPreconditionFailed
+			}
+		}
+	}
+
+	return writeFile(path, r)
+}
+
+// loadChartInfo reads the chart file and returns info about it.
+func loadChartInfo(path string) (storage.ChartInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return storage.ChartInfo{}, errors.Wrap(err, "open chart file")
+	}
+	defer f.Close()
+
+	return storage.LoadChartInfo(filepath.Base(path), f)
+}
+
+// writeFile writes the file atomically: the contents are written to
+// a temporary file first, which is then renamed to the destination path.
+// Missing parent directories are created.
+func writeFile(path string, r io.Reader) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Has no effect when the file was renamed.
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(filePerm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// copyFile copies the file by srcURI to dstURI with writeFile.
+func copyFile(srcURI, dstURI string) error {
+	src, err := parseURI(srcURI)
+	if err != nil {
+		return err
+	}
+	dst, err := parseURI(dstURI)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return writeFile(dst, f)
+}
+
+// etag returns the ETag of the file contents.
+func etag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// parseURI returns file path from URIs like:
+//   - file:///path/to/dir
+//   - file:///path/to/dir/file.ext
+//   - file://localhost/path/to/dir
+func parseURI(uri string) (string, error) {
+	if !strings.HasPrefix(uri, "file://") {
+		return "", fmt.Errorf("uri %s protocol is not file", uri)
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", errors.Wrapf(err, "parse uri %s", uri)
+	}
+
+	if u.Host != "" && u.Host != "localhost" {
+		return "", fmt.Errorf("uri %s must not contain host other than localhost", uri)
+	}
+
+	if u.Path == "" {
+		return "", fmt.Errorf("uri %s has empty path", uri)
+	}
+
+	return filepath.FromSlash(u.Path), nil
+}
@@ -0,0 +1,306 @@
+package localfs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+func TestStorage_PutChart(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repoURI := "file://" + filepath.ToSlash(dir)
+
+	s := New()
+
+	uri, err := s.PutChart(
+		ctx,
+		repoURI+"/foo-1.2.3.tgz",
+		strings.NewReader("chart"),
+		"{}", "", "sha256:123", "application/gzip",
+		true,
+		strings.NewReader("prov"),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, repoURI+"/foo-1.2.3.tgz", uri)
+
+	b, err := os.ReadFile(filepath.Join(dir, "foo-1.2.3.tgz"))
+	require.NoError(t, err)
+	assert.Equal(t, "chart", string(b))
+
+	b, err = os.ReadFile(filepath.Join(dir, "foo-1.2.3.tgz.prov"))
+	require.NoError(t, err)
+	assert.Equal(t, "prov", string(b))
+
+	exists, err := s.Exists(ctx, repoURI+"/foo-1.2.3.tgz")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	err = s.DeleteChart(ctx, repoURI+"/foo-1.2.3.tgz")
+	require.NoError(t, err)
+
+	assert.NoFileExists(t, filepath.Join(dir, "foo-1.2.3.tgz"))
+	assert.NoFileExists(t, filepath.Join(dir, "foo-1.2.3.tgz.prov"))
+}
+
+func TestStorage_PutIndex(t *testing.T) {
+	ctx := context.Background()
+	repoURI := "file://" + filepath.ToSlash(t.TempDir())
+
+	s := New()
+
+	t.Run("should create index only if it does not exist", func(t *testing.T) {
+		err := s.PutIndex(ctx, repoURI, "", strings.NewReader("v1"), storage.Precondition{IfNoneMatch: "*"})
+		require.NoError(t, err)
+
+		err = s.PutIndex(ctx, repoURI, "", strings.NewReader("v2"), storage.Precondition{IfNoneMatch: "*"})
+		assert.ErrorIs(t, err, storage.ErrPreconditionFailed)
+	})
+
+	t.Run("should replace index only if it was not modified", func(t *testing.T) {
+		b, etag, err := s.FetchRaw(ctx, repoURI+"/index.yaml")
+		require.NoError(t, err)
+		assert.Equal(t, "v1", string(b))
+
+		err = s.PutIndex(ctx, repoURI, "", strings.NewReader("v2"), storage.Precondition{IfMatch: etag})
+		require.NoError(t, err)
+
+		err = s.PutIndex(ctx, repoURI, "", strings.NewReader("v3"), storage.Precondition{IfMatch: etag})
+		assert.ErrorIs(t, err, storage.ErrPreconditionFailed)
+
+		b, _, err = s.FetchRaw(ctx, repoURI+"/index.yaml")
+		require.NoError(t, err)
+		assert.Equal(t, "v2", string(b))
+	})
+
+	t.Run("should replace index unconditionally", func(t *testing.T) {
+		err := s.PutIndex(ctx, repoURI, "", strings.NewReader("v4"), storage.Precondition{})
+		require.NoError(t, err)
+
+		exists, err := s.IndexExists(ctx, repoURI)
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+}
+
+func TestStorage_PutIndexKeeping(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repoURI := "file://" + filepath.ToSlash(dir)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.yaml.settings"), []byte("{}"), filePerm))
+
+	s := New()
+	for _, content := range []string{"v1", "v2", "v3", "v4"} {
+		err := s.PutIndexKeeping(ctx, repoURI, "", strings.NewReader(content), storage.Precondition{}, 2)
+		require.NoError(t, err)
+	}
+
+	generations, err := s.IndexGenerations(ctx, repoURI)
+	require.NoError(t, err)
+	require.Len(t, generations, 3, "the current index and two backups")
+	assert.Equal(t, storage.CurrentIndexGeneration, generations[0].ID)
+
+	var contents []string
+	for _, g := range generations {
+		b, err := s.FetchIndexGeneration(ctx, repoURI, g.ID)
+		require.NoError(t, err)
+		contents = append(contents, string(b))
+	}
+	assert.Equal(t, []string{"v4", "v3", "v2"}, contents)
+	assert.FileExists(t, filepath.Join(dir, "index.yaml.settings"), "other files must be kept")
+
+	_, err = s.FetchIndexGeneration(ctx, repoURI, "unknown")
+	assert.ErrorIs(t, err, storage.ErrObjectNotFound)
+}
+
+func TestStorage_FetchRaw_NotFound(t *testing.T) {
+	repoURI := "file://" + filepath.ToSlash(t.TempDir())
+
+	_, _, err := New().FetchRaw(context.Background(), repoURI+"/index.yaml")
+	assert.ErrorIs(t, err, storage.ErrObjectNotFound)
+}
+
+func TestStorage_ChartDigest(t *testing.T) {
+	dir := t.TempDir()
+	repoURI := "file://" + filepath.ToSlash(dir)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "foo-0.1.0.tgz"), []byte("chart"), filePerm))
+
+	digest, err := New().ChartDigest(context.Background(), repoURI+"/foo-0.1.0.tgz")
+	require.NoError(t, err)
+	assert.Empty(t, digest, "files have no recorded digest")
+
+	_, err = New().ChartDigest(context.Background(), repoURI+"/bar-0.1.0.tgz")
+	assert.ErrorIs(t, err, storage.ErrObjectNotFound)
+}
+
+func TestStorage_CopyChart(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repoURI := "file://" + filepath.ToSlash(dir)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "foo-0.1.0.tgz"), []byte("chart"), filePerm))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "foo-0.1.0.tgz.prov"), []byte("prov"), filePerm))
+
+	err := New().CopyChart(ctx, repoURI+"/foo-0.1.0.tgz", repoURI+"/.trash/foo-0.1.0.tgz", "")
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(filepath.Join(dir, ".trash", "foo-0.1.0.tgz"))
+	require.NoError(t, err)
+	assert.Equal(t, "chart", string(b))
+	b, err = os.ReadFile(filepath.Join(dir, ".trash", "foo-0.1.0.tgz.prov"))
+	require.NoError(t, err)
+	assert.Equal(t, "prov", string(b))
+	assert.FileExists(t, filepath.Join(dir, "foo-0.1.0.tgz"), "the source must be kept")
+
+	err = New().CopyChart(ctx, repoURI+"/bar-0.1.0.tgz", repoURI+"/.trash/bar-0.1.0.tgz", "")
+	assert.ErrorIs(t, err, storage.ErrObjectNotFound)
+}
+
+func TestStorage_Traverse(t *testing.T) {
+	t.Setenv("HELM_S3_MODE", "3")
+
+	dir := t.TempDir()
+	for _, version := range []string{"0.1.0", "0.2.0"} {
+		_, err := chartutil.Save(&chart.Chart{
+			Metadata: &chart.Metadata{
+				APIVersion: chart.APIVersionV2,
+				Name:       "foo",
+				Version:    version,
+			},
+		}, dir)
+		require.NoError(t, err)
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.yaml"), []byte("{}"), filePerm))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "nested"), dirPerm))
+
+	items, errs := New().Traverse(context.Background(), "file://"+filepath.ToSlash(dir), storage.TraverseOptions{})
+
+	var filenames []string
+	for item := range items {
+		filenames = append(filenames, item.Filename)
+		assert.Len(t, item.Hash, 64)
+		assert.NotNil(t, item.Meta)
+	}
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{"foo-0.1.0.tgz", "foo-0.2.0.tgz"}, filenames)
+}
+
+func TestStorage_Traverse_Recursive(t *testing.T) {
+	t.Setenv("HELM_S3_MODE", "3")
+
+	dir := t.TempDir()
+	for _, sub := range []string{"foo", ".trash", ""} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, sub), dirPerm))
+		_, err := chartutil.Save(&chart.Chart{
+			Metadata: &chart.Metadata{
+				APIVersion: chart.APIVersionV2,
+				Name:       "foo",
+				Version:    "0.1.0",
+			},
+		}, filepath.Join(dir, sub))
+		require.NoError(t, err)
+	}
+
+	items, errs := New().Traverse(context.Background(), "file://"+filepath.ToSlash(dir), storage.TraverseOptions{Recursive: true})
+
+	var filenames []string
+	for item := range items {
+		filenames = append(filenames, item.Filename)
+	}
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{"foo/foo-0.1.0.tgz", "foo-0.1.0.tgz"}, filenames)
+}
+
+func TestStorage_TryLock(t *testing.T) {
+	ctx := context.Background()
+	repoURI := "file://" + filepath.ToSlash(t.TempDir())
+
+	s := New()
+
+	first := storage.NewLock(time.Minute)
+	second := storage.NewLock(time.Minute)
+
+	require.NoError(t, s.TryLock(ctx, repoURI, "", first))
+	assert.ErrorIs(t, s.TryLock(ctx, repoURI, "", second), storage.ErrLockHeld)
+
+	current, err := s.LockStatus(ctx, repoURI)
+	require.NoError(t, err)
+	assert.Equal(t, first.Owner, current.Owner)
+
+	assert.ErrorIs(t, s.Unlock(ctx, repoURI, second), storage.ErrLockHeld)
+	require.NoError(t, s.Unlock(ctx, repoURI, first))
+
+	_, err = s.LockStatus(ctx, repoURI)
+	assert.ErrorIs(t, err, storage.ErrObjectNotFound)
+
+	t.Run("should take over stale lock", func(t *testing.T) {
+		stale := storage.NewLock(-time.Second)
+		require.NoError(t, s.TryLock(ctx, repoURI, "", stale))
+		require.NoError(t, s.TryLock(ctx, repoURI, "", second))
+
+		current, err := s.LockStatus(ctx, repoURI)
+		require.NoError(t, err)
+		assert.Equal(t, second.Owner, current.Owner)
+	})
+}
+
+func TestStorage_RenewLock(t *testing.T) {
+	ctx := context.Background()
+	repoURI := "file://" + filepath.ToSlash(t.TempDir())
+
+	s := New()
+
+	lock := storage.NewLock(time.Second)
+	other := storage.NewLock(time.Minute)
+	assert.ErrorIs(t, s.RenewLock(ctx, repoURI, "", lock), storage.ErrLockHeld, "the lock is not acquired")
+
+	require.NoError(t, s.TryLock(ctx, repoURI, "", lock))
+	renewed := lock.Renewed(time.Hour)
+	require.NoError(t, s.RenewLock(ctx, repoURI, "", renewed))
+
+	current, err := s.LockStatus(ctx, repoURI)
+	require.NoError(t, err)
+	assert.True(t, renewed.ExpiresAt.Equal(current.ExpiresAt))
+	assert.ErrorIs(t, s.RenewLock(ctx, repoURI, "", other), storage.ErrLockHeld)
+}
+
+func TestParseURI(t *testing.T) {
+	testCases := map[string]struct {
+		uri     string
+		path    string
+		wantErr bool
+	}{
+		"absolute path":  {uri: "file:///tmp/charts", path: filepath.FromSlash("/tmp/charts")},
+		"localhost":      {uri: "file://localhost/tmp/charts", path: filepath.FromSlash("/tmp/charts")},
+		"other host":     {uri: "file://example.com/tmp/charts", wantErr: true},
+		"other protocol": {uri: "s3://bucket/charts", wantErr: true},
+		"empty path":     {uri: "file://", wantErr: true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			path, err := parseURI(tc.uri)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.path, path)
+		})
+	}
+}
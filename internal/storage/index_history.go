@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CurrentIndexGeneration is the ID of the current generation of the index in
+// storages that keep previous generations as backup objects.
+const CurrentIndexGeneration = "current"
+
+// indexBackupPrefix is the prefix of names of index backup objects. The
+// backup time follows the prefix, see IndexBackupName.
+const indexBackupPrefix = "index.yaml."
+
+// indexBackupTimeFormat is the format of the backup time in names of index
+// backup objects. Milliseconds keep names of backups made in quick
+// succession distinct.
+const indexBackupTimeFormat = "20060102T150405.000Z"
+
+// ErrIndexGenerationsNotKept signals that IndexHistory.PutIndexKeeping has
+// put the index, but failed to back up the replaced generation or to remove
+// expired ones. The index update itself succeeded.
+var ErrIndexGenerationsNotKept = errors.New("index is updated, but its previous generations are not kept")
+
+// IndexHistory is implemented by storages that can keep previous generations
+// of the index, so that the index can be rolled back.
+//
+// Previous generations are kept as versions of the index object if the
+// storage keeps versions of objects, e.g. S3 buckets with versioning enabled,
+// and as index.yaml.<timestamp> backup objects next to the index otherwise.
+// Object versions are never deleted: their expiry is left to the storage,
+// e.g. to bucket lifecycle rules.
+type IndexHistory interface {
+	// PutIndexKeeping puts the index file like Storage.PutIndex, keeping up
+	// to keep previous generations of the index; older backup objects are
+	// removed. If keep is less than 1, it is the same as Storage.PutIndex.
+	// If the index was put, but the previous generations were not kept,
+	// returns an error wrapping ErrIndexGenerationsNotKept.
+	PutIndexKeeping(ctx context.Context, uri string, acl string, r io.Reader, cond Precondition, keep int) error
+
+	// IndexGenerations returns generations of the index of the repository
+	// with the provided uri, newest first, including the current one.
+	IndexGenerations(ctx context.Context, uri string) ([]IndexGeneration, error)
+
+	// FetchIndexGeneration downloads the generation of the index of the
+	// repository with the provided uri. If the generation does not exist,
+	// returns ErrObjectNotFound.
+	FetchIndexGeneration(ctx context.Context, uri, id string) ([]byte, error)
+}
+
+// IndexGeneration describes a generation of the index.
+type IndexGeneration struct {
+	// ID is the object version ID of the generation, the backup time for
+	// generations kept as backup objects, or CurrentIndexGeneration.
+	ID string `json:"id"`
+
+	// LastModified is the time the generation was stored: the time it was
+	// written for object versions, and the time it was replaced for backup
+	// objects.
+	LastModified time.Time `json:"lastModified"`
+	Size         int64     `json:"size"`
+
+	// Current is true for the current generation of the index.
+	Current bool `json:"current"`
+}
+
+// IndexBackupName returns the name of the index backup object made at t.
+func IndexBackupName(t time.Time) string {
+	return indexBackupPrefix + t.UTC().Format(indexBackupTimeFormat)
+}
+
+// PutIndexBackup uploads the replaced index as a backup object of the
+// repository with the provided uri, named after the backup time t. If a backup
+// with the same name exists, e.g. one made within the same millisecond, the
+// next free name is taken.
+func PutIndexBackup(ctx context.Context, s Storage, repoURI, acl string, data []byte, t time.Time) error {
+	const maxAttempts = 100
+	for i := range maxAttempts {
+		uri := strings.TrimSuffix(repoURI, "/") + "/" + IndexBackupName(t.Add(time.Duration(i)*time.Millisecond))
+		err := s.PutRaw(ctx, uri, acl, bytes.NewReader(data), Precondition{IfNoneMatch: "*"})
+		if !errors.Is(err, ErrPreconditionFailed) {
+			return err
+		}
+	}
+	return errors.Errorf("no free index backup name after %d attempts", maxAttempts)
+}
+
+// ParseIndexBackupName returns the ID and the time of the index backup by the
+// object name. Returns false if the name is not of an index backup, e.g. it
+// is the name of the repository settings file.
+func ParseIndexBackupName(name string) (id string, t time.Time, ok bool) {
+	id, ok = strings.CutPrefix(name, indexBackupPrefix)
+	if !ok {
+		return "", time.Time{}, false
+	}
+	t, err := time.Parse(indexBackupTimeFormat, id)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return id, t, true
+}
+
+// IndexGenerationURI returns the URI of the object of the index generation
+// kept as a backup object, or of the index itself for CurrentIndexGeneration.
+// Returns false if id is neither, e.g. it is an object version ID.
+func IndexGenerationURI(repoURI, id string) (string, bool) {
+	repoURI = strings.TrimSuffix(repoURI, "/")
+	if id == CurrentIndexGeneration {
+		return repoURI + "/index.yaml", true
+	}
+	if _, err := time.Parse(indexBackupTimeFormat, id); err != nil {
+		return "", false
+	}
+	return repoURI + "/" + indexBackupPrefix + id, true
+}
+
+// SortIndexGenerations sorts the generations newest first. The current
+// generation always comes first.
+func SortIndexGenerations(generations []IndexGeneration) {
+	sort.SliceStable(generations, func(i, j int) bool {
+		if generations[i].Current != generations[j].Current {
+			return generations[i].Current
+		}
+		return generations[i].LastModified.After(generations[j].LastModified)
+	})
+}
+
+// IndexBackups returns the generations kept as backup objects, excluding the
+// current generation and object versions.
+func IndexBackups(generations []IndexGeneration) []IndexGeneration {
+	var backups []IndexGeneration
+	for _, g := range generations {
+		if _, _, ok := ParseIndexBackupName(indexBackupPrefix + g.ID); ok {
+			backups = append(backups, g)
+		}
+	}
+	return backups
+}
+
+// ExpiredIndexGenerations returns previous generations beyond the keep
+// newest ones. The generations must be sorted, see SortIndexGenerations.
+func ExpiredIndexGenerations(generations []IndexGeneration, keep int) []IndexGeneration {
+	var expired []IndexGeneration
+	kept := 0
+	for _, g := range generations {
+		if g.Current {
+			continue
+		}
+		if kept < keep {
+			kept++
+			continue
+		}
+		expired = append(expired, g)
+	}
+	return expired
+}
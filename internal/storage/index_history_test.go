@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIndexBackupName(t *testing.T) {
+	backupTime := time.Date(2024, 3, 2, 10, 0, 0, 123e6, time.UTC)
+
+	id, parsed, ok := ParseIndexBackupName(IndexBackupName(backupTime))
+	assert.True(t, ok)
+	assert.Equal(t, "20240302T100000.123Z", id)
+	assert.True(t, backupTime.Equal(parsed))
+
+	for _, name := range []string{"index.yaml", "index.yaml.settings", "index.yaml.lock", "foo-1.0.0.tgz"} {
+		_, _, ok := ParseIndexBackupName(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestExpiredIndexGenerations(t *testing.T) {
+	now := time.Now()
+	generations := []IndexGeneration{
+		{ID: "d", LastModified: now.Add(-3 * time.Hour)},
+		{ID: "current", LastModified: now, Current: true},
+		{ID: "b", LastModified: now.Add(-time.Hour)},
+		{ID: "c", LastModified: now.Add(-2 * time.Hour)},
+	}
+	SortIndexGenerations(generations)
+
+	ids := func(generations []IndexGeneration) []string {
+		var ids []string
+		for _, g := range generations {
+			ids = append(ids, g.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []string{"current", "b", "c", "d"}, ids(generations))
+	assert.Equal(t, []string{"c", "d"}, ids(ExpiredIndexGenerations(generations, 1)))
+	assert.Empty(t, ExpiredIndexGenerations(generations, 3))
+}
+
+func TestIndexBackups(t *testing.T) {
+	now := time.Now()
+	backup := IndexGeneration{ID: now.UTC().Format(indexBackupTimeFormat), LastModified: now}
+	generations := []IndexGeneration{
+		{ID: CurrentIndexGeneration, LastModified: now, Current: true},
+		{ID: "Wq4dA3JKQ.dpd6fhNp9hUvZh_bBBU0Ia", LastModified: now.Add(-time.Hour)},
+		backup,
+	}
+
+	assert.Equal(t, []IndexGeneration{backup}, IndexBackups(generations))
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"sync"
+)
+
+// LoadFunc loads info about a single chart.
+type LoadFunc func(ctx context.Context) (ChartInfo, error)
+
+// LoadOrdered calls every load function received from loads, running up to
+// concurrency functions at once, and sends the results to items in the order
+// the functions were received. It returns when loads is closed and all
+// results are sent, or on the first error. In the latter case the context
+// passed to the functions still running is canceled.
+//
+// The caller must close loads, or stop sending to it once ctx is done.
+func LoadOrdered(ctx context.Context, concurrency int, loads <-chan LoadFunc, items chan<- ChartInfo) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		info ChartInfo
+		err  error
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	// pending holds result channels in the order the loads were received,
+	// while sem limits the number of loads in flight.
+	pending := make(chan chan result, concurrency)
+	sem := make(chan struct{}, concurrency)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(pending)
+
+		for {
+			var load LoadFunc
+			select {
+			case <-ctx.Done():
+				return
+			case l, ok := <-loads:
+				if !ok {
+					return
+				}
+				load = l
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case sem <- struct{}{}:
+			}
+
+			res := make(chan result, 1)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				info, err := load(ctx)
+				res <- result{info: info, err: err}
+			}()
+
+			select {
+			case <-ctx.Done():
+				return
+			case pending <- res:
+			}
+		}
+	}()
+
+	for res := range pending {
+		r := <-res
+		if r.err != nil {
+			cancel()
+			return r.err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case items <- r.info:
+		}
+	}
+
+	return ctx.Err()
+}
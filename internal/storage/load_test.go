@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadOrdered(t *testing.T) {
+	const total = 50
+
+	var inFlight, maxInFlight atomic.Int32
+
+	loads := make(chan LoadFunc)
+	go func() {
+		defer close(loads)
+		for i := range total {
+			loads <- func(ctx context.Context) (ChartInfo, error) {
+				n := inFlight.Add(1)
+				defer inFlight.Add(-1)
+				for {
+					m := maxInFlight.Load()
+					if n <= m || maxInFlight.CompareAndSwap(m, n) {
+						break
+					}
+				}
+
+				// Finish loads out of order.
+				time.Sleep(time.Duration(rand.IntN(1000)) * time.Microsecond) //nolint:gosec // No need for secure random here.
+				return ChartInfo{Filename: string(rune('a' + i))}, nil
+			}
+		}
+	}()
+
+	items := make(chan ChartInfo, total)
+	err := LoadOrdered(context.Background(), 4, loads, items)
+	require.NoError(t, err)
+	close(items)
+
+	var i int
+	for item := range items {
+		assert.Equal(t, string(rune('a'+i)), item.Filename)
+		i++
+	}
+	assert.Equal(t, total, i)
+	assert.LessOrEqual(t, maxInFlight.Load(), int32(4))
+	assert.Greater(t, maxInFlight.Load(), int32(1))
+}
+
+func TestLoadOrdered_Error(t *testing.T) {
+	errLoad := errors.New("load failed")
+
+	var canceled atomic.Bool
+	started := make(chan struct{})
+
+	loads := make(chan LoadFunc, 3)
+	loads <- func(ctx context.Context) (ChartInfo, error) {
+		return ChartInfo{Filename: "first"}, nil
+	}
+	loads <- func(ctx context.Context) (ChartInfo, error) {
+		<-started
+		return ChartInfo{}, errLoad
+	}
+	loads <- func(ctx context.Context) (ChartInfo, error) {
+		close(started)
+		<-ctx.Done()
+		canceled.Store(true)
+		return ChartInfo{}, ctx.Err()
+	}
+	// loads is intentionally left open: LoadOrdered must return anyway.
+
+	items := make(chan ChartInfo, 3)
+	err := LoadOrdered(context.Background(), 3, loads, items)
+	require.ErrorIs(t, err, errLoad)
+	close(items)
+
+	var filenames []string
+	for item := range items {
+		filenames = append(filenames, item.Filename)
+	}
+	assert.Equal(t, []string{"first"}, filenames)
+	assert.True(t, canceled.Load())
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// ErrLockHeld signals that the repository is locked by another owner.
+var ErrLockHeld = errors.New("repository is locked by another owner")
+
+// Locker is implemented by storages that support the repository lock.
+//
+// The lock is used to guard repository writes on backends that do not honor
+// conditional writes.
+type Locker interface {
+	// TryLock makes a single attempt to acquire the lock on the repository.
+	// If the repository is locked by another owner and the lock is not
+	// expired, ErrLockHeld is returned. An expired lock is taken over.
+	TryLock(ctx context.Context, repoURI, acl string, lock Lock) error
+
+	// Unlock releases the lock on the repository. If the lock is now owned
+	// by another owner (e.g. the lease expired and the lock was taken over),
+	// the lock is kept and ErrLockHeld is returned.
+	Unlock(ctx context.Context, repoURI string, lock Lock) error
+
+	// RenewLock extends the lease of the lock on the repository to
+	// lock.ExpiresAt. If the repository is not locked by the owner of the lock
+	// anymore (e.g. the lock was released forcibly, or the lease expired and
+	// the lock was taken over), ErrLockHeld is returned.
+	RenewLock(ctx context.Context, repoURI, acl string, lock Lock) error
+
+	// ForceUnlock releases the lock on the repository regardless of its owner.
+	ForceUnlock(ctx context.Context, repoURI string) error
+
+	// LockStatus returns the current lock on the repository.
+	// If the repository is not locked, ErrObjectNotFound is returned.
+	LockStatus(ctx context.Context, repoURI string) (Lock, error)
+}
+
+// Lock describes a lease lock on the repository.
+type Lock struct {
+	// Owner is a unique identifier of the lock owner.
+	Owner string `json:"owner"`
+
+	// Hostname is the name of the host where the owner process runs.
+	Hostname string `json:"hostname"`
+
+	// PID is the process ID of the owner process.
+	PID int `json:"pid"`
+
+	// AcquiredAt is the time when the lock was acquired.
+	AcquiredAt time.Time `json:"acquiredAt"`
+
+	// ExpiresAt is the time when the lock lease expires. An expired lock is
+	// considered stale and can be taken over by another owner.
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// NewLock returns a new lock owned by the current process with the lease
+// valid for ttl.
+func NewLock(ttl time.Duration) Lock {
+	hostname, _ := os.Hostname()
+	now := time.Now().UTC()
+
+	return Lock{
+		Owner:      uuid.NewString(),
+		Hostname:   hostname,
+		PID:        os.Getpid(),
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+}
+
+// Renewed returns the lock with the lease extended to ttl from now.
+func (l Lock) Renewed(ttl time.Duration) Lock {
+	l.ExpiresAt = time.Now().UTC().Add(ttl)
+	return l
+}
+
+// Expired returns true if the lock lease is expired at the given time.
+func (l Lock) Expired(now time.Time) bool {
+	return !now.Before(l.ExpiresAt)
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// Factory creates a storage for the repository with the provided uri.
+type Factory func(uri string) (Storage, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a storage factory available for URIs with the provided
+// scheme, e.g. "s3". If Register is called twice with the same scheme,
+// it panics.
+func Register(scheme string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("storage: Register factory is nil")
+	}
+	if _, dup := factories[scheme]; dup {
+		panic("storage: Register called twice for scheme " + scheme)
+	}
+	factories[scheme] = factory
+}
+
+// New returns a storage for the repository with the provided uri, using the
+// factory registered for the uri scheme.
+func New(uri string) (Storage, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("parse uri %s: %w", uri, err)
+	}
+
+	factoriesMu.RLock()
+	factory, ok := factories[u.Scheme]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("uri %s protocol %q is not supported, supported protocols: %v", uri, u.Scheme, Schemes())
+	}
+
+	return factory(uri)
+}
+
+// Schemes returns a sorted list of the registered schemes.
+func Schemes() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	schemes := make([]string, 0, len(factories))
+	for scheme := range factories {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
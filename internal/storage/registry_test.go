@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// registryTestStorage is a no-op storage for testing the registry.
+type registryTestStorage struct {
+	Storage
+
+	uri string
+}
+
+func TestNew(t *testing.T) {
+	Register("registry-test", func(uri string) (Storage, error) {
+		return registryTestStorage{uri: uri}, nil
+	})
+
+	t.Run("should use factory registered for the scheme", func(t *testing.T) {
+		s, err := New("registry-test://bucket/charts")
+		require.NoError(t, err)
+
+		assert.Equal(t, registryTestStorage{uri: "registry-test://bucket/charts"}, s)
+	})
+
+	t.Run("should fail on unknown scheme", func(t *testing.T) {
+		_, err := New("unknown://bucket/charts")
+		assert.ErrorContains(t, err, `protocol "unknown" is not supported`)
+	})
+
+	t.Run("should fail on URI without scheme", func(t *testing.T) {
+		_, err := New("bucket/charts")
+		assert.Error(t, err)
+	})
+}
+
+func TestRegister(t *testing.T) {
+	factory := func(uri string) (Storage, error) {
+		return nil, nil
+	}
+
+	Register("registry-test-dup", factory)
+
+	assert.Panics(t, func() {
+		Register("registry-test-dup", factory)
+	})
+	assert.Contains(t, Schemes(), "registry-test-dup")
+}
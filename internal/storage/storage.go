@@ -0,0 +1,211 @@
+// Package storage defines the interface of chart repository storage backends.
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+)
+
+var (
+	// ErrBucketNotFound signals that a bucket was not found.
+	ErrBucketNotFound = errors.New("bucket not found")
+
+	// ErrObjectNotFound signals that an object was not found.
+	ErrObjectNotFound = errors.New("object not found")
+
+	// ErrPreconditionFailed signals that a conditional write was rejected,
+	// because the object was modified since it was fetched.
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+// Storage describes a chart repository storage.
+//
+// All uri arguments are full URIs including the scheme the storage is
+// registered for, e.g. s3://bucket-name/key[...].
+type Storage interface {
+	// Traverse traverses all charts in the repository.
+	// It sends an info item about every chart to the first channel, and errors
+	// to the second one. Both channels are closed when the traversal is over.
+	// Items are sent in the same order regardless of opts.Concurrency.
+	Traverse(ctx context.Context, repoURI string, opts TraverseOptions) (<-chan ChartInfo, <-chan error)
+
+	// FetchRaw downloads the object from uri and returns it in the form of
+	// byte slice, along with the object ETag. The ETag can be used as
+	// a precondition for subsequent writes of the same object.
+	FetchRaw(ctx context.Context, uri string) ([]byte, string, error)
+
+	// Exists returns true if an object exists in the storage.
+	Exists(ctx context.Context, uri string) (bool, error)
+
+	// ChartDigest returns the digest of the chart object by uri recorded on
+	// push, see PutChart. If the storage does not record digests, or the
+	// chart object has no digest recorded, e.g. it was uploaded manually,
+	// returns empty string. If the object does not exist, returns
+	// ErrObjectNotFound.
+	ChartDigest(ctx context.Context, uri string) (string, error)
+
+	// PutChart puts the chart file to the storage, along with the provenance
+	// file if prov is true. Returns the URL of the uploaded chart object.
+	// Storages that support object metadata record the upload time as the
+	// chart push time, see ChartInfo.Created.
+	PutChart(
+		ctx context.Context,
+		uri string,
+		r io.Reader,
+		chartMeta, acl string,
+		chartDigest string,
+		contentType string,
+		prov bool,
+		provReader io.Reader,
+	) (string, error)
+
+	// PutIndex puts the index file to the storage for repository with
+	// the provided uri. If cond is not empty, the index is written only if
+	// the precondition holds, otherwise ErrPreconditionFailed is returned.
+	PutIndex(ctx context.Context, uri string, acl string, r io.Reader, cond Precondition) error
+
+	// IndexExists returns true if index file exists in the storage for
+	// repository with the provided uri.
+	IndexExists(ctx context.Context, uri string) (bool, error)
+
+	// PutRaw puts an arbitrary object to the storage by uri, e.g. a file
+	// accompanying the index. If cond is not empty, the object is written only
+	// if the precondition holds, otherwise ErrPreconditionFailed is returned.
+	PutRaw(ctx context.Context, uri string, acl string, r io.Reader, cond Precondition) error
+
+	// CopyChart copies the chart object from srcURI to dstURI along with its
+	// metadata, and the .prov file if exists. Storages that support it copy
+	// the objects without downloading them. The source may be in another
+	// bucket of the same storage. If the chart object does not exist,
+	// returns ErrObjectNotFound.
+	CopyChart(ctx context.Context, srcURI, dstURI string, acl string) error
+
+	// Delete deletes the object by uri. Deleting an object that does not
+	// exist is not an error.
+	Delete(ctx context.Context, uri string) error
+
+	// DeleteChart deletes the chart object by uri. Also deletes .prov file
+	// if exists.
+	DeleteChart(ctx context.Context, uri string) error
+}
+
+// TraverseOptions configures the repository traversal.
+type TraverseOptions struct {
+	// Concurrency is the maximum number of charts loaded concurrently.
+	// Values less than 1 mean that charts are loaded one by one.
+	Concurrency int
+
+	// Recursive makes the traversal include charts in nested directories
+	// (key prefixes) of the repository, e.g. charts/foo/foo-1.0.0.tgz.
+	// Directories with names starting with a dot are not traversed.
+	// Filename of such charts is relative to the repository root,
+	// e.g. foo/foo-1.0.0.tgz.
+	Recursive bool
+
+	// Skip, if set, is called for every chart object before the chart is
+	// loaded, with the info having only Filename, Size and LastModified set.
+	// If it returns true, the chart is not loaded, and the info is sent
+	// as is, without Meta and Hash.
+	Skip func(info ChartInfo) bool
+}
+
+// IsChartKey returns true if the key relative to the repository root refers
+// to a chart that should be traversed with the options.
+func (o TraverseOptions) IsChartKey(key string) bool {
+	if !strings.HasSuffix(key, ".tgz") {
+		// Ignore any file that isn't a chart. This could include index.yaml
+		// or any other kind of file that might be in the repo.
+		return false
+	}
+
+	dirs := strings.Split(key, "/")
+	dirs = dirs[:len(dirs)-1]
+	if len(dirs) == 0 {
+		return true
+	}
+	if !o.Recursive {
+		// Ignore charts in nested directories, because the repository
+		// layout is flat.
+		return false
+	}
+	for _, dir := range dirs {
+		if dir == "" || strings.HasPrefix(dir, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+// ChartInfo contains info about particular chart.
+type ChartInfo struct {
+	Meta     helmutil.ChartMetadata
+	Filename string
+	Hash     string
+
+	// Size is the size of the chart object in bytes.
+	Size int64
+
+	// LastModified is the time the chart object was last modified.
+	LastModified time.Time
+
+	// Created is the time the chart was originally pushed, as recorded by
+	// PutChart. It is zero if unknown, e.g. for charts uploaded manually.
+	Created time.Time
+
+	// Downloaded is true if the chart file had to be downloaded to obtain
+	// its metadata and digest, because they were not found in the object
+	// metadata.
+	Downloaded bool
+}
+
+// LoadChartInfo loads the chart archive from r and returns info about it,
+// including the chart metadata and digest.
+//
+// This is used when the chart metadata cannot be obtained in a cheaper way,
+// e.g. from the object metadata, and the chart file itself has to be read.
+func LoadChartInfo(filename string, r io.Reader) (ChartInfo, error) {
+	buf := &bytes.Buffer{}
+	tr := io.TeeReader(r, buf)
+
+	ch, err := helmutil.LoadArchive(tr)
+	if err != nil {
+		return ChartInfo{}, fmt.Errorf("load archive from %q: %s", filename, err)
+	}
+
+	digest, err := helmutil.Digest(buf)
+	if err != nil {
+		return ChartInfo{}, fmt.Errorf("get chart hash for %q: %s", filename, err)
+	}
+
+	return ChartInfo{
+		Meta:     ch.Metadata(),
+		Filename: filename,
+		Hash:     digest,
+	}, nil
+}
+
+// Precondition describes the condition of a conditional write.
+// Zero value means the write is unconditional.
+type Precondition struct {
+	// IfMatch makes the write succeed only if the current object ETag
+	// matches the value.
+	IfMatch string
+
+	// IfNoneMatch makes the write succeed only if the current object ETag
+	// does not match the value. Use "*" to write only if the object
+	// does not exist.
+	IfNoneMatch string
+}
+
+// IsZero returns true if the precondition is empty.
+func (p Precondition) IsZero() bool {
+	return p.IfMatch == "" && p.IfNoneMatch == ""
+}
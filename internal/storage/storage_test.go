@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraverseOptions_IsChartKey(t *testing.T) {
+	testCases := map[string]struct {
+		key       string
+		flat      bool
+		recursive bool
+	}{
+		"chart":               {key: "foo-1.0.0.tgz", flat: true, recursive: true},
+		"index":               {key: "index.yaml", flat: false, recursive: false},
+		"provenance":          {key: "foo-1.0.0.tgz.prov", flat: false, recursive: false},
+		"nested chart":        {key: "foo/foo-1.0.0.tgz", flat: false, recursive: true},
+		"deeply nested chart": {key: "team/foo/foo-1.0.0.tgz", flat: false, recursive: true},
+		"hidden directory":    {key: ".trash/foo-1.0.0.tgz", flat: false, recursive: false},
+		"empty directory":     {key: "foo//foo-1.0.0.tgz", flat: false, recursive: false},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.flat, TraverseOptions{}.IsChartKey(tc.key))
+			assert.Equal(t, tc.recursive, TraverseOptions{Recursive: true}.IsChartKey(tc.key))
+		})
+	}
+}
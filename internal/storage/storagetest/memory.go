@@ -0,0 +1,1058 @@
+// Package storagetest provides utilities for testing code that works with
+// chart repository storage.
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/hypnoglow/helm-s3/internal/helmutil"
+	"github.com/hypnoglow/helm-s3/internal/storage"
+)
+
+// Scheme is the URI scheme of the in-memory storage.
+const Scheme = "mem"
+
+// Op is a storage operation.
+type Op string
+
+// Storage operations.
+const (
+	OpTraverse    Op = "Traverse"
+	OpLoadChart   Op = "LoadChart"
+	OpFetchRaw    Op = "FetchRaw"
+	OpExists      Op = "Exists"
+	OpChartDigest Op = "ChartDigest"
+	OpPutChart    Op = "PutChart"
+	OpPutIndex    Op = "PutIndex"
+	OpPutRaw      Op = "PutRaw"
+	OpIndexExists Op = "IndexExists"
+	OpCopyChart   Op = "CopyChart"
+	OpDelete      Op = "Delete"
+	OpDeleteChart Op = "DeleteChart"
+	OpTryLock     Op = "TryLock"
+	OpUnlock      Op = "Unlock"
+	OpRenewLock   Op = "RenewLock"
+	OpForceUnlock Op = "ForceUnlock"
+	OpLockStatus  Op = "LockStatus"
+
+	OpObjectVersions       Op = "ObjectVersions"
+	OpRestoreObjectVersion Op = "RestoreObjectVersion"
+
+	OpIndexGenerations     Op = "IndexGenerations"
+	OpFetchIndexGeneration Op = "FetchIndexGeneration"
+)
+
+// Call is a record of a storage operation call.
+type Call struct {
+	Op  Op
+	URI string
+}
+
+// Hook is called before every storage operation. If it returns an error,
+// the operation fails with this error without taking effect.
+type Hook func(op Op, uri string) error
+
+// Object is an object stored in the in-memory storage.
+type Object struct {
+	Data         []byte
+	ETag         string
+	Metadata     map[string]string
+	LastModified time.Time
+
+	// VersionID is the version of the object, set if versioning is enabled.
+	VersionID string
+}
+
+// Object metadata keys, the same as used by S3 storage.
+const (
+	MetaChartMetadata = "chart-metadata"
+	MetaChartDigest   = "chart-digest"
+	MetaChartCreated  = "chart-created"
+)
+
+var (
+	memoriesMu sync.Mutex
+	memories   = make(map[string]*Memory)
+	memorySeq  atomic.Int64
+)
+
+func init() {
+	storage.Register(Scheme, func(uri string) (storage.Storage, error) {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, err
+		}
+
+		memoriesMu.Lock()
+		defer memoriesMu.Unlock()
+
+		m, ok := memories[u.Host]
+		if !ok {
+			return nil, storage.ErrBucketNotFound
+		}
+		return m, nil
+	})
+}
+
+// Interface guards.
+var (
+	_ storage.Storage      = (*Memory)(nil)
+	_ storage.Locker       = (*Memory)(nil)
+	_ storage.Versioner    = (*Memory)(nil)
+	_ storage.IndexHistory = (*Memory)(nil)
+)
+
+// Memory is an in-memory storage that mimics S3 semantics, including ETags
+// and conditional writes. It is available via storage.New for URIs returned
+// by URL until the test completes.
+type Memory struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string]Object
+	calls   []Call
+	hook    Hook
+	latency time.Duration
+	etagSeq int
+
+	// versions are all versions of objects by key, oldest first, kept if
+	// versioning is enabled. Delete markers have nil data.
+	versioning bool
+	versions   map[string][]objectVersion
+}
+
+// objectVersion is a version of an object kept by the in-memory storage.
+type objectVersion struct {
+	Object
+	deleteMarker bool
+}
+
+// NewMemory returns a new empty in-memory storage. The storage is registered
+// under a unique bucket name and unregistered when the test completes.
+func NewMemory(t testing.TB) *Memory {
+	t.Helper()
+
+	m := &Memory{
+		bucket:   "bucket-" + strconv.FormatInt(memorySeq.Add(1), 10),
+		objects:  make(map[string]Object),
+		versions: make(map[string][]objectVersion),
+	}
+
+	memoriesMu.Lock()
+	memories[m.bucket] = m
+	memoriesMu.Unlock()
+
+	t.Cleanup(func() {
+		memoriesMu.Lock()
+		delete(memories, m.bucket)
+		memoriesMu.Unlock()
+	})
+
+	return m
+}
+
+// URL returns the URL of the storage bucket root, e.g. mem://bucket-1.
+func (m *Memory) URL() string {
+	return Scheme + "://" + m.bucket
+}
+
+// SetHook sets the hook called before every operation.
+func (m *Memory) SetHook(hook Hook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hook = hook
+}
+
+// FailOn makes every call of the operation fail with the error.
+func (m *Memory) FailOn(op Op, err error) {
+	m.SetHook(func(o Op, _ string) error {
+		if o == op {
+			return err
+		}
+		return nil
+	})
+}
+
+// EnableVersioning makes the storage keep all versions of objects written
+// from now on, like an S3 bucket with versioning enabled.
+func (m *Memory) EnableVersioning() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.versioning = true
+}
+
+// SetLatency sets the latency added to every operation.
+func (m *Memory) SetLatency(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latency = d
+}
+
+// Calls returns all recorded operation calls in the order they were made.
+func (m *Memory) Calls() []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Call(nil), m.calls...)
+}
+
+// Put stores the object by uri unconditionally, bypassing hooks.
+func (m *Memory) Put(uri string, data []byte, metadata map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.put(mustKey(uri), data, metadata)
+}
+
+// Get returns the object by uri, bypassing hooks.
+func (m *Memory) Get(uri string) (Object, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj, ok := m.objects[mustKey(uri)]
+	return obj, ok
+}
+
+// Keys returns sorted keys of all stored objects.
+func (m *Memory) Keys() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]string, 0, len(m.objects))
+	for k := range m.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Traverse traverses all charts in the repository. Every chart is loaded
+// as a separate OpLoadChart operation, like S3 storage does with HEAD
+// requests, concurrently according to opts.Concurrency.
+func (m *Memory) Traverse(ctx context.Context, repoURI string, opts storage.TraverseOptions) (<-chan storage.ChartInfo, <-chan error) {
+	charts := make(chan storage.ChartInfo, 1)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(charts)
+		defer close(errs)
+
+		if err := m.before(ctx, OpTraverse, repoURI); err != nil {
+			errs <- err
+			return
+		}
+
+		prefix, err := m.key(repoURI)
+		if err != nil {
+			errs <- err
+			return
+		}
+		if prefix != "" {
+			prefix = strings.TrimSuffix(prefix, "/") + "/"
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		loads := make(chan storage.LoadFunc)
+		go func() {
+			defer close(loads)
+
+			for _, key := range m.Keys() {
+				name := strings.TrimPrefix(key, prefix)
+				if !strings.HasPrefix(key, prefix) || !opts.IsChartKey(name) {
+					continue
+				}
+
+				m.mu.Lock()
+				listed, ok := m.objects[key]
+				m.mu.Unlock()
+				if !ok {
+					continue
+				}
+
+				info := storage.ChartInfo{
+					Filename:     name,
+					Size:         int64(len(listed.Data)),
+					LastModified: listed.LastModified,
+				}
+
+				uri := Scheme + "://" + m.bucket + "/" + key
+				load := func(ctx context.Context) (storage.ChartInfo, error) {
+					if err := m.before(ctx, OpLoadChart, uri); err != nil {
+						return storage.ChartInfo{}, err
+					}
+
+					m.mu.Lock()
+					obj, ok := m.objects[key]
+					m.mu.Unlock()
+					if !ok {
+						return storage.ChartInfo{}, storage.ErrObjectNotFound
+					}
+					loaded, err := chartInfo(name, obj)
+					loaded.Size, loaded.LastModified = info.Size, info.LastModified
+					return loaded, err
+				}
+				if opts.Skip != nil && opts.Skip(info) {
+					load = func(ctx context.Context) (storage.ChartInfo, error) {
+						return info, nil
+					}
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case loads <- load:
+				}
+			}
+		}()
+
+		if err := storage.LoadOrdered(ctx, opts.Concurrency, loads, charts); err != nil {
+			errs <- err
+		}
+	}()
+
+	return charts, errs
+}
+
+// FetchRaw returns the object data by uri along with its ETag.
+func (m *Memory) FetchRaw(ctx context.Context, uri string) ([]byte, string, error) {
+	if err := m.before(ctx, OpFetchRaw, uri); err != nil {
+		return nil, "", err
+	}
+
+	key, err := m.key(uri)
+	if err != nil {
+		return nil, "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj, ok := m.objects[key]
+	if !ok {
+		return nil, "", storage.ErrObjectNotFound
+	}
+	return bytes.Clone(obj.Data), obj.ETag, nil
+}
+
+// Exists returns true if an object exists by uri.
+func (m *Memory) Exists(ctx context.Context, uri string) (bool, error) {
+	if err := m.before(ctx, OpExists, uri); err != nil {
+		return false, err
+	}
+	return m.exists(uri)
+}
+
+// ChartDigest returns the chart digest recorded in the object metadata.
+func (m *Memory) ChartDigest(ctx context.Context, uri string) (string, error) {
+	if err := m.before(ctx, OpChartDigest, uri); err != nil {
+		return "", err
+	}
+
+	key, err := m.key(uri)
+	if err != nil {
+		return "", err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj, ok := m.objects[key]
+	if !ok {
+		return "", storage.ErrObjectNotFound
+	}
+	return obj.Metadata[MetaChartDigest], nil
+}
+
+// PutChart stores the chart object along with its metadata, and the
+// provenance object if prov is true.
+func (m *Memory) PutChart(
+	ctx context.Context,
+	uri string,
+	r io.Reader,
+	chartMeta, acl string,
+	chartDigest string,
+	contentType string,
+	prov bool,
+	provReader io.Reader,
+) (string, error) {
+	if err := m.before(ctx, OpPutChart, uri); err != nil {
+		return "", err
+	}
+
+	key, err := m.key(uri)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	var provData []byte
+	if prov {
+		provData, err = io.ReadAll(provReader)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.put(key, data, map[string]string{
+		MetaChartMetadata: chartMeta,
+		MetaChartDigest:   chartDigest,
+		MetaChartCreated:  time.Now().UTC().Format(time.RFC3339),
+	})
+	if prov {
+		m.put(key+".prov", provData, nil)
+	}
+
+	return uri, nil
+}
+
+// PutIndex stores the index object for repository with the provided uri,
+// honoring the precondition.
+func (m *Memory) PutIndex(ctx context.Context, uri string, acl string, r io.Reader, cond storage.Precondition) error {
+	if err := m.before(ctx, OpPutIndex, uri); err != nil {
+		return err
+	}
+
+	key, err := m.key(helmutil.IndexFileURL(uri))
+	if err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(key, cond); err != nil {
+		return err
+	}
+	m.put(key, data, nil)
+	return nil
+}
+
+// PutRaw stores the object by uri, honoring the precondition.
+func (m *Memory) PutRaw(ctx context.Context, uri string, acl string, r io.Reader, cond storage.Precondition) error {
+	if err := m.before(ctx, OpPutRaw, uri); err != nil {
+		return err
+	}
+
+	key, err := m.key(uri)
+	if err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(key, cond); err != nil {
+		return err
+	}
+	m.put(key, data, nil)
+	return nil
+}
+
+// IndexExists returns true if the index object exists for repository with
+// the provided uri.
+func (m *Memory) IndexExists(ctx context.Context, uri string) (bool, error) {
+	if err := m.before(ctx, OpIndexExists, uri); err != nil {
+		return false, err
+	}
+	return m.exists(helmutil.IndexFileURL(uri))
+}
+
+// CopyChart copies the chart object along with its metadata, and its
+// provenance object if exists. The source may be in another registered
+// in-memory storage.
+func (m *Memory) CopyChart(ctx context.Context, srcURI, dstURI string, acl string) error {
+	if err := m.before(ctx, OpCopyChart, dstURI); err != nil {
+		return err
+	}
+
+	key, err := m.key(dstURI)
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(srcURI)
+	if err != nil {
+		return errors.Wrapf(err, "parse uri %s", srcURI)
+	}
+	memoriesMu.Lock()
+	src, ok := memories[u.Host]
+	memoriesMu.Unlock()
+	if !ok {
+		return storage.ErrBucketNotFound
+	}
+	srcKey, err := src.key(srcURI)
+	if err != nil {
+		return err
+	}
+
+	src.mu.Lock()
+	obj, ok := src.objects[srcKey]
+	prov, hasProv := src.objects[srcKey+".prov"]
+	src.mu.Unlock()
+	if !ok {
+		return storage.ErrObjectNotFound
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.put(key, obj.Data, maps.Clone(obj.Metadata))
+	if hasProv {
+		m.put(key+".prov", prov.Data, maps.Clone(prov.Metadata))
+	}
+	return nil
+}
+
+// Delete deletes the object. Deleting an object that does not exist is not
+// an error.
+func (m *Memory) Delete(ctx context.Context, uri string) error {
+	if err := m.before(ctx, OpDelete, uri); err != nil {
+		return err
+	}
+
+	key, err := m.key(uri)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(key)
+	return nil
+}
+
+// DeleteChart deletes the chart object and its provenance object.
+func (m *Memory) DeleteChart(ctx context.Context, uri string) error {
+	if err := m.before(ctx, OpDeleteChart, uri); err != nil {
+		return err
+	}
+
+	key, err := m.key(uri)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(key)
+	m.remove(key + ".prov")
+	return nil
+}
+
+// ObjectVersions returns versions of the object by uri, newest first.
+func (m *Memory) ObjectVersions(ctx context.Context, uri string) ([]storage.ObjectVersion, error) {
+	if err := m.before(ctx, OpObjectVersions, uri); err != nil {
+		return nil, err
+	}
+
+	key, err := m.key(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.versioning {
+		return nil, storage.ErrVersioningDisabled
+	}
+
+	stored := m.versions[key]
+	versions := make([]storage.ObjectVersion, 0, len(stored))
+	for i := len(stored) - 1; i >= 0; i-- {
+		v := stored[i]
+		versions = append(versions, storage.ObjectVersion{
+			VersionID:    v.VersionID,
+			LastModified: v.LastModified,
+			Size:         int64(len(v.Data)),
+			ETag:         v.ETag,
+			IsLatest:     i == len(stored)-1,
+			DeleteMarker: v.deleteMarker,
+		})
+	}
+	return versions, nil
+}
+
+// RestoreObjectVersion makes the version of the object by uri current.
+func (m *Memory) RestoreObjectVersion(ctx context.Context, uri, versionID, acl string) error {
+	if err := m.before(ctx, OpRestoreObjectVersion, uri); err != nil {
+		return err
+	}
+
+	key, err := m.key(uri)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, v := range m.versions[key] {
+		if v.VersionID != versionID {
+			continue
+		}
+		if v.deleteMarker {
+			return storage.ErrObjectNotFound
+		}
+		m.put(key, v.Data, maps.Clone(v.Metadata))
+		return nil
+	}
+	return storage.ErrObjectNotFound
+}
+
+// PutIndexKeeping stores the index object like PutIndex, keeping up to keep
+// previous generations: as versions of the index object if versioning is
+// enabled, and as backup objects otherwise. Like in S3 storage, only backup
+// objects are removed; versions are kept.
+func (m *Memory) PutIndexKeeping(ctx context.Context, uri string, acl string, r io.Reader, cond storage.Precondition, keep int) error {
+	if keep < 1 {
+		return m.PutIndex(ctx, uri, acl, r, cond)
+	}
+
+	key, err := m.key(helmutil.IndexFileURL(uri))
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	prev, hasPrev := m.objects[key]
+	versioning := m.versioning
+	m.mu.Unlock()
+
+	if err := m.PutIndex(ctx, uri, acl, r, cond); err != nil {
+		return err
+	}
+
+	if !versioning && hasPrev {
+		if err := storage.PutIndexBackup(ctx, m, uri, acl, prev.Data, time.Now()); err != nil {
+			return fmt.Errorf("%w: %w", storage.ErrIndexGenerationsNotKept, err)
+		}
+	}
+
+	generations, err := m.IndexGenerations(ctx, uri)
+	if err != nil {
+		return fmt.Errorf("%w: %w", storage.ErrIndexGenerationsNotKept, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, g := range storage.ExpiredIndexGenerations(storage.IndexBackups(generations), keep) {
+		backupURI, _ := storage.IndexGenerationURI(uri, g.ID)
+		m.remove(mustKey(backupURI))
+	}
+	return nil
+}
+
+// IndexGenerations returns versions of the index object if versioning is
+// enabled, and backup objects of the index, newest first.
+func (m *Memory) IndexGenerations(ctx context.Context, uri string) ([]storage.IndexGeneration, error) {
+	if err := m.before(ctx, OpIndexGenerations, uri); err != nil {
+		return nil, err
+	}
+
+	key, err := m.key(helmutil.IndexFileURL(uri))
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.indexGenerations(key), nil
+}
+
+// FetchIndexGeneration returns the generation of the index by id.
+func (m *Memory) FetchIndexGeneration(ctx context.Context, uri, id string) ([]byte, error) {
+	if err := m.before(ctx, OpFetchIndexGeneration, uri); err != nil {
+		return nil, err
+	}
+
+	key, err := m.key(helmutil.IndexFileURL(uri))
+	if err != nil {
+		return nil, err
+	}
+	genURI, isObject := storage.IndexGenerationURI(uri, id)
+	if isObject {
+		if key, err = m.key(genURI); err != nil {
+			return nil, err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if isObject {
+		obj, ok := m.objects[key]
+		if !ok {
+			return nil, storage.ErrObjectNotFound
+		}
+		return bytes.Clone(obj.Data), nil
+	}
+	for _, v := range m.versions[key] {
+		if v.VersionID == id && !v.deleteMarker {
+			return bytes.Clone(v.Data), nil
+		}
+	}
+	return nil, storage.ErrObjectNotFound
+}
+
+// indexGenerations returns generations of the index object by key.
+// Must be called with m.mu held.
+func (m *Memory) indexGenerations(key string) []storage.IndexGeneration {
+	var generations []storage.IndexGeneration
+	if m.versioning {
+		stored := m.versions[key]
+		for i, v := range stored {
+			if v.deleteMarker {
+				continue
+			}
+			generations = append(generations, storage.IndexGeneration{
+				ID:           v.VersionID,
+				LastModified: v.LastModified,
+				Size:         int64(len(v.Data)),
+				Current:      i == len(stored)-1,
+			})
+		}
+	} else if obj, ok := m.objects[key]; ok {
+		generations = append(generations, storage.IndexGeneration{
+			ID:           storage.CurrentIndexGeneration,
+			LastModified: obj.LastModified,
+			Size:         int64(len(obj.Data)),
+			Current:      true,
+		})
+	}
+
+	prefix := strings.TrimSuffix(key, "index.yaml")
+	for k, obj := range m.objects {
+		name, ok := strings.CutPrefix(k, prefix)
+		if !ok {
+			continue
+		}
+		if id, t, ok := storage.ParseIndexBackupName(name); ok {
+			generations = append(generations, storage.IndexGeneration{
+				ID:           id,
+				LastModified: t,
+				Size:         int64(len(obj.Data)),
+			})
+		}
+	}
+
+	storage.SortIndexGenerations(generations)
+	return generations
+}
+
+// TryLock makes a single attempt to acquire the lock on the repository.
+func (m *Memory) TryLock(ctx context.Context, repoURI, acl string, lock storage.Lock) error {
+	if err := m.before(ctx, OpTryLock, repoURI); err != nil {
+		return err
+	}
+
+	key, err := m.key(lockFileURL(repoURI))
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if current, ok, err := m.lock(key); err != nil {
+		return err
+	} else if ok && current.Owner != lock.Owner && !current.Expired(time.Now()) {
+		return storage.ErrLockHeld
+	}
+
+	b, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	m.put(key, b, nil)
+	return nil
+}
+
+// Unlock releases the lock on the repository if it is owned by the owner
+// of the lock.
+func (m *Memory) Unlock(ctx context.Context, repoURI string, lock storage.Lock) error {
+	if err := m.before(ctx, OpUnlock, repoURI); err != nil {
+		return err
+	}
+
+	key, err := m.key(lockFileURL(repoURI))
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok, err := m.lock(key)
+	if err != nil || !ok {
+		return err
+	}
+	if current.Owner != lock.Owner {
+		return storage.ErrLockHeld
+	}
+	delete(m.objects, key)
+	return nil
+}
+
+// RenewLock extends the lease of the lock on the repository if it is owned
+// by the owner of the lock.
+func (m *Memory) RenewLock(ctx context.Context, repoURI, acl string, lock storage.Lock) error {
+	if err := m.before(ctx, OpRenewLock, repoURI); err != nil {
+		return err
+	}
+
+	key, err := m.key(lockFileURL(repoURI))
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok, err := m.lock(key)
+	if err != nil {
+		return err
+	}
+	if !ok || current.Owner != lock.Owner {
+		return storage.ErrLockHeld
+	}
+
+	b, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	m.put(key, b, nil)
+	return nil
+}
+
+// ForceUnlock releases the lock on the repository regardless of its owner.
+func (m *Memory) ForceUnlock(ctx context.Context, repoURI string) error {
+	if err := m.before(ctx, OpForceUnlock, repoURI); err != nil {
+		return err
+	}
+
+	key, err := m.key(lockFileURL(repoURI))
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.objects, key)
+	return nil
+}
+
+// LockStatus returns the current lock on the repository.
+func (m *Memory) LockStatus(ctx context.Context, repoURI string) (storage.Lock, error) {
+	if err := m.before(ctx, OpLockStatus, repoURI); err != nil {
+		return storage.Lock{}, err
+	}
+
+	key, err := m.key(lockFileURL(repoURI))
+	if err != nil {
+		return storage.Lock{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok, err := m.lock(key)
+	if err != nil {
+		return storage.Lock{}, err
+	}
+	if !ok {
+		return storage.Lock{}, storage.ErrObjectNotFound
+	}
+	return current, nil
+}
+
+// before records the call, applies the latency and calls the hook.
+func (m *Memory) before(ctx context.Context, op Op, uri string) error {
+	m.mu.Lock()
+	m.calls = append(m.calls, Call{Op: op, URI: uri})
+	hook, latency := m.hook, m.latency
+	m.mu.Unlock()
+
+	if latency > 0 {
+		t := time.NewTimer(latency)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+
+	if hook != nil {
+		return hook(op, uri)
+	}
+	return nil
+}
+
+func (m *Memory) exists(uri string) (bool, error) {
+	key, err := m.key(uri)
+	if err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.objects[key]
+	return ok, nil
+}
+
+// check checks the precondition against the current object by key.
+// Must be called with m.mu held.
+func (m *Memory) check(key string, cond storage.Precondition) error {
+	obj, ok := m.objects[key]
+	if cond.IfMatch != "" && (!ok || (cond.IfMatch != "*" && cond.IfMatch != obj.ETag)) {
+		return storage.ErrPreconditionFailed
+	}
+	if cond.IfNoneMatch != "" && ok && (cond.IfNoneMatch == "*" || cond.IfNoneMatch == obj.ETag) {
+		return storage.ErrPreconditionFailed
+	}
+	return nil
+}
+
+// put stores the object with a new ETag. Must be called with m.mu held.
+func (m *Memory) put(key string, data []byte, metadata map[string]string) {
+	m.etagSeq++
+	obj := Object{
+		Data:         bytes.Clone(data),
+		ETag:         fmt.Sprintf("%q", strconv.Itoa(m.etagSeq)),
+		Metadata:     metadata,
+		LastModified: time.Now(),
+	}
+	if m.versioning {
+		obj.VersionID = "v" + strconv.Itoa(m.etagSeq)
+		m.versions[key] = append(m.versions[key], objectVersion{Object: obj})
+	}
+	m.objects[key] = obj
+}
+
+// remove deletes the object by key, leaving a delete marker if versioning is
+// enabled. Must be called with m.mu held.
+func (m *Memory) remove(key string) {
+	if _, ok := m.objects[key]; !ok {
+		return
+	}
+	delete(m.objects, key)
+
+	if m.versioning {
+		m.etagSeq++
+		m.versions[key] = append(m.versions[key], objectVersion{
+			Object: Object{
+				VersionID:    "v" + strconv.Itoa(m.etagSeq),
+				LastModified: time.Now(),
+			},
+			deleteMarker: true,
+		})
+	}
+}
+
+// lock returns the lock stored by key. Must be called with m.mu held.
+func (m *Memory) lock(key string) (storage.Lock, bool, error) {
+	obj, ok := m.objects[key]
+	if !ok {
+		return storage.Lock{}, false, nil
+	}
+
+	var lock storage.Lock
+	if err := json.Unmarshal(obj.Data, &lock); err != nil {
+		return storage.Lock{}, false, errors.Wrap(err, "unmarshal lock")
+	}
+	return lock, true, nil
+}
+
+// key returns object key from URIs like mem://bucket/key, checking that the
+// URI refers to this storage bucket.
+func (m *Memory) key(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", errors.Wrapf(err, "parse uri %s", uri)
+	}
+	if u.Scheme != Scheme {
+		return "", fmt.Errorf("uri %s protocol is not %s", uri, Scheme)
+	}
+	if u.Host != m.bucket {
+		return "", storage.ErrBucketNotFound
+	}
+	return strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// chartInfo returns info about the chart stored in the object, preferring
+// the object metadata like S3 storage does.
+func chartInfo(filename string, obj Object) (storage.ChartInfo, error) {
+	created, _ := time.Parse(time.RFC3339, obj.Metadata[MetaChartCreated])
+
+	serializedMeta, hasMeta := obj.Metadata[MetaChartMetadata]
+	digest, hasDigest := obj.Metadata[MetaChartDigest]
+	if !hasMeta || !hasDigest {
+		info, err := storage.LoadChartInfo(filename, bytes.NewReader(obj.Data))
+		info.Created = created
+		info.Downloaded = true
+		return info, err
+	}
+
+	meta := helmutil.NewChartMetadata()
+	if err := meta.UnmarshalJSON([]byte(serializedMeta)); err != nil {
+		return storage.ChartInfo{}, fmt.Errorf("unserialize chart meta for %q: %s", filename, err)
+	}
+
+	return storage.ChartInfo{
+		Meta:     meta,
+		Filename: filename,
+		Hash:     digest,
+		Created:  created,
+	}, nil
+}
+
+// mustKey returns object key from URIs like mem://bucket/key.
+func mustKey(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		panic(err)
+	}
+	return strings.TrimPrefix(u.Path, "/")
+}
+
+// lockFileURL returns lock file URL for the provided repository URL.
+func lockFileURL(repoURI string) string {
+	return strings.TrimSuffix(repoURI, "/") + "/index.yaml.lock"
+}
@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrVersioningDisabled signals that the storage does not keep previous
+// versions of objects, e.g. versioning is not enabled for the S3 bucket.
+var ErrVersioningDisabled = errors.New("object versioning is not enabled")
+
+// Versioner is implemented by storages that can keep previous versions of
+// objects, e.g. S3 buckets with versioning enabled.
+type Versioner interface {
+	// ObjectVersions returns versions of the object by uri, including delete
+	// markers, newest first. If the object never existed, returns an empty
+	// list. If versioning is not enabled, returns ErrVersioningDisabled.
+	ObjectVersions(ctx context.Context, uri string) ([]ObjectVersion, error)
+
+	// RestoreObjectVersion makes the version of the object by uri current by
+	// copying it over the object along with its metadata. All versions,
+	// including the replaced one, are kept. If the version does not exist or
+	// is a delete marker, returns ErrObjectNotFound.
+	RestoreObjectVersion(ctx context.Context, uri, versionID, acl string) error
+}
+
+// ObjectVersion describes a version of an object.
+type ObjectVersion struct {
+	VersionID    string    `json:"versionID"`
+	LastModified time.Time `json:"lastModified"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag,omitempty"`
+
+	// IsLatest is true for the current version of the object.
+	IsLatest bool `json:"isLatest"`
+
+	// DeleteMarker is true if the version marks the object as deleted.
+	DeleteMarker bool `json:"deleteMarker"`
+}